@@ -0,0 +1,272 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AsyncPolicy is what an AsyncLogger does with a message when its queue is
+// full, and with an error when its error channel is full.
+type AsyncPolicy int
+
+const (
+	AsyncDrop   AsyncPolicy = iota // Discard it and count it in Dropped.
+	AsyncBlock                     // Wait for room.
+	AsyncStderr                    // Write it to Stderr.
+)
+
+// ErrFlushTimeout is returned by Flush when the queue was not written in
+// time.
+var ErrFlushTimeout = errors.New("log flush timed out")
+
+// AsyncConfig configures an AsyncLogger.
+type AsyncConfig struct {
+	// QueueSize is the number of messages waiting to be written, 1000 if
+	// zero.
+	QueueSize int
+	// Policy applies when the queue or the error channel is full.
+	Policy AsyncPolicy
+	// Stderr receives the overflow of AsyncStderr, os.Stderr if nil.
+	Stderr io.Writer
+}
+
+// AsyncLogger is a Logger writing to another Logger in the background, so a
+// slow or unreachable system log does not stall the service. Errors of the
+// wrapped Logger are sent on errs by the same policy; create the wrapped
+// Logger with a nil errs.
+//
+// Run flushes the open AsyncLoggers once the service is stopped, so the last
+// messages are written, as does Exit of CommandLine for MainOptions.Async.
+// Flush or Close the AsyncLogger to do it elsewhere.
+type AsyncLogger struct {
+	logger Logger
+	c      AsyncConfig
+	errs   chan<- error
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []asyncEntry
+	busy    bool // an entry is being written
+	dropped uint64
+	closed  bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+type asyncEntry struct {
+	level Level
+	msg   string
+}
+
+// NewAsyncLogger returns an AsyncLogger writing to l. If errs is non-nil the
+// errors of l are sent on errs.
+func NewAsyncLogger(l Logger, c AsyncConfig, errs chan<- error) *AsyncLogger {
+	if c.QueueSize <= 0 {
+		c.QueueSize = 1000
+	}
+	if c.Stderr == nil {
+		c.Stderr = os.Stderr
+	}
+	a := &AsyncLogger{logger: l, c: c, errs: errs, done: make(chan struct{})}
+	a.cond = sync.NewCond(&a.mu)
+	go a.run()
+	trackFlusher(a)
+	return a
+}
+
+// run writes the queued entries until closed and drained.
+func (l *AsyncLogger) run() {
+	defer close(l.done)
+	for {
+		l.mu.Lock()
+		for len(l.queue) == 0 && !l.closed {
+			l.cond.Wait()
+		}
+		if len(l.queue) == 0 {
+			l.mu.Unlock()
+			return
+		}
+		e := l.queue[0]
+		l.queue = l.queue[1:]
+		l.busy = true
+		l.cond.Broadcast()
+		l.mu.Unlock()
+
+		l.send(e.write(l.logger))
+
+		l.mu.Lock()
+		l.busy = false
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	}
+}
+
+func (e asyncEntry) write(l Logger) error {
+	switch e.level {
+	case LevelError:
+		return l.Error(e.msg)
+	case LevelWarning:
+		return l.Warning(e.msg)
+	case LevelDebug:
+		return Debug(l, e.msg)
+	}
+	return l.Info(e.msg)
+}
+
+// send passes err on errs by the policy.
+func (l *AsyncLogger) send(err error) {
+	if err == nil || l.errs == nil {
+		return
+	}
+	if l.c.Policy == AsyncBlock {
+		l.errs <- err
+		return
+	}
+	select {
+	case l.errs <- err:
+	default:
+		if l.c.Policy == AsyncStderr {
+			fmt.Fprintln(l.c.Stderr, "log error:", err)
+		}
+	}
+}
+
+func (l *AsyncLogger) log(level Level, msg string) error {
+	if ll, ok := l.logger.(LevelLogger); ok && level < ll.Level() {
+		return nil
+	}
+	if _, ok := l.logger.(DebugLogger); level == LevelDebug && !ok {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for !l.closed && len(l.queue) >= l.c.QueueSize {
+		switch l.c.Policy {
+		case AsyncBlock:
+			l.cond.Wait()
+			continue
+		case AsyncStderr:
+			_, err := fmt.Fprintf(l.c.Stderr, "%s %s %s\n", time.Now().Format(time.RFC3339), levelPrefix(level), msg)
+			return err
+		}
+		l.dropped++
+		return nil
+	}
+	if l.closed {
+		return os.ErrClosed
+	}
+	l.queue = append(l.queue, asyncEntry{level, msg})
+	l.cond.Broadcast()
+	return nil
+}
+
+func levelPrefix(level Level) string {
+	switch level {
+	case LevelError:
+		return "E:"
+	case LevelWarning:
+		return "W:"
+	case LevelDebug:
+		return "D:"
+	}
+	return "I:"
+}
+
+// Dropped returns the number of messages discarded by AsyncDrop.
+func (l *AsyncLogger) Dropped() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped
+}
+
+// Flush waits until the queued messages are written, for at most timeout if
+// it is positive.
+func (l *AsyncLogger) Flush(timeout time.Duration) error {
+	expired := false
+	if timeout > 0 {
+		t := time.AfterFunc(timeout, func() {
+			l.mu.Lock()
+			expired = true
+			l.cond.Broadcast()
+			l.mu.Unlock()
+		})
+		defer t.Stop()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for (len(l.queue) > 0 || l.busy) && !expired {
+		l.cond.Wait()
+	}
+	if len(l.queue) > 0 || l.busy {
+		return ErrFlushTimeout
+	}
+	return nil
+}
+
+// Close writes the queued messages and closes the wrapped Logger if it is an
+// io.Closer. Messages logged after Close return os.ErrClosed.
+func (l *AsyncLogger) Close() error {
+	var err error
+	l.once.Do(func() {
+		untrackFlusher(l)
+		l.mu.Lock()
+		l.closed = true
+		l.cond.Broadcast()
+		l.mu.Unlock()
+		<-l.done
+		if c, ok := l.logger.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+	return err
+}
+
+func (l *AsyncLogger) writesToStd() bool {
+	return writesToStd(l.logger) || l.c.Policy == AsyncStderr && isStdStream(l.c.Stderr)
+}
+
+// Level returns the level of the wrapped Logger, LevelInfo if it has none.
+func (l *AsyncLogger) Level() Level {
+	if ll, ok := l.logger.(LevelLogger); ok {
+		return ll.Level()
+	}
+	return LevelInfo
+}
+
+// SetLevel sets the level of the wrapped Logger if it is a LevelLogger.
+func (l *AsyncLogger) SetLevel(level Level) {
+	SetLevel(l.logger, level)
+}
+
+func (l *AsyncLogger) Error(v ...interface{}) error {
+	return l.log(LevelError, fmt.Sprint(v...))
+}
+func (l *AsyncLogger) Warning(v ...interface{}) error {
+	return l.log(LevelWarning, fmt.Sprint(v...))
+}
+func (l *AsyncLogger) Info(v ...interface{}) error {
+	return l.log(LevelInfo, fmt.Sprint(v...))
+}
+func (l *AsyncLogger) Debug(v ...interface{}) error {
+	return l.log(LevelDebug, fmt.Sprint(v...))
+}
+func (l *AsyncLogger) Errorf(format string, a ...interface{}) error {
+	return l.log(LevelError, fmt.Sprintf(format, a...))
+}
+func (l *AsyncLogger) Warningf(format string, a ...interface{}) error {
+	return l.log(LevelWarning, fmt.Sprintf(format, a...))
+}
+func (l *AsyncLogger) Infof(format string, a ...interface{}) error {
+	return l.log(LevelInfo, fmt.Sprintf(format, a...))
+}
+func (l *AsyncLogger) Debugf(format string, a ...interface{}) error {
+	return l.log(LevelDebug, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,230 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gateLogger records messages, waiting for gate before each write when it
+// is set.
+type gateLogger struct {
+	gate    chan struct{}
+	entered chan struct{}
+	err     error
+
+	mu     sync.Mutex
+	lines  []string
+	level  Level
+	closed bool
+}
+
+func (g *gateLogger) write(prefix, msg string) error {
+	if g.gate != nil {
+		g.entered <- struct{}{}
+		<-g.gate
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lines = append(g.lines, prefix+msg)
+	return g.err
+}
+
+func (g *gateLogger) got() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.lines...)
+}
+
+func (g *gateLogger) Error(v ...interface{}) error   { return g.write("E:", fmt.Sprint(v...)) }
+func (g *gateLogger) Warning(v ...interface{}) error { return g.write("W:", fmt.Sprint(v...)) }
+func (g *gateLogger) Info(v ...interface{}) error    { return g.write("I:", fmt.Sprint(v...)) }
+func (g *gateLogger) Debug(v ...interface{}) error   { return g.write("D:", fmt.Sprint(v...)) }
+func (g *gateLogger) Errorf(format string, a ...interface{}) error {
+	return g.Error(fmt.Sprintf(format, a...))
+}
+func (g *gateLogger) Warningf(format string, a ...interface{}) error {
+	return g.Warning(fmt.Sprintf(format, a...))
+}
+func (g *gateLogger) Infof(format string, a ...interface{}) error {
+	return g.Info(fmt.Sprintf(format, a...))
+}
+func (g *gateLogger) Debugf(format string, a ...interface{}) error {
+	return g.Debug(fmt.Sprintf(format, a...))
+}
+func (g *gateLogger) Level() Level         { g.mu.Lock(); defer g.mu.Unlock(); return g.level }
+func (g *gateLogger) SetLevel(level Level) { g.mu.Lock(); defer g.mu.Unlock(); g.level = level }
+func (g *gateLogger) Close() error         { g.closed = true; return nil }
+
+func newGateLogger() *gateLogger {
+	return &gateLogger{gate: make(chan struct{}), entered: make(chan struct{}, 100)}
+}
+
+// hold logs "first" and waits until the writer is blocked writing it.
+func hold(t *testing.T, a *AsyncLogger, g *gateLogger) {
+	t.Helper()
+	a.Info("first")
+	select {
+	case <-g.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first message was not written")
+	}
+}
+
+func TestAsyncLogger(t *testing.T) {
+	g := &gateLogger{}
+	a := NewAsyncLogger(g, AsyncConfig{}, nil)
+	a.Info("one")
+	a.Warningf("t%s", "wo")
+	a.Error("three")
+	a.Debug("hidden")
+	a.SetLevel(LevelDebug)
+	a.Debugf("four")
+	if err := a.Flush(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"I:one", "W:two", "E:three", "D:four"}
+	if got := g.got(); !reflect.DeepEqual(got, want) {
+		t.Errorf("written %q, want %q", got, want)
+	}
+	if err := a.Close(); err != nil || !g.closed {
+		t.Errorf("Close() = %v, closed the logger: %v", err, g.closed)
+	}
+	if err := a.Info("late"); err != os.ErrClosed {
+		t.Errorf("Info after Close = %v, want os.ErrClosed", err)
+	}
+}
+
+func TestAsyncLoggerDrop(t *testing.T) {
+	g := newGateLogger()
+	a := NewAsyncLogger(g, AsyncConfig{QueueSize: 2}, nil)
+	hold(t, a, g)
+	for i := 0; i < 4; i++ {
+		a.Info(i)
+	}
+	if got := a.Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2", got)
+	}
+	close(g.gate)
+	a.Close()
+	if want := []string{"I:first", "I:0", "I:1"}; !reflect.DeepEqual(g.got(), want) {
+		t.Errorf("written %q, want %q", g.got(), want)
+	}
+}
+
+func TestAsyncLoggerStderr(t *testing.T) {
+	g := newGateLogger()
+	var stderr bytes.Buffer
+	a := NewAsyncLogger(g, AsyncConfig{QueueSize: 1, Policy: AsyncStderr, Stderr: &stderr}, nil)
+	hold(t, a, g)
+	a.Info("queued")
+	a.Error("overflow")
+	if !strings.HasSuffix(stderr.String(), " E: overflow\n") {
+		t.Errorf("stderr = %q, want the overflow", stderr.String())
+	}
+	close(g.gate)
+	a.Close()
+	if a.Dropped() != 0 {
+		t.Errorf("Dropped() = %d, want 0", a.Dropped())
+	}
+}
+
+func TestAsyncLoggerBlock(t *testing.T) {
+	g := newGateLogger()
+	a := NewAsyncLogger(g, AsyncConfig{QueueSize: 1, Policy: AsyncBlock}, nil)
+	hold(t, a, g)
+	a.Info("queued")
+	done := make(chan struct{})
+	go func() {
+		a.Info("blocked")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Info returned with a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := a.Flush(10 * time.Millisecond); err != ErrFlushTimeout {
+		t.Errorf("Flush() = %v, want ErrFlushTimeout", err)
+	}
+	close(g.gate)
+	<-done
+	a.Close()
+	if want := []string{"I:first", "I:queued", "I:blocked"}; !reflect.DeepEqual(g.got(), want) {
+		t.Errorf("written %q, want %q", g.got(), want)
+	}
+}
+
+func TestAsyncLoggerErrors(t *testing.T) {
+	g := &gateLogger{err: errors.New("syslog down")}
+	errs := make(chan error, 1)
+	var stderr bytes.Buffer
+	a := NewAsyncLogger(g, AsyncConfig{Policy: AsyncStderr, Stderr: &stderr}, errs)
+	a.Info("one")
+	a.Info("two")
+	a.Close()
+	if err := <-errs; err != g.err {
+		t.Errorf("errs received %v", err)
+	}
+	if got := stderr.String(); got != "log error: syslog down\n" {
+		t.Errorf("stderr = %q, want the error the channel had no room for", got)
+	}
+}
+
+func TestCommandLineAsync(t *testing.T) {
+	var code = -1
+	cl, err := NewCommandLine(nopInterface{}, &Config{Name: "asynctest"}, &MainOptions{
+		Async: &AsyncConfig{},
+		Exit:  func(c int) { code = c },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cl.Logger.(*AsyncLogger); !ok {
+		t.Fatalf("Logger is a %T, want an *AsyncLogger", cl.Logger)
+	}
+	cl.Exit(nil)
+	if code != 0 {
+		t.Errorf("Exit(nil) exited with %d", code)
+	}
+}
+
+// logOnStop logs "stopped" to l when stopped.
+type logOnStop struct{ l Logger }
+
+func (logOnStop) Start(s Service) error  { return nil }
+func (i logOnStop) Stop(s Service) error { return i.l.Info("stopped") }
+
+func TestAsyncLoggerRunFlush(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Run waits for an interrupt on windows")
+	}
+	g := newGateLogger()
+	a := NewAsyncLogger(g, AsyncConfig{}, nil)
+	defer a.Close()
+	s, err := New(logOnStop{a}, &Config{Name: "flushtest", Option: KeyValue{optionRunWait: func() {}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(g.gate)
+	}()
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := g.got(), []string{"I:stopped"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("written %q when Run returned, want %q", got, want)
+	}
+}
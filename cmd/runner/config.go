@@ -0,0 +1,218 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/kardianos/service/supervisor"
+)
+
+// Restart policies.
+const (
+	restartAlways    = "always"
+	restartOnFailure = "on-failure"
+	restartNever     = "never"
+)
+
+// Duration is a time.Duration written as a string such as "10s" in the
+// config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config is the runner config file.
+type Config struct {
+	// Service is how the runner is installed, as read by service.ParseConfig.
+	// Its Arguments are set by the runner.
+	Service json.RawMessage
+
+	// Exec is the command to run, looked up in PATH if not absolute.
+	Exec string
+	Args []string
+	// Dir is the working directory of the command.
+	Dir string
+	// Env holds NAME=value pairs added to the environment of the command.
+	Env []string
+	// EnvFile is read before each start of the command; its variables are
+	// added to Env. A missing file is ignored.
+	EnvFile string
+
+	// StopTimeout is how long the command has to exit after it is asked to
+	// before it is killed. 10s if zero.
+	StopTimeout Duration
+	// Restart is when the command is restarted after it exits: "always",
+	// "on-failure" or "never". Defaults to "always".
+	Restart string
+	// RestartDelay is the first wait before a restart, 1s if zero. It doubles
+	// after each restart up to MaxRestartDelay, 1m if zero, and is reset once
+	// the command has run for MaxRestartDelay.
+	RestartDelay    Duration
+	MaxRestartDelay Duration
+
+	// Stdout and Stderr are files the output of the command is appended to.
+	// Output is discarded if empty.
+	Stdout, Stderr string
+	// LogMaxSize is the size in bytes a log file is rotated at, 10 MiB if
+	// zero. LogMaxBackups rotated files are kept, 3 if zero.
+	LogMaxSize    int64
+	LogMaxBackups int
+}
+
+// loadConfig reads the runner config at path and the service config within.
+// SERVICE_* variables of the environment override the service config.
+func loadConfig(path string) (*Config, *service.Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	c := &Config{}
+	if err := d.Decode(c); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.setDefaults(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	sc := &service.Config{}
+	if len(c.Service) > 0 {
+		if sc, err = service.ParseConfig(c.Service, ".json"); err != nil {
+			return nil, nil, fmt.Errorf("%s: Service: %w", path, err)
+		}
+	}
+	if err := sc.ApplyEnv(os.Environ()); err != nil {
+		return nil, nil, err
+	}
+	if sc.Name == "" {
+		return nil, nil, fmt.Errorf("%s: Service.Name is required", path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc.Arguments = []string{"-config", abs, "run"}
+	return c, sc, nil
+}
+
+func (c *Config) setDefaults() error {
+	if c.Exec == "" {
+		return fmt.Errorf("Exec is required")
+	}
+	switch c.Restart {
+	case "":
+		c.Restart = restartAlways
+	case restartAlways, restartOnFailure, restartNever:
+	default:
+		return fmt.Errorf("Restart %q is not one of %s, %s, %s", c.Restart, restartAlways, restartOnFailure, restartNever)
+	}
+	if c.StopTimeout == 0 {
+		c.StopTimeout = Duration(10 * time.Second)
+	}
+	if c.RestartDelay == 0 {
+		c.RestartDelay = Duration(time.Second)
+	}
+	if c.MaxRestartDelay == 0 {
+		c.MaxRestartDelay = Duration(time.Minute)
+	}
+	if c.LogMaxSize == 0 {
+		c.LogMaxSize = 10 << 20
+	}
+	if c.LogMaxBackups == 0 {
+		c.LogMaxBackups = 3
+	}
+	return nil
+}
+
+// environ returns the environment of the command.
+func (c *Config) environ() ([]string, error) {
+	env := append(os.Environ(), c.Env...)
+	if c.EnvFile == "" {
+		return env, nil
+	}
+	b, err := ioutil.ReadFile(c.EnvFile)
+	if os.IsNotExist(err) {
+		return env, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vars, err := service.ParseEnvFile(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.EnvFile, err)
+	}
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	return env, nil
+}
+
+var restartPolicy = map[string]supervisor.Policy{
+	restartAlways:    supervisor.RestartAlways,
+	restartOnFailure: supervisor.RestartOnFailure,
+	restartNever:     supervisor.RestartNever,
+}
+
+// output opens the file at path the output of the command is appended to. It
+// is rotated at LogMaxSize, keeping LogMaxBackups files named path.<time>.
+func (c *Config) output(path string) (*service.FileLogger, error) {
+	return service.NewFileLogger(service.FileLoggerConfig{
+		Path:       path,
+		MaxSize:    c.LogMaxSize,
+		MaxBackups: c.LogMaxBackups,
+	}, nil)
+}
+
+// child returns the supervised child running the command, its output written
+// to stdout and stderr.
+func (c *Config) child(stdout, stderr io.Writer) supervisor.Child {
+	return supervisor.Child{
+		Name: filepath.Base(c.Exec),
+		Command: func() (*exec.Cmd, error) {
+			path, err := exec.LookPath(c.Exec)
+			if err != nil {
+				return nil, err
+			}
+			env, err := c.environ()
+			if err != nil {
+				return nil, err
+			}
+			cmd := exec.Command(path, c.Args...)
+			cmd.Dir = c.Dir
+			cmd.Env = env
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+			return cmd, nil
+		},
+		Restart:         restartPolicy[c.Restart],
+		RestartDelay:    time.Duration(c.RestartDelay),
+		MaxRestartDelay: time.Duration(c.MaxRestartDelay),
+	}
+}
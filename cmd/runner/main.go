@@ -0,0 +1,158 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+// Command runner runs any command as a service.
+//
+// Usage:
+//
+//	runner [-config file] [install|uninstall|start|stop|restart|status|run]
+//
+// The config file defaults to the path of the runner executable with a .json
+// extension. See runner.json for an example. Without a subcommand, or with
+// "run", the command is run until the service is stopped: it is asked to exit
+// with SIGTERM, or CTRL_BREAK_EVENT on Windows, and killed after StopTimeout.
+// It is restarted with a growing delay when it exits, as Restart says, and
+// its output is appended to the Stdout and Stderr files, which are rotated
+// by size.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/kardianos/service/supervisor"
+)
+
+type program struct {
+	c       *Config
+	service service.Service
+	logger  service.Logger
+
+	sup      *supervisor.Supervisor
+	stopping chan struct{} // closed by Stop
+	closer   []io.Closer
+}
+
+func (p *program) Start(s service.Service) error {
+	var stdout, stderr io.Writer = io.Discard, io.Discard
+	for _, out := range []struct {
+		path string
+		w    *io.Writer
+	}{{p.c.Stdout, &stdout}, {p.c.Stderr, &stderr}} {
+		if out.path == "" {
+			continue
+		}
+		w, err := p.c.output(out.path)
+		if err != nil {
+			p.close()
+			return err
+		}
+		*out.w = w
+		p.closer = append(p.closer, w)
+	}
+
+	sup, err := supervisor.New(p.c.child(stdout, stderr))
+	if err != nil {
+		p.close()
+		return err
+	}
+	sup.StopTimeout = time.Duration(p.c.StopTimeout)
+	sup.Logger = p.logger
+	if err := sup.Start(s); err != nil {
+		p.close()
+		return err
+	}
+	p.sup = sup
+	p.stopping = make(chan struct{})
+	go p.wait()
+	return nil
+}
+
+// wait ends the runner once the command is not restarted.
+func (p *program) wait() {
+	<-p.sup.Done()
+	select {
+	case <-p.stopping:
+		return
+	default:
+	}
+	if !service.Interactive() {
+		// Let the service manager stop the runner.
+		if err := p.service.Stop(); err != nil {
+			p.logger.Error(err)
+		}
+		return
+	}
+	p.close()
+	code := 0
+	var exitErr *exec.ExitError
+	if err := p.sup.Err(); errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		code = 1
+	}
+	os.Exit(code)
+}
+
+func (p *program) Stop(s service.Service) error {
+	p.logger.Infof("stopping %s", p.c.Exec)
+	close(p.stopping)
+	err := p.sup.Stop(s)
+	p.close()
+	return err
+}
+
+func (p *program) close() {
+	for _, c := range p.closer {
+		c.Close()
+	}
+	p.closer = nil
+}
+
+func defaultConfigPath() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return exe[:len(exe)-len(filepath.Ext(exe))] + ".json", nil
+}
+
+func main() {
+	configPath := flag.String("config", "", "Runner config file.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config file] [install|uninstall|start|stop|restart|status|run]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *configPath == "" {
+		p, err := defaultConfigPath()
+		if err != nil {
+			log.Fatal(err)
+		}
+		*configPath = p
+	}
+
+	c, sc, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	prg := &program{c: c}
+	cl, err := service.NewCommandLine(prg, sc, &service.MainOptions{
+		Logger: &prg.logger,
+		Errors: func(err error) { log.Print(err) },
+	})
+	if err != nil {
+		cl.Exit(err)
+	}
+	prg.service = cl.Service
+	cl.Exit(cl.Execute(flag.Args()))
+}
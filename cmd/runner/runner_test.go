@@ -0,0 +1,140 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kardianos/service/supervisor"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c, sc, err := loadConfig("runner.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Name != "builder" || c.Restart != restartOnFailure || time.Duration(c.StopTimeout) != 10*time.Second {
+		t.Errorf("loadConfig() = %+v, %+v", c, sc)
+	}
+	if len(sc.Arguments) != 3 || sc.Arguments[0] != "-config" || !filepath.IsAbs(sc.Arguments[1]) || sc.Arguments[2] != "run" {
+		t.Errorf("service arguments = %q", sc.Arguments)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &Config{LogMaxSize: 10, LogMaxBackups: 2}
+	w, err := c.output(filepath.Join(dir, "out.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if len(names) != 3 || names[0] != "out.log" {
+		t.Fatalf("files = %q, want out.log and 2 backups", names)
+	}
+	for i, want := range []string{"dddddddd\n", "bbbbbbbb\n", "cccccccc\n"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, names[i]))
+		if err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v, want %q", names[i], b, err, want)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func shell(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	return sh
+}
+
+func TestChild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	envFile := filepath.Join(dir, "env")
+	if err := ioutil.WriteFile(envFile, []byte("FROM_FILE=file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{
+		Exec:    shell(t),
+		Args:    []string{"-c", `echo "$FROM_ENV $FROM_FILE $(pwd)"; echo err >&2`},
+		Dir:     dir,
+		Env:     []string{"FROM_ENV=env"},
+		EnvFile: envFile,
+		Restart: restartOnFailure,
+	}
+	if err := c.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	ch := c.child(stdout, stderr)
+	if ch.Restart != supervisor.RestartOnFailure || ch.RestartDelay != time.Second || ch.MaxRestartDelay != time.Minute {
+		t.Errorf("child = %+v", ch)
+	}
+	cmd, err := ch.Command()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	realDir, _ := filepath.EvalSymlinks(dir)
+	if got, want := strings.TrimSpace(stdout.String()), "env file "+realDir; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if got := stderr.String(); got != "err\n" {
+		t.Errorf("stderr = %q, want %q", got, "err\n")
+	}
+}
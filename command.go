@@ -0,0 +1,365 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Command is a subcommand of a CommandLine.
+type Command struct {
+	Name string
+	// Usage is a one line description shown by help.
+	Usage string
+	// Run runs the command with the arguments following its name. An error
+	// with an ExitCode() int method, such as *exec.ExitError, sets the exit
+	// status of Main.
+	Run func(s Service, args []string) error
+}
+
+// MainOptions configure Main and NewCommandLine. The zero value is ready to
+// use.
+type MainOptions struct {
+	// Args are the command line arguments without the program name,
+	// os.Args[1:] if nil.
+	Args []string
+	// Commands are added to the standard commands. A command with the name of
+	// a standard command replaces it.
+	Commands []Command
+	// Default is the command run without arguments, "run" if empty.
+	Default string
+	// Logger, if not nil, is set to the service logger before a command runs.
+	Logger *Logger
+	// Errors receives the errors of the service logger. They are written to
+	// Stderr if nil.
+	Errors func(err error)
+	// Async, if not nil, wraps the service logger in an AsyncLogger, flushed
+	// by Exit for up to FlushTimeout.
+	Async *AsyncConfig
+	// Stdout and Stderr receive the output of the commands, os.Stdout and
+	// os.Stderr if nil.
+	Stdout, Stderr io.Writer
+	// Exit ends the program, os.Exit if nil.
+	Exit func(code int)
+}
+
+// CommandLine dispatches command line arguments to the subcommands of a
+// service: install, uninstall, start, stop, restart, status and run, plus
+// those of MainOptions.Commands.
+//
+// Main covers most programs. Programs parsing their own flags can use Flag,
+// and command trees such as cobra can add the commands with AddTo.
+type CommandLine struct {
+	Service Service
+	Logger  Logger
+
+	opts     MainOptions
+	commands []Command
+	action   string // set by the flag of Flag
+}
+
+// FlushTimeout bounds the wait of Exit and Run for background loggers such as
+// AsyncLogger to write the last messages.
+var FlushTimeout = 5 * time.Second
+
+// exitCode is an error ending Main with a status and no message, the output
+// having been written by the command.
+type exitCode int
+
+func (e exitCode) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitCode) ExitCode() int { return int(e) }
+
+// Main creates the service of i and c, runs the command of the command line
+// and exits. Without a command the service is run.
+//
+//	func main() {
+//		service.Main(&program{}, &service.Config{Name: "app"}, nil)
+//	}
+//
+// The exit status of status follows LSB: 0 running, 3 stopped and 4 unknown
+// or not installed. Unknown commands exit with 2.
+func Main(i Interface, c *Config, opts *MainOptions) {
+	cl, err := NewCommandLine(i, c, opts)
+	if err != nil {
+		cl.Exit(err)
+		return
+	}
+	args := cl.opts.Args
+	if args == nil {
+		args = os.Args[1:]
+	}
+	cl.Exit(cl.Execute(args))
+}
+
+// NewCommandLine creates the service of i and c and opens its logger. The
+// returned CommandLine can Exit with err when it is not nil.
+func NewCommandLine(i Interface, c *Config, opts *MainOptions) (*CommandLine, error) {
+	cl := newCommandLine(opts)
+	s, err := New(i, c)
+	if err != nil {
+		return cl, err
+	}
+	cl.Service = s
+	errs := make(chan error, 5)
+	if cl.opts.Async != nil {
+		var l Logger
+		l, err = s.Logger(nil)
+		if err == nil {
+			cl.Logger = NewAsyncLogger(l, *cl.opts.Async, errs)
+		}
+	} else {
+		cl.Logger, err = s.Logger(errs)
+	}
+	if err != nil {
+		return cl, err
+	}
+	go func() {
+		for err := range errs {
+			if cl.opts.Errors != nil {
+				cl.opts.Errors(err)
+			} else {
+				fmt.Fprintln(cl.stderr(), err)
+			}
+		}
+	}()
+	if cl.opts.Logger != nil {
+		*cl.opts.Logger = cl.Logger
+	}
+	return cl, nil
+}
+
+// newCommandLine returns a CommandLine without a service.
+func newCommandLine(opts *MainOptions) *CommandLine {
+	cl := &CommandLine{}
+	if opts != nil {
+		cl.opts = *opts
+	}
+	if cl.opts.Default == "" {
+		cl.opts.Default = "run"
+	}
+	cl.commands = cl.standardCommands()
+	for _, cmd := range cl.opts.Commands {
+		if std := cl.lookup(cmd.Name); std != nil {
+			*std = cmd
+			continue
+		}
+		cl.commands = append(cl.commands, cmd)
+	}
+	return cl
+}
+
+func (cl *CommandLine) stdout() io.Writer {
+	if cl.opts.Stdout != nil {
+		return cl.opts.Stdout
+	}
+	return os.Stdout
+}
+
+func (cl *CommandLine) stderr() io.Writer {
+	if cl.opts.Stderr != nil {
+		return cl.opts.Stderr
+	}
+	return os.Stderr
+}
+
+func (cl *CommandLine) standardCommands() []Command {
+	control := func(action string) func(s Service, args []string) error {
+		return func(s Service, args []string) error {
+			return Control(s, action)
+		}
+	}
+	return []Command{
+		{Name: "run", Usage: "Run the service.", Run: cl.run},
+		{Name: "install", Usage: "Install the service.", Run: cl.install},
+		{Name: "uninstall", Usage: "Uninstall the service.", Run: control("uninstall")},
+		{Name: "start", Usage: "Start the installed service.", Run: control("start")},
+		{Name: "stop", Usage: "Stop the installed service.", Run: control("stop")},
+		{Name: "restart", Usage: "Restart the installed service.", Run: control("restart")},
+		{Name: "status", Usage: "Print the status of the service.", Run: cl.status},
+	}
+}
+
+func (cl *CommandLine) run(s Service, args []string) error {
+	if err := s.Run(); err != nil {
+		if cl.Logger == nil {
+			return err
+		}
+		cl.Logger.Error(err)
+		return exitCode(1)
+	}
+	return nil
+}
+
+func (cl *CommandLine) install(s Service, args []string) error {
+	for _, w := range Warnings(s) {
+		fmt.Fprintln(cl.stderr(), "warning:", w)
+	}
+	return Control(s, "install")
+}
+
+func (cl *CommandLine) status(s Service, args []string) error {
+	status, err := s.Status()
+	switch {
+	case err == ErrNotInstalled:
+		fmt.Fprintln(cl.stdout(), "not installed")
+		return exitCode(4)
+	case err != nil:
+		return err
+	}
+	switch status {
+	case StatusRunning:
+		fmt.Fprintln(cl.stdout(), "running")
+		return nil
+	case StatusStopped:
+		fmt.Fprintln(cl.stdout(), "stopped")
+		return exitCode(3)
+	}
+	fmt.Fprintln(cl.stdout(), "unknown")
+	return exitCode(4)
+}
+
+func (cl *CommandLine) lookup(name string) *Command {
+	for i := range cl.commands {
+		if cl.commands[i].Name == name {
+			return &cl.commands[i]
+		}
+	}
+	return nil
+}
+
+// Exit ends the program with the result of Execute, once an AsyncLogger is
+// flushed. An error is written to Stderr unless the command already did, and
+// sets the exit status to its ExitCode() method, or to 1.
+func (cl *CommandLine) Exit(err error) {
+	exit := cl.opts.Exit
+	if exit == nil {
+		exit = os.Exit
+	}
+	if a, ok := cl.Logger.(*AsyncLogger); ok {
+		if ferr := a.Flush(FlushTimeout); ferr != nil {
+			fmt.Fprintln(cl.stderr(), ferr)
+		}
+	}
+	if err == nil {
+		exit(0)
+		return
+	}
+	var code exitCode
+	if errors.As(err, &code) {
+		exit(int(code))
+		return
+	}
+	fmt.Fprintln(cl.stderr(), err)
+	var coder interface{ ExitCode() int }
+	if errors.As(err, &coder) && coder.ExitCode() > 0 {
+		exit(coder.ExitCode())
+		return
+	}
+	exit(1)
+}
+
+// Commands returns the commands of the command line.
+func (cl *CommandLine) Commands() []Command {
+	return append([]Command(nil), cl.commands...)
+}
+
+// Execute runs the command named by the first argument, or by the flag of
+// Flag if it was set, with the remaining arguments. Without a command the
+// default command runs. "help" prints the usage.
+func (cl *CommandLine) Execute(args []string) error {
+	name := cl.action
+	if name == "" && len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "" {
+		name = cl.opts.Default
+	}
+	switch name {
+	case "help", "-h", "-help", "--help":
+		cl.Usage(cl.stdout())
+		return nil
+	}
+	if cl.lookup(name) == nil {
+		fmt.Fprintf(cl.stderr(), "unknown command %q\n", name)
+		cl.Usage(cl.stderr())
+		return exitCode(2)
+	}
+	return cl.Exec(name, args)
+}
+
+// Exec runs the named command with args.
+func (cl *CommandLine) Exec(name string, args []string) error {
+	cmd := cl.lookup(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	if cl.Service == nil {
+		return errors.New("the service was not created")
+	}
+	return cmd.Run(cl.Service, args)
+}
+
+// Usage writes the commands to w.
+func (cl *CommandLine) Usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [command] [arguments]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range cl.commands {
+		usage := cmd.Usage
+		if cmd.Name == cl.opts.Default {
+			usage += " (default)"
+		}
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.Name, usage)
+	}
+}
+
+// Flag defines a flag on fs naming the command Execute runs, for programs
+// that take the command as a flag:
+//
+//	cl.Flag(flag.CommandLine, "service")
+//	flag.Parse()
+//	err = cl.Execute(nil)
+func (cl *CommandLine) Flag(fs *flag.FlagSet, name string) {
+	names := make([]string, len(cl.commands))
+	for i, cmd := range cl.commands {
+		names[i] = cmd.Name
+	}
+	fs.Var(actionFlag{cl}, name, "Command to run: "+strings.Join(names, ", ")+".")
+}
+
+type actionFlag struct{ cl *CommandLine }
+
+func (f actionFlag) String() string {
+	if f.cl == nil {
+		return ""
+	}
+	return f.cl.action
+}
+
+func (f actionFlag) Set(name string) error {
+	if f.cl.lookup(name) == nil {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	f.cl.action = name
+	return nil
+}
+
+// AddTo passes every command to add, for command trees such as cobra:
+//
+//	cl.AddTo(func(name, usage string, run func(args []string) error) {
+//		root.AddCommand(&cobra.Command{Use: name, Short: usage,
+//			RunE: func(_ *cobra.Command, args []string) error { return run(args) }})
+//	})
+func (cl *CommandLine) AddTo(add func(name, usage string, run func(args []string) error)) {
+	for _, cmd := range cl.commands {
+		name := cmd.Name
+		add(name, cmd.Usage, func(args []string) error { return cl.Exec(name, args) })
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeCommandService records the control calls of a CommandLine.
+type fakeCommandService struct {
+	Service
+	calls     []string
+	status    Status
+	statusErr error
+}
+
+func (f *fakeCommandService) Run() error     { f.calls = append(f.calls, "run"); return nil }
+func (f *fakeCommandService) Start() error   { f.calls = append(f.calls, "start"); return nil }
+func (f *fakeCommandService) Stop() error    { f.calls = append(f.calls, "stop"); return nil }
+func (f *fakeCommandService) Restart() error { f.calls = append(f.calls, "restart"); return nil }
+func (f *fakeCommandService) Install() error { f.calls = append(f.calls, "install"); return nil }
+func (f *fakeCommandService) Uninstall() error {
+	f.calls = append(f.calls, "uninstall")
+	return nil
+}
+func (f *fakeCommandService) Status() (Status, error) { return f.status, f.statusErr }
+func (f *fakeCommandService) String() string          { return "fake" }
+
+func TestCommandLineExecute(t *testing.T) {
+	var custom []string
+	opts := &MainOptions{Commands: []Command{{
+		Name:  "reload",
+		Usage: "Reload the configuration.",
+		Run: func(s Service, args []string) error {
+			custom = args
+			return nil
+		},
+	}}}
+	tests := []struct {
+		args  []string
+		calls []string
+		code  int
+	}{
+		{nil, []string{"run"}, 0},
+		{[]string{"run"}, []string{"run"}, 0},
+		{[]string{"install"}, []string{"install"}, 0},
+		{[]string{"uninstall"}, []string{"uninstall"}, 0},
+		{[]string{"start"}, []string{"start"}, 0},
+		{[]string{"stop"}, []string{"stop"}, 0},
+		{[]string{"restart"}, []string{"restart"}, 0},
+		{[]string{"reload", "a", "b"}, nil, 0},
+		{[]string{"help"}, nil, 0},
+		{[]string{"bogus"}, nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			opts.Stdout, opts.Stderr = &stdout, &stderr
+			cl := newCommandLine(opts)
+			s := &fakeCommandService{}
+			cl.Service = s
+			err := cl.Execute(tt.args)
+			code := 0
+			var e exitCode
+			if errors.As(err, &e) {
+				code = int(e)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d", code, tt.code)
+			}
+			if !reflect.DeepEqual(s.calls, tt.calls) {
+				t.Errorf("calls = %q, want %q", s.calls, tt.calls)
+			}
+			if code == 2 && !strings.Contains(stderr.String(), "Commands:") {
+				t.Errorf("stderr = %q, want the usage", stderr.String())
+			}
+		})
+	}
+	if !reflect.DeepEqual(custom, []string{"a", "b"}) {
+		t.Errorf("custom command args = %q", custom)
+	}
+}
+
+// warningService is a fakeCommandService whose system ignores part of its
+// Config.
+type warningService struct {
+	fakeCommandService
+}
+
+func (w *warningService) warnings() []FieldError {
+	return []FieldError{{Field: `Option["Restart"]`, Message: "not used by fake"}}
+}
+
+func TestCommandLineInstallWarnings(t *testing.T) {
+	var stderr bytes.Buffer
+	cl := newCommandLine(&MainOptions{Stderr: &stderr})
+	s := &warningService{}
+	cl.Service = s
+	if err := cl.Execute([]string{"install"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.calls, []string{"install"}) {
+		t.Errorf("calls = %q, want install", s.calls)
+	}
+	if want := "warning: Option[\"Restart\"]: not used by fake\n"; stderr.String() != want {
+		t.Errorf("stderr = %q, want %q", stderr.String(), want)
+	}
+}
+
+func TestCommandLineStatus(t *testing.T) {
+	tests := []struct {
+		status Status
+		err    error
+		out    string
+		code   int
+	}{
+		{StatusRunning, nil, "running\n", 0},
+		{StatusStopped, nil, "stopped\n", 3},
+		{StatusUnknown, ErrNotInstalled, "not installed\n", 4},
+		{StatusUnknown, nil, "unknown\n", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			var stdout bytes.Buffer
+			code := -1
+			cl := newCommandLine(&MainOptions{Stdout: &stdout})
+			cl.Service = &fakeCommandService{status: tt.status, statusErr: tt.err}
+			err := cl.Execute([]string{"status"})
+			var e exitCode
+			if err == nil {
+				code = 0
+			} else if errors.As(err, &e) {
+				code = int(e)
+			}
+			if stdout.String() != tt.out || code != tt.code {
+				t.Errorf("status printed %q and exited %d, want %q and %d", stdout.String(), code, tt.out, tt.code)
+			}
+		})
+	}
+}
+
+func TestCommandLineFlag(t *testing.T) {
+	cl := newCommandLine(nil)
+	s := &fakeCommandService{}
+	cl.Service = s
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	cl.Flag(fs, "service")
+	if err := fs.Parse([]string{"-service", "bogus"}); err == nil {
+		t.Error("unknown command accepted by the flag")
+	}
+	if err := fs.Parse([]string{"-service", "stop"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Execute(fs.Args()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.calls, []string{"stop"}) {
+		t.Errorf("calls = %q, want stop", s.calls)
+	}
+}
+
+func TestCommandLineAddTo(t *testing.T) {
+	cl := newCommandLine(&MainOptions{Commands: []Command{{Name: "stop", Usage: "Custom stop.", Run: func(s Service, args []string) error {
+		return errors.New("custom stop")
+	}}}})
+	s := &fakeCommandService{}
+	cl.Service = s
+	tree := map[string]func([]string) error{}
+	var names []string
+	cl.AddTo(func(name, usage string, run func([]string) error) {
+		names = append(names, name)
+		tree[name] = run
+	})
+	want := []string{"run", "install", "uninstall", "start", "stop", "restart", "status"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("commands = %q, want %q", names, want)
+	}
+	if err := tree["start"](nil); err != nil || !reflect.DeepEqual(s.calls, []string{"start"}) {
+		t.Errorf("start = %v, calls %q", err, s.calls)
+	}
+	if err := tree["stop"](nil); err == nil || err.Error() != "custom stop" {
+		t.Errorf("stop = %v, want the replaced command", err)
+	}
+}
+
+func TestMainExitCode(t *testing.T) {
+	var code int
+	var stderr bytes.Buffer
+	Main(nopInterface{}, &Config{Name: "maintest"}, &MainOptions{
+		Args:   []string{"bogus"},
+		Stderr: &stderr,
+		Stdout: &stderr,
+		Exit:   func(c int) { code = c },
+	})
+	if code == 0 {
+		t.Errorf("Main exited with 0, output %q", stderr.String())
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownConfigFormat is returned by LoadConfig for a file extension with
+// no registered format.
+var ErrUnknownConfigFormat = errors.New("unknown config format")
+
+var (
+	configFormatsMu sync.RWMutex
+	configFormats   = map[string]func([]byte, interface{}) error{
+		".json": json.Unmarshal,
+	}
+)
+
+// RegisterConfigFormat makes LoadConfig read files ending in ext with
+// unmarshal. JSON (".json") is built in; YAML and TOML are added with their
+// decoders:
+//
+//	service.RegisterConfigFormat(".yaml", yaml.Unmarshal)
+//	service.RegisterConfigFormat(".toml", toml.Unmarshal)
+//
+// unmarshal is given a *interface{}. Whatever the format, field names are
+// those of Config and are matched as encoding/json matches them.
+func RegisterConfigFormat(ext string, unmarshal func(data []byte, v interface{}) error) {
+	configFormatsMu.Lock()
+	defer configFormatsMu.Unlock()
+	configFormats[strings.ToLower(ext)] = unmarshal
+}
+
+// LoadConfig reads a Config from the file at path, in the format registered
+// for its extension, then applies the SERVICE_* overrides of the environment
+// as ApplyEnv does. Unknown fields in the file are an error.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseConfig(b, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.ApplyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ParseConfig decodes a Config from data in the format registered for the
+// extension ext, such as ".json".
+func ParseConfig(data []byte, ext string) (*Config, error) {
+	configFormatsMu.RLock()
+	unmarshal, ok := configFormats[strings.ToLower(ext)]
+	configFormatsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownConfigFormat, ext)
+	}
+	if strings.ToLower(ext) != ".json" {
+		// Go through JSON so every format decodes the same way.
+		var v interface{}
+		if err := unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = json.Marshal(jsonValue(v)); err != nil {
+			return nil, err
+		}
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	c := &Config{}
+	if err := d.Decode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// jsonValue converts the map[interface{}]interface{} values some YAML decoders
+// produce into values encoding/json accepts.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	}
+	return v
+}
+
+// ApplyEnv overrides c with the SERVICE_* variables of environ, given as
+// "NAME=value" like os.Environ:
+//
+//   - SERVICE_NAME, SERVICE_DISPLAY_NAME, SERVICE_DESCRIPTION,
+//     SERVICE_USER_NAME, SERVICE_EXECUTABLE, SERVICE_WORKING_DIRECTORY and
+//     SERVICE_CHROOT set the field of the same name.
+//   - SERVICE_ARGUMENTS and SERVICE_DEPENDENCIES hold a JSON array of strings.
+//   - SERVICE_ENV_<NAME> sets EnvVars[NAME].
+//   - SERVICE_OPTION_<KEY> sets the option KEY, matched ignoring case and
+//     underscores, so SERVICE_OPTION_LIMIT_NOFILE sets LimitNOFILE. The value
+//     is parsed as the type of the option.
+//
+// Other SERVICE_* variables are ignored.
+func (c *Config) ApplyEnv(environ []string) error {
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		key, ok := strings.CutPrefix(name, "SERVICE_")
+		if !ok {
+			continue
+		}
+		var err error
+		switch {
+		case key == "NAME":
+			c.Name = value
+		case key == "DISPLAY_NAME":
+			c.DisplayName = value
+		case key == "DESCRIPTION":
+			c.Description = value
+		case key == "USER_NAME":
+			c.UserName = value
+		case key == "EXECUTABLE":
+			c.Executable = value
+		case key == "WORKING_DIRECTORY":
+			c.WorkingDirectory = value
+		case key == "CHROOT":
+			c.ChRoot = value
+		case key == "ARGUMENTS":
+			err = json.Unmarshal([]byte(value), &c.Arguments)
+		case key == "DEPENDENCIES":
+			err = json.Unmarshal([]byte(value), &c.Dependencies)
+		case strings.HasPrefix(key, "ENV_"):
+			if c.EnvVars == nil {
+				c.EnvVars = map[string]string{}
+			}
+			c.EnvVars[strings.TrimPrefix(key, "ENV_")] = value
+		case strings.HasPrefix(key, "OPTION_"):
+			err = c.setEnvOption(strings.TrimPrefix(key, "OPTION_"), value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setEnvOption sets the option matching key to value parsed as its type.
+func (c *Config) setEnvOption(key, value string) error {
+	norm := func(s string) string { return strings.ToUpper(strings.Replace(s, "_", "", -1)) }
+	var name string
+	for k := range optionKinds {
+		if norm(k) == norm(key) {
+			name = k
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("unknown option %q", key)
+	}
+	var v interface{}
+	var err error
+	switch optionKinds[name] {
+	case "bool":
+		v, err = strconv.ParseBool(value)
+	case "int":
+		v, err = strconv.Atoi(value)
+	case "string":
+		v = value
+	default:
+		return fmt.Errorf("option %s cannot be set from the environment", name)
+	}
+	if err != nil {
+		return err
+	}
+	if c.Option == nil {
+		c.Option = KeyValue{}
+	}
+	c.Option[name] = v
+	// Typed options take precedence over Option, so clear them.
+	for _, o := range []interface{}{c.POSIX, c.Systemd, c.Launchd, c.Windows} {
+		clearOption(o, name)
+	}
+	return nil
+}
+
+// clearOption zeroes the field of o, a pointer to one of the option structs,
+// holding the option name.
+func clearOption(o interface{}, name string) {
+	v := reflect.ValueOf(o)
+	if v.IsNil() {
+		return
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("option") == name {
+			v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+		}
+	}
+}
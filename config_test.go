@@ -0,0 +1,167 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigJSONRoundTrip(t *testing.T) {
+	limit := 1024
+	c := &Config{
+		Name:         "demo",
+		DisplayName:  "Demo",
+		Arguments:    []string{"-v", "a b"},
+		Dependencies: []string{"After=network.target"},
+		EnvVars:      map[string]string{"A": "1"},
+		Option:       KeyValue{optionKeepAlive: false, optionLimitNOFILE: 10, optionRunWait: func() {}},
+		Systemd:      &SystemdOptions{LimitNOFILE: &limit, DBus: true},
+		Credentials:  []CredentialConfig{{Name: "key", Path: "/etc/key"}},
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseConfig(b, ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(c.Option, optionRunWait)
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("round trip = %+v, want %+v", got, c)
+	}
+
+	if _, err := ParseConfig([]byte(`{"Name": "demo", "Nmae": "typo"}`), ".json"); err == nil {
+		t.Error("ParseConfig() accepted an unknown field")
+	}
+	if _, err := ParseConfig(b, ".ini"); !errors.Is(err, ErrUnknownConfigFormat) {
+		t.Errorf("ParseConfig(.ini) error = %v, want %v", err, ErrUnknownConfigFormat)
+	}
+}
+
+func TestRegisterConfigFormat(t *testing.T) {
+	// Decoders such as yaml.v2 produce map[interface{}]interface{}.
+	RegisterConfigFormat(".test", func(data []byte, v interface{}) error {
+		*v.(*interface{}) = map[interface{}]interface{}{
+			"Name":   string(data),
+			"Option": map[interface{}]interface{}{"LimitNOFILE": 7},
+		}
+		return nil
+	})
+	defer func() {
+		configFormatsMu.Lock()
+		delete(configFormats, ".test")
+		configFormatsMu.Unlock()
+	}()
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "demo.TEST")
+	if err := ioutil.WriteFile(path, []byte("demo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SERVICE_DISPLAY_NAME", "From env")
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "demo" || c.DisplayName != "From env" || c.Option.int(optionLimitNOFILE, -1) != 7 {
+		t.Errorf("LoadConfig() = %+v", c)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	keepAlive := true
+	c := &Config{
+		Name:    "demo",
+		Launchd: &LaunchdOptions{KeepAlive: &keepAlive, RunAtLoad: true},
+	}
+	err := c.ApplyEnv([]string{
+		"PATH=/bin",
+		"SERVICE_NAME=renamed",
+		"SERVICE_WORKING_DIRECTORY=/srv",
+		`SERVICE_ARGUMENTS=["-v", "a b"]`,
+		"SERVICE_ENV_GREETING=hello=world",
+		"SERVICE_OPTION_LIMIT_NOFILE=4096",
+		"SERVICE_OPTION_KEEPALIVE=false",
+		"SERVICE_OPTION_USERSERVICE=true",
+		"SERVICE_UNRELATED=x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "renamed" || c.WorkingDirectory != "/srv" || !reflect.DeepEqual(c.Arguments, []string{"-v", "a b"}) {
+		t.Errorf("fields = %+v", c)
+	}
+	if c.EnvVars["GREETING"] != "hello=world" {
+		t.Errorf("EnvVars = %v", c.EnvVars)
+	}
+	opts := c.Options()
+	want := map[string]interface{}{optionLimitNOFILE: 4096, optionKeepAlive: false, optionUserService: true, optionRunAtLoad: true}
+	for k, v := range want {
+		if opts[k] != v {
+			t.Errorf("option %s = %#v, want %#v", k, opts[k], v)
+		}
+	}
+
+	for _, env := range []string{"SERVICE_OPTION_BOGUS=1", "SERVICE_OPTION_LIMITNOFILE=many", "SERVICE_ARGUMENTS=-v", "SERVICE_OPTION_RUNWAIT=x"} {
+		if err := (&Config{}).ApplyEnv([]string{env}); err == nil {
+			t.Errorf("ApplyEnv(%s) succeeded", env)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "demo.json")
+	doc := `{
+	"Name": "demo",
+	"Option": {"LogMaxAge": "12h"},
+	"POSIX": {"LogFile": true, "LogMaxAge": "24h", "LogLevel": "debug"},
+	"Windows": {"OnFailure": "restart", "OnFailureDelay": "1m30s"}
+}`
+	if err := ioutil.WriteFile(path, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.POSIX == nil || c.POSIX.LogMaxAge != Duration(24*time.Hour) || c.POSIX.LogLevel != LevelDebug {
+		t.Errorf("POSIX = %+v", c.POSIX)
+	}
+	if c.Windows == nil || c.Windows.OnFailureDelay != Duration(90*time.Second) {
+		t.Errorf("Windows = %+v", c.Windows)
+	}
+	opts := c.Options()
+	if opts[optionLogMaxAge] != "24h0m0s" || opts["OnFailureDelayDuration"] != "1m30s" {
+		t.Errorf("Options() = %v", opts)
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ParseConfig(b, ".json"); err != nil || !reflect.DeepEqual(got, c) {
+		t.Errorf("round trip = %+v, %v, want %+v", got, err, c)
+	}
+	if _, err := ParseConfig([]byte(`{"Name": "demo", "POSIX": {"LogMaxAge": "a day"}}`), ".json"); err == nil {
+		t.Error("ParseConfig() accepted an invalid duration")
+	}
+}
@@ -5,44 +5,226 @@
 package service
 
 import (
-	"log"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// ConsoleLogger logs to the std err.
-var ConsoleLogger = consoleLogger{}
+// ConsoleLogger logs to the std err. Its level is read from LogLevelEnv at
+// startup. Service.Logger returns it when interactive, unless the
+// ConsoleFormat option is set; it may be replaced by a NewConsoleLogger.
+var ConsoleLogger Logger = NewConsoleLogger(ConsoleConfig{Level: envLevel(LevelInfo)})
 
-type consoleLogger struct {
-	info, warn, err *log.Logger
+// ConsoleFormat is the line format of a console logger.
+type ConsoleFormat int
+
+const (
+	ConsoleText   ConsoleFormat = iota // I: 15:04:05 message k=v
+	ConsoleLogfmt                      // time=... level=info msg=message k=v
+	ConsoleJSON                        // {"time":"...","level":"info","msg":"message","k":"v"}
+)
+
+// ConsoleConfig configures a console logger.
+type ConsoleConfig struct {
+	// Writer receives the lines, os.Stderr if nil.
+	Writer io.Writer
+	Format ConsoleFormat
+	// TimeFormat is the layout of the time, "15:04:05" for ConsoleText and
+	// time.RFC3339Nano for the others if empty. "-" omits the time.
+	TimeFormat string
+	// Color colors the level of ConsoleText when Writer is a terminal and
+	// NO_COLOR is not set.
+	Color bool
+	// Fields are written with every message.
+	Fields map[string]string
+	// Level is the initial level of the logger.
+	Level Level
+}
+
+// WriterLogger is a Logger writing a line per message to a writer, created
+// by NewConsoleLogger. It is safe for concurrent use.
+type WriterLogger struct {
+	c     ConsoleConfig
+	color bool
+	keys  []string // of c.Fields, sorted
+
+	mu sync.Mutex
+	*levelVar
+}
+
+func (l *WriterLogger) writesToStd() bool {
+	return isStdStream(l.c.Writer)
+}
+
+// NewConsoleLogger returns a logger writing to the Writer of c.
+func NewConsoleLogger(c ConsoleConfig) *WriterLogger {
+	if c.Writer == nil {
+		c.Writer = os.Stderr
+	}
+	if c.TimeFormat == "" {
+		c.TimeFormat = time.RFC3339Nano
+		if c.Format == ConsoleText {
+			c.TimeFormat = "15:04:05"
+		}
+	}
+	l := &WriterLogger{c: c, levelVar: newLevelVar(c.Level)}
+	l.color = c.Color && c.Format == ConsoleText && os.Getenv("NO_COLOR") == "" && isTerminal(c.Writer)
+	for k := range c.Fields {
+		l.keys = append(l.keys, k)
+	}
+	sort.Strings(l.keys)
+	return l
+}
+
+// newConsoleLogger returns the console logger of c: ConsoleLogger, or one in
+// the ConsoleFormat of c at the level of configLevel.
+func newConsoleLogger(c *Config) (Logger, error) {
+	name := c.Option.string(optionConsoleFormat, "")
+	if name == "" {
+		return ConsoleLogger, nil
+	}
+	format, err := parseConsoleFormat(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewConsoleLogger(ConsoleConfig{Format: format, Level: configLevel(c)}), nil
+}
+
+// parseConsoleFormat returns the format named s: text, logfmt or json.
+func parseConsoleFormat(s string) (ConsoleFormat, error) {
+	switch strings.ToLower(s) {
+	case "text":
+		return ConsoleText, nil
+	case "logfmt":
+		return ConsoleLogfmt, nil
+	case "json":
+		return ConsoleJSON, nil
+	}
+	return 0, fmt.Errorf("console format %q is not text, logfmt or json", s)
+}
+
+// isTerminal reports whether w is a character device such as a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// ANSI colors of the levels.
+var levelColors = map[Level]string{
+	LevelDebug:   "\x1b[90m",
+	LevelWarning: "\x1b[33m",
+	LevelError:   "\x1b[31m",
+}
+
+// format returns the line of msg at level.
+func (l *WriterLogger) format(level Level, msg string, t time.Time) []byte {
+	msg = strings.TrimRight(msg, "\n")
+	var ts string
+	if l.c.TimeFormat != "-" {
+		ts = t.Format(l.c.TimeFormat)
+	}
+	var b strings.Builder
+	switch l.c.Format {
+	case ConsoleJSON:
+		b.WriteString("{")
+		if ts != "" {
+			b.WriteString(`"time":` + jsonString(ts) + ",")
+		}
+		b.WriteString(`"level":` + jsonString(level.String()) + `,"msg":` + jsonString(msg))
+		for _, k := range l.keys {
+			b.WriteString("," + jsonString(k) + ":" + jsonString(l.c.Fields[k]))
+		}
+		b.WriteString("}")
+	case ConsoleLogfmt:
+		if ts != "" {
+			b.WriteString("time=" + logfmtValue(ts) + " ")
+		}
+		b.WriteString("level=" + level.String() + " msg=" + logfmtValue(msg))
+		for _, k := range l.keys {
+			b.WriteString(" " + k + "=" + logfmtValue(l.c.Fields[k]))
+		}
+	default:
+		prefix := levelPrefix(level)
+		if c, ok := levelColors[level]; ok && l.color {
+			prefix = c + prefix + "\x1b[0m"
+		}
+		b.WriteString(prefix + " ")
+		if ts != "" {
+			b.WriteString(ts + " ")
+		}
+		b.WriteString(msg)
+		for _, k := range l.keys {
+			b.WriteString(" " + k + "=" + logfmtValue(l.c.Fields[k]))
+		}
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
 }
 
-func init() {
-	ConsoleLogger.info = log.New(os.Stderr, "I: ", log.Ltime)
-	ConsoleLogger.warn = log.New(os.Stderr, "W: ", log.Ltime)
-	ConsoleLogger.err = log.New(os.Stderr, "E: ", log.Ltime)
+// jsonString returns s as a JSON string, without the HTML escapes of
+// json.Marshal.
+func jsonString(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
 }
 
-func (c consoleLogger) Error(v ...interface{}) error {
-	c.err.Print(v...)
-	return nil
+// logfmtValue quotes s if it is empty or holds spaces, quotes, equal signs
+// or control characters.
+func logfmtValue(s string) string {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (l *WriterLogger) log(level Level, msg string) error {
+	if !l.enabled(level) {
+		return nil
+	}
+	line := l.format(level, msg, time.Now())
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.c.Writer.Write(line)
+	return err
+}
+
+func (l *WriterLogger) Error(v ...interface{}) error {
+	return l.log(LevelError, fmt.Sprint(v...))
+}
+func (l *WriterLogger) Warning(v ...interface{}) error {
+	return l.log(LevelWarning, fmt.Sprint(v...))
+}
+func (l *WriterLogger) Info(v ...interface{}) error {
+	return l.log(LevelInfo, fmt.Sprint(v...))
 }
-func (c consoleLogger) Warning(v ...interface{}) error {
-	c.warn.Print(v...)
-	return nil
+func (l *WriterLogger) Debug(v ...interface{}) error {
+	return l.log(LevelDebug, fmt.Sprint(v...))
 }
-func (c consoleLogger) Info(v ...interface{}) error {
-	c.info.Print(v...)
-	return nil
+func (l *WriterLogger) Errorf(format string, a ...interface{}) error {
+	return l.log(LevelError, fmt.Sprintf(format, a...))
 }
-func (c consoleLogger) Errorf(format string, a ...interface{}) error {
-	c.err.Printf(format, a...)
-	return nil
+func (l *WriterLogger) Warningf(format string, a ...interface{}) error {
+	return l.log(LevelWarning, fmt.Sprintf(format, a...))
 }
-func (c consoleLogger) Warningf(format string, a ...interface{}) error {
-	c.warn.Printf(format, a...)
-	return nil
+func (l *WriterLogger) Infof(format string, a ...interface{}) error {
+	return l.log(LevelInfo, fmt.Sprintf(format, a...))
 }
-func (c consoleLogger) Infof(format string, a ...interface{}) error {
-	c.info.Printf(format, a...)
-	return nil
+func (l *WriterLogger) Debugf(format string, a ...interface{}) error {
+	return l.log(LevelDebug, fmt.Sprintf(format, a...))
 }
@@ -0,0 +1,102 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsoleFormat(t *testing.T) {
+	at := time.Date(2024, 3, 5, 7, 8, 9, 123456789, time.UTC)
+	fields := map[string]string{"service": "demo", "zone": "eu west"}
+	tests := []struct {
+		name  string
+		c     ConsoleConfig
+		level Level
+		msg   string
+		want  string
+	}{
+		{"text", ConsoleConfig{}, LevelInfo, "started\n", "I: 07:08:09 started\n"},
+		{"text fields", ConsoleConfig{Fields: fields, TimeFormat: "-"}, LevelError, "failed", `E: failed service=demo zone="eu west"` + "\n"},
+		{"logfmt", ConsoleConfig{Format: ConsoleLogfmt, Fields: fields}, LevelWarning, `slow "disk"`,
+			`time=2024-03-05T07:08:09.123456789Z level=warning msg="slow \"disk\"" service=demo zone="eu west"` + "\n"},
+		{"logfmt empty", ConsoleConfig{Format: ConsoleLogfmt, TimeFormat: "-"}, LevelDebug, "", `level=debug msg=""` + "\n"},
+		{"json", ConsoleConfig{Format: ConsoleJSON, Fields: fields}, LevelInfo, "a\tb <c>",
+			`{"time":"2024-03-05T07:08:09.123456789Z","level":"info","msg":"a\tb <c>","service":"demo","zone":"eu west"}` + "\n"},
+		{"json time format", ConsoleConfig{Format: ConsoleJSON, TimeFormat: time.RFC3339}, LevelError, "x",
+			`{"time":"2024-03-05T07:08:09Z","level":"error","msg":"x"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewConsoleLogger(tt.c)
+			if got := string(l.format(tt.level, tt.msg, at)); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsoleLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewConsoleLogger(ConsoleConfig{Writer: &buf, Format: ConsoleJSON, Level: LevelWarning})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Infof("hidden %d", i)
+			l.Warningf("line %d\nwith a newline", i)
+		}(i)
+	}
+	wg.Wait()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("got %d lines, want 10: %q", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		var v struct{ Time, Level, Msg string }
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, v.Time); err != nil || v.Level != "warning" || !strings.HasSuffix(v.Msg, "\nwith a newline") {
+			t.Errorf("unexpected line %q", line)
+		}
+	}
+}
+
+func TestConsoleLoggerColor(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewConsoleLogger(ConsoleConfig{Writer: &buf, Color: true})
+	if l.color {
+		t.Error("colored output to a buffer")
+	}
+	l.color = true
+	if got := string(l.format(LevelError, "x", time.Time{})); !strings.HasPrefix(got, "\x1b[31mE:\x1b[0m ") {
+		t.Errorf("colored line = %q", got)
+	}
+}
+
+func TestNewConsoleLoggerOption(t *testing.T) {
+	t.Setenv(LogLevelEnv, "")
+	l, err := newConsoleLogger(&Config{})
+	if err != nil || l != ConsoleLogger {
+		t.Errorf("newConsoleLogger() = %v, %v, want ConsoleLogger", l, err)
+	}
+	l, err = newConsoleLogger(&Config{Option: KeyValue{optionConsoleFormat: "JSON", optionLogLevel: "error"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, ok := l.(*WriterLogger); !ok || w.c.Format != ConsoleJSON || w.Level() != LevelError {
+		t.Errorf("newConsoleLogger() = %#v, want a JSON logger at error", l)
+	}
+	if _, err := newConsoleLogger(&Config{Option: KeyValue{optionConsoleFormat: "xml"}}); err == nil {
+		t.Error("newConsoleLogger() accepted an unknown format")
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+// Container describes the container or sandbox the program runs in, as
+// reported by ContainerInfo.
+type Container struct {
+	// Runtime names it, such as "docker", "podman", "kubernetes",
+	// "containerd", "lxc", "incus", "systemd-nspawn" or "wsl". Empty if none
+	// was found.
+	Runtime string
+	// Sandbox is true for system containers such as LXC, LXD, Incus and
+	// systemd-nspawn, and for WSL, which run a whole system with its own
+	// service manager rather than a single program.
+	Sandbox bool
+	// Evidence lists what was found, most telling first, such as
+	// "/.dockerenv exists".
+	Evidence []string
+}
+
+// InContainer reports whether the program runs in an application container,
+// such as one of Docker, Podman, Kubernetes or containerd, where no service
+// manager supervises it. It is false in a Sandbox.
+func (c Container) InContainer() bool {
+	return c.Runtime != "" && !c.Sandbox
+}
+
+// ContainerInfo detects the container the program runs in. Only Linux
+// containers are detected.
+func ContainerInfo() Container {
+	return detectContainer()
+}
+
+// inContainer reports whether the service runs in an application container.
+func inContainer() bool {
+	return ContainerInfo().InContainer()
+}
@@ -0,0 +1,169 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Files read by detectContainer, besides cgroupFile, mountInfoFile and
+// dockerEnvFile. Tests replace them.
+var (
+	containerEnvFile     = "/run/.containerenv"
+	kubernetesSecretsDir = "/var/run/secrets/kubernetes.io"
+	pid1EnvironFile      = "/proc/1/environ"
+	osReleaseFile        = "/proc/sys/kernel/osrelease"
+	incusSocket          = "/dev/incus/sock"
+	lxdSocket            = "/dev/lxd/sock"
+)
+
+// systemContainers are the runtimes booting a whole system, reported as
+// Sandbox.
+var systemContainers = map[string]bool{
+	"lxc":            true,
+	"lxc-libvirt":    true,
+	"lxd":            true,
+	"incus":          true,
+	"systemd-nspawn": true,
+	"openvz":         true,
+	"wsl":            true,
+}
+
+// detectContainer looks for the traces container runtimes leave, the most
+// specific first. Files that cannot be read are skipped.
+func detectContainer() Container {
+	var c Container
+	found := func(runtime, evidence string) {
+		if c.Runtime == "" {
+			c.Runtime = runtime
+		}
+		c.Evidence = append(c.Evidence, evidence)
+	}
+	if getenv("KUBERNETES_SERVICE_HOST") != "" {
+		found("kubernetes", "KUBERNETES_SERVICE_HOST is set")
+	}
+	if exists(kubernetesSecretsDir) {
+		found("kubernetes", kubernetesSecretsDir+" exists")
+	}
+	if exists(containerEnvFile) {
+		found("podman", containerEnvFile+" exists")
+	}
+	if exists(dockerEnvFile) {
+		found("docker", dockerEnvFile+" exists")
+	}
+	if exists(incusSocket) {
+		found("incus", incusSocket+" exists")
+	}
+	if exists(lxdSocket) {
+		found("lxd", lxdSocket+" exists")
+	}
+	if v := getenv("container"); v != "" {
+		found(v, "container="+v+" is set")
+	}
+	if v := pid1Container(pid1EnvironFile); v != "" {
+		found(v, "container="+v+" is in the environment of PID 1")
+	}
+	if runtime, line, _ := cgroupRuntime(cgroupFile); runtime != "" {
+		found(runtime, cgroupFile+" has "+line)
+	}
+	if runtime, line, _ := mountInfoRuntime(mountInfoFile); runtime != "" {
+		found(runtime, mountInfoFile+" has "+line)
+	}
+	if c.Runtime == "" {
+		// The WSL kernel also runs Docker Desktop, so WSL is only reported
+		// outside of containers.
+		if v := getenv("WSL_DISTRO_NAME"); v != "" {
+			found("wsl", "WSL_DISTRO_NAME="+v+" is set")
+		}
+		if b, err := ioutil.ReadFile(osReleaseFile); err == nil && strings.Contains(strings.ToLower(string(b)), "microsoft") {
+			found("wsl", osReleaseFile+" is "+strings.TrimSpace(string(b)))
+		}
+	}
+	c.Sandbox = systemContainers[c.Runtime]
+	return c
+}
+
+// pid1Container returns the container variable of the environment file of
+// PID 1, set by systemd-nspawn, LXC and others. Reading it needs privileges.
+func pid1Container(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, kv := range bytes.Split(b, []byte{0}) {
+		if v := bytes.TrimPrefix(kv, []byte("container=")); len(v) < len(kv) {
+			return string(v)
+		}
+	}
+	return ""
+}
+
+// cgroupRuntime returns the runtime named by the cgroup file of a process
+// and the line naming it. Under cgroup v2 the file is "0::/" in most
+// containers and names nothing.
+func cgroupRuntime(path string) (runtime, line string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := scan.Text()
+		switch {
+		case strings.Contains(line, "kubepods"):
+			return "kubernetes", line, nil
+		case strings.Contains(line, "libpod"):
+			return "podman", line, nil
+		case strings.Contains(line, "docker"):
+			return "docker", line, nil
+		case strings.Contains(line, "lxc"):
+			return "lxc", line, nil
+		case strings.Contains(line, "containerd"):
+			return "containerd", line, nil
+		}
+	}
+	return "", "", scan.Err()
+}
+
+// mountInfoRuntime returns the runtime that bind mounted /etc/hosts,
+// /etc/hostname or /etc/resolv.conf, as the mountinfo file at path shows,
+// and the line showing it.
+func mountInfoRuntime(path string) (runtime, line string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := scan.Text()
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		switch fields[4] {
+		case "/etc/hosts", "/etc/hostname", "/etc/resolv.conf":
+		default:
+			continue
+		}
+		root := fields[3]
+		switch {
+		case strings.Contains(root, "/kubelet/pods/"):
+			return "kubernetes", line, nil
+		case strings.Contains(root, "/docker/containers/"):
+			return "docker", line, nil
+		case strings.Contains(root, "containerd") || strings.Contains(root, "nerdctl"):
+			return "containerd", line, nil
+		case strings.Contains(root, "/containers/"):
+			return "podman", line, nil
+		}
+	}
+	return "", "", scan.Err()
+}
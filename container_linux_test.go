@@ -0,0 +1,105 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withContainerFiles points the files of detectContainer into dir and its
+// environment to env until the test ends.
+func withContainerFiles(t *testing.T, dir string, env map[string]string) {
+	vars := []*string{&cgroupFile, &mountInfoFile, &dockerEnvFile, &containerEnvFile,
+		&kubernetesSecretsDir, &pid1EnvironFile, &osReleaseFile, &incusSocket, &lxdSocket}
+	saved := make([]string, len(vars))
+	for i, v := range vars {
+		saved[i] = *v
+		*v = filepath.Join(dir, filepath.Base(*v))
+	}
+	savedGetenv := getenv
+	getenv = func(k string) string { return env[k] }
+	t.Cleanup(func() {
+		for i, v := range vars {
+			*v = saved[i]
+		}
+		getenv = savedGetenv
+	})
+}
+
+const (
+	podmanMountInfo = `1021 1020 0:80 / / rw,relatime - overlay overlay rw,lowerdir=/home/u/.local/share/containers/storage/overlay/l/X
+1029 1021 0:5 /containers/storage/overlay-containers/4f1c/userdata/hostname /etc/hostname rw,nosuid,nodev - tmpfs tmpfs rw`
+	kubernetesMountInfo = `2410 2391 259:1 /var/lib/kubelet/pods/8a2f/etc-hosts /etc/hosts rw,relatime - ext4 /dev/root rw`
+)
+
+func TestDetectContainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		env      map[string]string
+		runtime  string
+		sandbox  bool
+		evidence int
+	}{
+		{"none", map[string]string{"cgroup": linuxCgroup, "mountinfo": linuxMountInfo}, nil, "", false, 0},
+		{"docker", map[string]string{".dockerenv": "", "cgroup": "0::/", "mountinfo": dockerMountInfo}, nil, "docker", false, 2},
+		{"docker cgroup v1", map[string]string{"cgroup": dockerCgroup}, nil, "docker", false, 1},
+		{"podman", map[string]string{".containerenv": `engine="podman-4.9.3"`, "cgroup": "0::/", "mountinfo": podmanMountInfo}, nil, "podman", false, 2},
+		{"podman mounts", map[string]string{"mountinfo": podmanMountInfo}, nil, "podman", false, 1},
+		{"kubernetes", map[string]string{"kubernetes.io/token": "t", "mountinfo": kubernetesMountInfo},
+			map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"}, "kubernetes", false, 3},
+		{"systemd-nspawn", map[string]string{"environ": "PATH=/bin\x00container=systemd-nspawn\x00"}, nil, "systemd-nspawn", true, 1},
+		{"container variable", nil, map[string]string{"container": "lxc"}, "lxc", true, 1},
+		{"incus", map[string]string{"sock": ""}, nil, "incus", true, 2},
+		{"wsl", map[string]string{"osrelease": "5.15.153.1-microsoft-standard-WSL2\n"},
+			map[string]string{"WSL_DISTRO_NAME": "Ubuntu"}, "wsl", true, 2},
+		{"docker on wsl", map[string]string{".dockerenv": "", "osrelease": "5.15.153.1-microsoft-standard-WSL2\n"}, nil, "docker", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "container")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				os.MkdirAll(filepath.Dir(path), 0755)
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			withContainerFiles(t, dir, tt.env)
+			c := ContainerInfo()
+			if c.Runtime != tt.runtime || c.Sandbox != tt.sandbox || len(c.Evidence) != tt.evidence {
+				t.Errorf("ContainerInfo() = %+v, want runtime %q, sandbox %v and %d evidence", c, tt.runtime, tt.sandbox, tt.evidence)
+			}
+			if want := tt.runtime != "" && !tt.sandbox; c.InContainer() != want {
+				t.Errorf("InContainer() = %v, want %v", c.InContainer(), want)
+			}
+		})
+	}
+}
+
+func TestMountInfoRuntime(t *testing.T) {
+	f, err := ioutil.TempFile("", "mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeTestFile(f)
+	f.WriteString(dockerMountInfo)
+	runtime, line, err := mountInfoRuntime(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3866 3857 259:4 /var/lib/docker/containers/ea4d56df6742a4940bfa0b31a4481707511f2da7b7c0708ffe901b46f461eb89/resolv.conf /etc/resolv.conf rw,relatime - ext4 /dev/nvme0n1p4 rw"
+	if !reflect.DeepEqual([]string{runtime, line}, []string{"docker", want}) {
+		t.Errorf("mountInfoRuntime() = %q, %q", runtime, line)
+	}
+}
@@ -0,0 +1,12 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package service
+
+func detectContainer() Container {
+	return Container{}
+}
@@ -0,0 +1,130 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"os"
+	"strings"
+)
+
+// ModeEnv is the environment variable forcing the mode Interactive reports:
+// "interactive" or "service". Other values are ignored.
+var ModeEnv = "SERVICE_MODE"
+
+// Values of ModeEnv.
+const (
+	ModeInteractive = "interactive"
+	ModeService     = "service"
+)
+
+// Replaced by tests.
+var (
+	getenv  = os.Getenv
+	getppid = os.Getppid
+
+	stdTerminals = func() (stdin, stdout bool) {
+		return isTerminal(os.Stdin), isTerminal(os.Stdout)
+	}
+)
+
+// RunContext describes how the program was started, as reported by
+// DetectContext.
+type RunContext struct {
+	// Supervisor names what manages the program, such as "systemd",
+	// "systemd-run", "runit", "s6", "openrc", "procd", "daemontools",
+	// "launchd", "srcmstr", "rc", "init", "windows-service", or a container
+	// init such as "tini", "dumb-init" or "catatonit". Empty if none was
+	// found.
+	Supervisor string
+	// Parent is the name of the parent process, if known.
+	Parent string
+
+	// StdinTTY and StdoutTTY report whether stdin and stdout are terminals.
+	StdinTTY  bool
+	StdoutTTY bool
+
+	// InvocationID is the systemd INVOCATION_ID of the unit, if set.
+	InvocationID string
+	// JournalStream is true if JOURNAL_STREAM is set: systemd connected
+	// stdout or stderr to the journal.
+	JournalStream bool
+
+	// Container is the container the program runs in.
+	Container Container
+	// Session is the login session type: "ssh", the XDG_SESSION_TYPE such
+	// as "tty", "x11" or "wayland", or the Windows SESSIONNAME in lower
+	// case such as "console". Empty if unknown.
+	Session string
+
+	// Mode is the value of ModeEnv if valid, forcing Interactive.
+	Mode string
+}
+
+// DetectContext inspects how the program was started.
+func DetectContext() RunContext {
+	c := RunContext{
+		InvocationID:  getenv("INVOCATION_ID"),
+		JournalStream: getenv("JOURNAL_STREAM") != "",
+		Container:     ContainerInfo(),
+		Session:       sessionType(),
+	}
+	c.StdinTTY, c.StdoutTTY = stdTerminals()
+	switch m := strings.ToLower(getenv(ModeEnv)); m {
+	case ModeInteractive, ModeService:
+		c.Mode = m
+	}
+	detectSupervisor(&c)
+	return c
+}
+
+// Interactive reports whether the program runs from a terminal or an
+// application container rather than under a service manager. In a system
+// container such as LXC the supervisor decides, as on a host.
+func (c RunContext) Interactive() bool {
+	switch c.Mode {
+	case ModeInteractive:
+		return true
+	case ModeService:
+		return false
+	}
+	if c.Container.InContainer() {
+		return true
+	}
+	switch c.Supervisor {
+	case "", "tini", "dumb-init", "catatonit":
+		return true
+	case "systemd-run":
+		// A transient scope runs whatever the user asked for, usually
+		// from a shell.
+		return c.StdinTTY || c.StdoutTTY
+	}
+	return false
+}
+
+func sessionType() string {
+	if getenv("SSH_CONNECTION") != "" || getenv("SSH_TTY") != "" {
+		return "ssh"
+	}
+	if s := getenv("XDG_SESSION_TYPE"); s != "" {
+		return s
+	}
+	return strings.ToLower(getenv("SESSIONNAME"))
+}
+
+// supervisorNames maps the names of supervising processes to the
+// supervisor they belong to.
+var supervisorNames = map[string]string{
+	"systemd":          "systemd",
+	"runsv":            "runit",
+	"s6-supervise":     "s6",
+	"supervise-daemon": "openrc",
+	"procd":            "procd",
+	"supervise":        "daemontools",
+	"launchd":          "launchd",
+	"srcmstr":          "srcmstr",
+	"tini":             "tini",
+	"dumb-init":        "dumb-init",
+	"catatonit":        "catatonit",
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectContext(t *testing.T) {
+	tests := []struct {
+		name       string
+		parent     string
+		ppid       int
+		cgroup     string
+		env        map[string]string
+		tty        bool
+		supervisor string
+		want       bool
+	}{
+		{"shell", "bash", 4242, "0::/user.slice/user-1000.slice/session-2.scope", nil, true, "", true},
+		{"systemd parent", "systemd", 1, "0::/system.slice/foo.service", nil, false, "systemd", false},
+		{"systemd user service", "systemd", 900, "0::/user.slice/user-1000.slice/user@1000.service/app.slice/foo.service", nil, false, "systemd", false},
+		{"service unit", "foo-wrapper", 4242, "0::/system.slice/foo.service", nil, false, "systemd", false},
+		{"tmux user unit", "bash", 4242, "0::/user.slice/user-1000.slice/user@1000.service/app.slice/tmux.service", nil, true, "", true},
+		{"terminal server unit", "bash", 4242, "0::/user.slice/user-1000.slice/user@1000.service/app.slice/gnome-terminal-server.service", map[string]string{"INVOCATION_ID": "a1b2"}, true, "", true},
+		{"cgroup v1 service unit", "sh", 4242, "12:cpu,cpuacct:/\n1:name=systemd:/system.slice/foo.service\n0::/", nil, false, "systemd", false},
+		{"invocation id", "sh", 4242, "", map[string]string{"INVOCATION_ID": "a1b2"}, false, "systemd", false},
+		{"invocation id in terminal", "bash", 4242, "0::/user.slice/user-1000.slice/user@1000.service/app.slice/vte-spawn-1.scope", map[string]string{"INVOCATION_ID": "a1b2"}, true, "", true},
+		{"systemd-run scope", "systemd-run", 4242, "0::/user.slice/user-1000.slice/user@1000.service/app.slice/run-r1.scope", nil, true, "systemd-run", true},
+		{"systemd-run scope without terminal", "cron", 4242, "0::/system.slice/run-r1.scope", nil, false, "systemd-run", false},
+		{"runit", "runsv", 4242, "", nil, false, "runit", false},
+		{"s6", "s6-supervise", 4242, "", nil, false, "s6", false},
+		{"openrc", "supervise-daemon", 4242, "", nil, false, "openrc", false},
+		{"procd", "procd", 1, "", nil, false, "procd", false},
+		{"tini", "tini", 1, "", nil, false, "tini", true},
+		{"dumb-init", "dumb-init", 1, "", nil, false, "dumb-init", true},
+		{"init", "init", 1, "", nil, false, "init", false},
+		{"service in nspawn", "systemd", 1, "0::/system.slice/foo.service", map[string]string{"container": "systemd-nspawn"}, false, "systemd", false},
+		{"service in lxc", "foo-wrapper", 4242, "0::/system.slice/foo.service", map[string]string{"container": "lxc"}, false, "systemd", false},
+		{"shell in lxc", "bash", 4242, "0::/user.slice/user-0.slice/session-1.scope", map[string]string{"container": "lxc"}, true, "", true},
+		{"docker", "dumb-init", 1, "", map[string]string{"container": "docker"}, false, "dumb-init", true},
+		{"forced interactive", "runsv", 4242, "", map[string]string{ModeEnv: "Interactive"}, false, "runit", true},
+		{"forced service", "bash", 4242, "", map[string]string{ModeEnv: "service"}, true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			withContainerFiles(t, dir, tt.env)
+			withContextFiles(t, dir, tt.ppid, tt.parent, tt.cgroup, tt.tty)
+
+			c := DetectContext()
+			if c.Parent != tt.parent {
+				t.Errorf("Parent = %q, want %q", c.Parent, tt.parent)
+			}
+			if c.Supervisor != tt.supervisor {
+				t.Errorf("Supervisor = %q, want %q", c.Supervisor, tt.supervisor)
+			}
+			if got := c.Interactive(); got != tt.want {
+				t.Errorf("Interactive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// withContextFiles fakes the parent process, the cgroup file and the
+// terminals read by DetectContext until the test ends.
+func withContextFiles(t *testing.T, dir string, ppid int, parent, cgroup string, tty bool) {
+	stat := fmt.Sprintf("%d (%s) S 1 %d %d 0 -1", ppid, parent, ppid, ppid)
+	if err := os.MkdirAll(filepath.Join(dir, fmt.Sprint(ppid)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprint(ppid), "stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cgroupPath := filepath.Join(dir, "self-cgroup")
+	if err := ioutil.WriteFile(cgroupPath, []byte(cgroup), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	savedProcDir, savedCgroup, savedPpid, savedTerminals := procDir, selfCgroupFile, getppid, stdTerminals
+	procDir, selfCgroupFile = dir, cgroupPath
+	getppid = func() int { return ppid }
+	stdTerminals = func() (bool, bool) { return tty, tty }
+	t.Cleanup(func() {
+		procDir, selfCgroupFile, getppid, stdTerminals = savedProcDir, savedCgroup, savedPpid, savedTerminals
+	})
+}
@@ -0,0 +1,14 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !solaris && !aix && !freebsd && !windows
+// +build !linux,!darwin,!solaris,!aix,!freebsd,!windows
+
+package service
+
+func detectSupervisor(c *RunContext) {
+	if getppid() == 1 {
+		c.Supervisor = "init"
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import "testing"
+
+func TestRunContextInteractive(t *testing.T) {
+	docker := Container{Runtime: "docker"}
+	wsl := Container{Runtime: "wsl", Sandbox: true}
+	lxc := Container{Runtime: "lxc", Sandbox: true}
+	tests := []struct {
+		name string
+		c    RunContext
+		want bool
+	}{
+		{"terminal", RunContext{StdinTTY: true}, true},
+		{"no supervisor", RunContext{}, true},
+		{"systemd", RunContext{Supervisor: "systemd"}, false},
+		{"runit", RunContext{Supervisor: "runit"}, false},
+		{"s6", RunContext{Supervisor: "s6"}, false},
+		{"openrc", RunContext{Supervisor: "openrc"}, false},
+		{"procd", RunContext{Supervisor: "procd"}, false},
+		{"init", RunContext{Supervisor: "init"}, false},
+		{"windows service", RunContext{Supervisor: "windows-service"}, false},
+		{"tini", RunContext{Supervisor: "tini"}, true},
+		{"dumb-init", RunContext{Supervisor: "dumb-init"}, true},
+		{"container", RunContext{Supervisor: "init", Container: docker}, true},
+		{"wsl", RunContext{Supervisor: "systemd", Container: wsl}, false},
+		{"lxc", RunContext{Supervisor: "systemd", Container: lxc}, false},
+		{"shell in lxc", RunContext{StdinTTY: true, Container: lxc}, true},
+		{"systemd-run with terminal", RunContext{Supervisor: "systemd-run", StdoutTTY: true}, true},
+		{"systemd-run without terminal", RunContext{Supervisor: "systemd-run"}, false},
+		{"forced interactive", RunContext{Supervisor: "systemd", Mode: ModeInteractive}, true},
+		{"forced service", RunContext{StdinTTY: true, Container: docker, Mode: ModeService}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.Interactive(); got != tt.want {
+				t.Errorf("Interactive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionType(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"XDG_SESSION_TYPE": "wayland"}, "wayland"},
+		{map[string]string{"XDG_SESSION_TYPE": "tty", "SSH_CONNECTION": "10.0.0.1 5122 10.0.0.2 22"}, "ssh"},
+		{map[string]string{"SESSIONNAME": "Console"}, "console"},
+	}
+	saved := getenv
+	defer func() { getenv = saved }()
+	for _, tt := range tests {
+		getenv = func(k string) string { return tt.env[k] }
+		if got := sessionType(); got != tt.want {
+			t.Errorf("sessionType() with %v = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials is returned by Credential when the service was not
+	// started with credentials.
+	ErrNoCredentials = errors.New("no credentials passed to the service")
+	// ErrInvalidCredential is returned for a credential that cannot be
+	// passed to the service.
+	ErrInvalidCredential = errors.New("invalid credential")
+	// ErrCredentialsNotSupported is returned by Install when the system cannot
+	// pass credentials to the service.
+	ErrCredentialsNotSupported = errors.New("credentials are not supported on this system")
+)
+
+// CredentialConfig is a secret passed to the service without placing it in
+// its environment. The service reads it with Credential.
+//
+// On systemd the credential is rendered as LoadCredential,
+// LoadCredentialEncrypted or SetCredential. Other systems copy it at install
+// time into a directory that only root (LocalSystem and Administrators on
+// Windows) and the service user can read. AIX's System Resource Controller
+// cannot pass the directory to the service, so Install returns
+// ErrCredentialsNotSupported there.
+type CredentialConfig struct {
+	// Name of the credential, as passed to Credential.
+	Name string
+	// Path of the file the credential is read from.
+	Path string
+	// Encrypted marks Path as encrypted with systemd-creds. Only supported
+	// on systemd.
+	Encrypted bool
+	// Value of the credential when Path is empty. Units holding values are
+	// only readable by root.
+	Value string
+}
+
+// Credential returns the named credential passed to the running service.
+func Credential(name string) ([]byte, error) {
+	if !validCredentialName(name) {
+		return nil, fmt.Errorf("%w: name %q", ErrInvalidCredential, name)
+	}
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return nil, ErrNoCredentials
+	}
+	return ioutil.ReadFile(filepath.Join(dir, name))
+}
+
+// credentialsRoot holds the credentials of services on systems that do not
+// manage credentials themselves.
+var credentialsRoot = "/var/lib/kardianos-service/credentials"
+
+// credentialsDirectory returns the directory holding the credentials of c,
+// or "" if c has none.
+func credentialsDirectory(c *Config) string {
+	if len(c.Credentials) == 0 {
+		return ""
+	}
+	return filepath.Join(credentialsRoot, c.Name)
+}
+
+func validCredentialName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/:") && !hasControl(name)
+}
+
+// validateCredentials checks the credentials of c. encrypted reports whether
+// the system can decrypt credentials.
+func validateCredentials(c *Config, encrypted bool) error {
+	for _, cred := range c.Credentials {
+		switch {
+		case !validCredentialName(cred.Name):
+			return fmt.Errorf("%w: name %q", ErrInvalidCredential, cred.Name)
+		case cred.Encrypted && cred.Path == "":
+			return fmt.Errorf("%w: %s is encrypted but has no Path", ErrInvalidCredential, cred.Name)
+		case cred.Encrypted && !encrypted:
+			return fmt.Errorf("%w: %s is encrypted", ErrCredentialsNotSupported, cred.Name)
+		}
+	}
+	return nil
+}
+
+// hasCredentialValues reports whether a credential of c is set by value.
+func hasCredentialValues(c *Config) bool {
+	for _, cred := range c.Credentials {
+		if cred.Path == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCredentials copies the credentials of c into credentialsDirectory(c)
+// as a step.
+func (tx *installTx) writeCredentials(c *Config) error {
+	dir := credentialsDirectory(c)
+	if dir == "" {
+		return nil
+	}
+	if err := validateCredentials(c, false); err != nil {
+		return tx.fail("write credentials", err)
+	}
+	return tx.do("write credentials "+dir, func() error {
+		err := storeCredentials(c, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+		return err
+	}, func() error {
+		return os.RemoveAll(dir)
+	})
+}
+
+// storeCredentials writes the credentials of c into dir and gives dir to the
+// service user.
+func storeCredentials(c *Config, dir string) error {
+	// Others may only traverse the root to reach their own directory.
+	if err := os.MkdirAll(filepath.Dir(dir), 0711); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	for _, cred := range c.Credentials {
+		data := []byte(cred.Value)
+		if cred.Path != "" {
+			var err error
+			if data, err = ioutil.ReadFile(cred.Path); err != nil {
+				return err
+			}
+		}
+		if err := writeFileAtomic(filepath.Join(dir, cred.Name), data, 0400); err != nil {
+			return err
+		}
+	}
+	return restrictCredentials(c, dir)
+}
+
+// removeCredentials removes the credentials directory of c.
+func (r *UninstallReport) removeCredentials(c *Config) error {
+	return r.removeAll(filepath.Join(credentialsRoot, c.Name))
+}
@@ -0,0 +1,12 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix && !windows
+// +build !unix,!windows
+
+package service
+
+func restrictCredentials(c *Config, dir string) error {
+	return ErrCredentialsNotSupported
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredential(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("s3cret"), 0400); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	if _, err := Credential("token"); err != ErrNoCredentials {
+		t.Errorf("Credential() without directory error = %v, want %v", err, ErrNoCredentials)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	if b, err := Credential("token"); err != nil || string(b) != "s3cret" {
+		t.Errorf("Credential() = %q, %v", b, err)
+	}
+	if _, err := Credential("../token"); !errors.Is(err, ErrInvalidCredential) {
+		t.Errorf("Credential(../token) error = %v, want %v", err, ErrInvalidCredential)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		cred      CredentialConfig
+		encrypted bool
+		want      error
+	}{
+		{"value", CredentialConfig{Name: "a", Value: "x"}, false, nil},
+		{"path", CredentialConfig{Name: "a", Path: "/etc/a"}, false, nil},
+		{"bad name", CredentialConfig{Name: "a:b", Value: "x"}, true, ErrInvalidCredential},
+		{"name with newline", CredentialConfig{Name: "a\nLoadCredential=b", Value: "x"}, true, ErrInvalidCredential},
+		{"encrypted without path", CredentialConfig{Name: "a", Encrypted: true}, true, ErrInvalidCredential},
+		{"encrypted", CredentialConfig{Name: "a", Path: "/etc/a.cred", Encrypted: true}, true, nil},
+		{"encrypted unsupported", CredentialConfig{Name: "a", Path: "/etc/a.cred", Encrypted: true}, false, ErrCredentialsNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Name: "test", Credentials: []CredentialConfig{tt.cred}}
+			if err := validateCredentials(c, tt.encrypted); !errors.Is(err, tt.want) {
+				t.Errorf("validateCredentials() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteCredentials(t *testing.T) {
+	root, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(r string) { credentialsRoot = r }(credentialsRoot)
+	credentialsRoot = filepath.Join(root, "store")
+
+	src := filepath.Join(root, "src")
+	if err := ioutil.WriteFile(src, []byte("from file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{Name: "test", Credentials: []CredentialConfig{
+		{Name: "file", Path: src},
+		{Name: "value", Value: "inline"},
+	}}
+	tx := &installTx{}
+	if err := tx.writeCredentials(c); err != nil {
+		t.Fatal(err)
+	}
+	dir := credentialsDirectory(c)
+	for name, want := range map[string]string{"file": "from file", "value": "inline"} {
+		p := filepath.Join(dir, name)
+		b, err := ioutil.ReadFile(p)
+		if err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v, want %q", name, b, err, want)
+		}
+		if fi, err := os.Stat(p); err == nil && fi.Mode().Perm() != 0400 {
+			t.Errorf("%s mode = %v, want %v", name, fi.Mode().Perm(), os.FileMode(0400))
+		}
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("directory = %v, %v, want mode 0700", fi, err)
+	}
+
+	tx.fail("test", errors.New("rollback"))
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("credentials directory not rolled back: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+// +build unix
+
+package service
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// restrictCredentials gives dir and the credentials in it to the service
+// user. Their modes already keep everyone else out.
+func restrictCredentials(c *Config, dir string) error {
+	uid, gid := -1, -1
+	if c.UserName != "" {
+		u, err := user.Lookup(c.UserName)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return err
+		}
+	}
+	for _, cred := range c.Credentials {
+		if err := os.Lchown(filepath.Join(dir, cred.Name), uid, gid); err != nil {
+			return err
+		}
+	}
+	return os.Lchown(dir, uid, gid)
+}
@@ -0,0 +1,43 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+func init() {
+	if dir := os.Getenv("ProgramData"); dir != "" {
+		credentialsRoot = filepath.Join(dir, "kardianos-service", "credentials")
+	}
+}
+
+// restrictCredentials replaces the inherited permissions of dir and the
+// credentials in it, letting only LocalSystem and Administrators, and the
+// service user if set, read them.
+func restrictCredentials(c *Config, dir string) error {
+	sddl := "D:P(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)"
+	if c.UserName != "" {
+		sid, _, _, err := windows.LookupSID("", c.UserName)
+		if err != nil {
+			return err
+		}
+		sddl += "(A;OICI;FR;;;" + sid.String() + ")"
+	}
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	return windows.SetNamedSecurityInfo(dir, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION,
+		nil, nil, dacl, nil)
+}
@@ -0,0 +1,876 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+// A minimal D-Bus client. Only what the systemd transport needs is
+// implemented: EXTERNAL authentication over unix sockets, method calls,
+// replies and signals.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dbusTypeMethodCall   = 1
+	dbusTypeMethodReturn = 2
+	dbusTypeError        = 3
+	dbusTypeSignal       = 4
+)
+
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+)
+
+const dbusCallTimeout = 30 * time.Second
+
+// The protocol limits the nesting of arrays and of structs to 32 each, and
+// of all containers, variants included, to 64.
+const (
+	dbusMaxArrayDepth     = 32
+	dbusMaxStructDepth    = 32
+	dbusMaxContainerDepth = 64
+)
+
+// dbusObjectPath is a D-Bus object path ("o").
+type dbusObjectPath string
+
+// dbusSignature is a D-Bus type signature ("g").
+type dbusSignature string
+
+// dbusVariant is a D-Bus variant ("v"): a value with its own signature.
+type dbusVariant struct {
+	Sig   string
+	Value interface{}
+}
+
+// dbusError is an error reply from a D-Bus peer.
+type dbusError struct {
+	Name    string
+	Message string
+}
+
+func (e *dbusError) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+type dbusMessage struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	ReplySerial uint32
+	Path        dbusObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	Destination string
+	Sender      string
+	Signature   string
+	Body        []interface{}
+}
+
+// dbusNextType splits the first complete type off sig.
+func dbusNextType(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", errors.New("dbus: empty signature")
+	}
+	switch sig[0] {
+	case 'a':
+		elem, rest, err := dbusNextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "a" + elem, rest, nil
+	case '(', '{':
+		end := byte(')')
+		if sig[0] == '{' {
+			end = '}'
+		}
+		depth := 0
+		for i := 0; i < len(sig); i++ {
+			switch sig[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+				if depth == 0 {
+					if sig[i] != end || i == 1 {
+						return "", "", fmt.Errorf("dbus: bad signature %q", sig)
+					}
+					return sig[:i+1], sig[i+1:], nil
+				}
+			}
+		}
+		return "", "", fmt.Errorf("dbus: bad signature %q", sig)
+	}
+	return sig[:1], sig[1:], nil
+}
+
+// dbusSplitSignature splits sig into complete types.
+func dbusSplitSignature(sig string) ([]string, error) {
+	var types []string
+	for sig != "" {
+		t, rest, err := dbusNextType(sig)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+		sig = rest
+	}
+	return types, nil
+}
+
+func dbusAlignment(sig string) int {
+	switch sig[0] {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 4
+}
+
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *dbusEncoder) encode(sig string, v interface{}) error {
+	bad := func() error {
+		return fmt.Errorf("dbus: cannot encode %T as %q", v, sig)
+	}
+	switch sig[0] {
+	case 'y':
+		b, ok := v.(byte)
+		if !ok {
+			return bad()
+		}
+		e.buf = append(e.buf, b)
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return bad()
+		}
+		var u uint32
+		if b {
+			u = 1
+		}
+		e.uint32(u)
+	case 'n', 'q':
+		var u uint16
+		switch x := v.(type) {
+		case int16:
+			u = uint16(x)
+		case uint16:
+			u = x
+		default:
+			return bad()
+		}
+		e.align(2)
+		e.buf = binary.LittleEndian.AppendUint16(e.buf, u)
+	case 'i', 'u', 'h':
+		var u uint32
+		switch x := v.(type) {
+		case int32:
+			u = uint32(x)
+		case uint32:
+			u = x
+		case int:
+			u = uint32(x)
+		default:
+			return bad()
+		}
+		e.uint32(u)
+	case 'x', 't', 'd':
+		var u uint64
+		switch x := v.(type) {
+		case int64:
+			u = uint64(x)
+		case uint64:
+			u = x
+		case float64:
+			u = math.Float64bits(x)
+		default:
+			return bad()
+		}
+		e.align(8)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, u)
+	case 's', 'o':
+		var s string
+		switch x := v.(type) {
+		case string:
+			s = x
+		case dbusObjectPath:
+			s = string(x)
+		default:
+			return bad()
+		}
+		e.uint32(uint32(len(s)))
+		e.buf = append(append(e.buf, s...), 0)
+	case 'g':
+		var s string
+		switch x := v.(type) {
+		case string:
+			s = x
+		case dbusSignature:
+			s = string(x)
+		default:
+			return bad()
+		}
+		e.buf = append(e.buf, byte(len(s)))
+		e.buf = append(append(e.buf, s...), 0)
+	case 'v':
+		x, ok := v.(dbusVariant)
+		if !ok {
+			return bad()
+		}
+		if err := e.encode("g", x.Sig); err != nil {
+			return err
+		}
+		return e.encode(x.Sig, x.Value)
+	case 'a':
+		var items []interface{}
+		switch x := v.(type) {
+		case []interface{}:
+			items = x
+		case []string:
+			for _, s := range x {
+				items = append(items, s)
+			}
+		default:
+			return bad()
+		}
+		e.uint32(0)
+		lenAt := len(e.buf) - 4
+		e.align(dbusAlignment(sig[1:]))
+		start := len(e.buf)
+		for _, item := range items {
+			if err := e.encode(sig[1:], item); err != nil {
+				return err
+			}
+		}
+		binary.LittleEndian.PutUint32(e.buf[lenAt:], uint32(len(e.buf)-start))
+	case '(', '{':
+		fields, ok := v.([]interface{})
+		if !ok {
+			return bad()
+		}
+		types, err := dbusSplitSignature(sig[1 : len(sig)-1])
+		if err != nil {
+			return err
+		}
+		if len(types) != len(fields) {
+			return bad()
+		}
+		e.align(8)
+		for i, t := range types {
+			if err := e.encode(t, fields[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("dbus: unsupported signature %q", sig)
+	}
+	return nil
+}
+
+type dbusDecoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+
+	// Containers being decoded, to bound the recursion.
+	arrays, structs, variants int
+}
+
+var (
+	errDBusShort = errors.New("dbus: message too short")
+	errDBusDepth = errors.New("dbus: containers nested too deeply")
+)
+
+// enter counts a container of decode, *n being the containers of its kind,
+// and fails if they are nested too deeply. The returned func leaves it.
+func (d *dbusDecoder) enter(n *int, max int) (func(), error) {
+	if *n >= max || d.arrays+d.structs+d.variants >= dbusMaxContainerDepth {
+		return nil, errDBusDepth
+	}
+	*n++
+	return func() { *n-- }, nil
+}
+
+func (d *dbusDecoder) align(n int) error {
+	for d.pos%n != 0 {
+		d.pos++
+	}
+	if d.pos > len(d.buf) {
+		return errDBusShort
+	}
+	return nil
+}
+
+func (d *dbusDecoder) next(n int) ([]byte, error) {
+	if d.pos+n > len(d.buf) {
+		return nil, errDBusShort
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// length checks that n more bytes are left before converting it to int, so
+// it cannot overflow on 32-bit systems.
+func (d *dbusDecoder) length(n uint32) (int, error) {
+	if uint64(n) > uint64(len(d.buf)-d.pos) {
+		return 0, errDBusShort
+	}
+	return int(n), nil
+}
+
+func (d *dbusDecoder) uint32() (uint32, error) {
+	if err := d.align(4); err != nil {
+		return 0, err
+	}
+	b, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *dbusDecoder) decode(sig string) (interface{}, error) {
+	switch sig[0] {
+	case 'y':
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		u, err := d.uint32()
+		return u != 0, err
+	case 'n', 'q':
+		if err := d.align(2); err != nil {
+			return nil, err
+		}
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'i':
+		u, err := d.uint32()
+		return int32(u), err
+	case 'u', 'h':
+		return d.uint32()
+	case 'x', 't', 'd':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		u := d.order.Uint64(b)
+		switch sig[0] {
+		case 'x':
+			return int64(u), nil
+		case 'd':
+			return math.Float64frombits(u), nil
+		}
+		return u, nil
+	case 's', 'o':
+		u, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.length(u)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(n + 1)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'o' {
+			return dbusObjectPath(b[:n]), nil
+		}
+		return string(b[:n]), nil
+	case 'g':
+		n, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(n[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return dbusSignature(b[:n[0]]), nil
+	case 'v':
+		leave, err := d.enter(&d.variants, dbusMaxContainerDepth)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		s, err := d.decode("g")
+		if err != nil {
+			return nil, err
+		}
+		vsig := string(s.(dbusSignature))
+		if t, rest, err := dbusNextType(vsig); err != nil || rest != "" || t == "" {
+			return nil, fmt.Errorf("dbus: bad variant signature %q", vsig)
+		}
+		v, err := d.decode(vsig)
+		if err != nil {
+			return nil, err
+		}
+		return dbusVariant{Sig: vsig, Value: v}, nil
+	case 'a':
+		leave, err := d.enter(&d.arrays, dbusMaxArrayDepth)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		u, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.align(dbusAlignment(sig[1:])); err != nil {
+			return nil, err
+		}
+		n, err := d.length(u)
+		if err != nil {
+			return nil, err
+		}
+		end := d.pos + n
+		items := []interface{}{}
+		for d.pos < end {
+			start := d.pos
+			item, err := d.decode(sig[1:])
+			if err != nil {
+				return nil, err
+			}
+			if d.pos == start {
+				return nil, fmt.Errorf("dbus: zero-size array element %q", sig[1:])
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case '(', '{':
+		leave, err := d.enter(&d.structs, dbusMaxStructDepth)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		types, err := dbusSplitSignature(sig[1 : len(sig)-1])
+		if err != nil {
+			return nil, err
+		}
+		fields := make([]interface{}, 0, len(types))
+		for _, t := range types {
+			f, err := d.decode(t)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("dbus: unsupported signature %q", sig)
+}
+
+func (m *dbusMessage) marshal() ([]byte, error) {
+	body := &dbusEncoder{}
+	types, err := dbusSplitSignature(m.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) != len(m.Body) {
+		return nil, fmt.Errorf("dbus: signature %q does not match %d values", m.Signature, len(m.Body))
+	}
+	for i, t := range types {
+		if err := body.encode(t, m.Body[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var fields []interface{}
+	field := func(code byte, sig string, v interface{}) {
+		fields = append(fields, []interface{}{code, dbusVariant{Sig: sig, Value: v}})
+	}
+	if m.Path != "" {
+		field(dbusFieldPath, "o", m.Path)
+	}
+	if m.Interface != "" {
+		field(dbusFieldInterface, "s", m.Interface)
+	}
+	if m.Member != "" {
+		field(dbusFieldMember, "s", m.Member)
+	}
+	if m.ErrorName != "" {
+		field(dbusFieldErrorName, "s", m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		field(dbusFieldReplySerial, "u", m.ReplySerial)
+	}
+	if m.Destination != "" {
+		field(dbusFieldDestination, "s", m.Destination)
+	}
+	if m.Signature != "" {
+		field(dbusFieldSignature, "g", m.Signature)
+	}
+
+	e := &dbusEncoder{buf: []byte{'l', m.Type, m.Flags, 1}}
+	e.uint32(uint32(len(body.buf)))
+	e.uint32(m.Serial)
+	if err := e.encode("a(yv)", fields); err != nil {
+		return nil, err
+	}
+	e.align(8)
+	return append(e.buf, body.buf...), nil
+}
+
+func readDBusMessage(r io.Reader) (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("dbus: bad endianness %q", fixed[0])
+	}
+	bodyLen := order.Uint32(fixed[4:])
+	fieldsLen := order.Uint32(fixed[12:])
+	// The protocol limits messages to 128MiB. Check before converting to
+	// int, which is 32 bits on some systems.
+	if uint64(fieldsLen)+uint64(bodyLen) > 1<<27 {
+		return nil, errors.New("dbus: message too large")
+	}
+	headerLen := 16 + int(fieldsLen)
+	if pad := headerLen % 8; pad != 0 {
+		headerLen += 8 - pad
+	}
+	buf := make([]byte, headerLen+int(bodyLen))
+	copy(buf, fixed)
+	if _, err := io.ReadFull(r, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &dbusMessage{
+		Type:   fixed[1],
+		Flags:  fixed[2],
+		Serial: order.Uint32(fixed[8:]),
+	}
+	d := &dbusDecoder{buf: buf[:16+fieldsLen], pos: 12, order: order}
+	v, err := d.decode("a(yv)")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range v.([]interface{}) {
+		f := f.([]interface{})
+		value := f[1].(dbusVariant).Value
+		var ok bool
+		switch f[0].(byte) {
+		case dbusFieldPath:
+			m.Path, ok = value.(dbusObjectPath)
+		case dbusFieldInterface:
+			m.Interface, ok = value.(string)
+		case dbusFieldMember:
+			m.Member, ok = value.(string)
+		case dbusFieldErrorName:
+			m.ErrorName, ok = value.(string)
+		case dbusFieldReplySerial:
+			m.ReplySerial, ok = value.(uint32)
+		case dbusFieldDestination:
+			m.Destination, ok = value.(string)
+		case dbusFieldSender:
+			m.Sender, ok = value.(string)
+		case dbusFieldSignature:
+			var sig dbusSignature
+			sig, ok = value.(dbusSignature)
+			m.Signature = string(sig)
+		default:
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("dbus: bad header field %v", f[0])
+		}
+	}
+
+	types, err := dbusSplitSignature(m.Signature)
+	if err != nil {
+		return nil, err
+	}
+	d = &dbusDecoder{buf: buf[headerLen:], order: order}
+	for _, t := range types {
+		v, err := d.decode(t)
+		if err != nil {
+			return nil, err
+		}
+		m.Body = append(m.Body, v)
+	}
+	return m, nil
+}
+
+// dbusConn is a connection to a message bus.
+type dbusConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+
+	mu      sync.Mutex
+	serial  uint32
+	calls   map[uint32]chan *dbusMessage
+	handler func(*dbusMessage)
+	err     error
+	done    chan struct{}
+}
+
+// dbusSystemBusAddress returns the address of the system bus.
+func dbusSystemBusAddress() string {
+	if addr := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); addr != "" {
+		return addr
+	}
+	return "unix:path=/var/run/dbus/system_bus_socket"
+}
+
+// dbusDialAddress connects to the first reachable unix address in addresses,
+// a semicolon separated list such as "unix:path=/run/dbus/system_bus_socket".
+func dbusDialAddress(addresses string) (net.Conn, error) {
+	err := fmt.Errorf("dbus: no supported address in %q", addresses)
+	for _, address := range strings.Split(addresses, ";") {
+		transport, params, found := strings.Cut(address, ":")
+		if !found || transport != "unix" {
+			continue
+		}
+		var socket string
+		for _, kv := range strings.Split(params, ",") {
+			k, v, _ := strings.Cut(kv, "=")
+			v, uerr := url.PathUnescape(v)
+			if uerr != nil {
+				continue
+			}
+			switch k {
+			case "path":
+				socket = v
+			case "abstract":
+				socket = "@" + v
+			}
+		}
+		if socket == "" {
+			continue
+		}
+		var c net.Conn
+		c, err = net.DialTimeout("unix", socket, dbusCallTimeout)
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// dialDBus connects and authenticates to the bus at address and registers
+// with it.
+func dialDBus(address string) (*dbusConn, error) {
+	conn, err := dbusDialAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	c := &dbusConn{
+		conn:  conn,
+		r:     bufio.NewReader(conn),
+		calls: make(map[uint32]chan *dbusMessage),
+		done:  make(chan struct{}),
+	}
+	conn.SetDeadline(time.Now().Add(dbusCallTimeout))
+	if err := c.auth(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	go c.readLoop()
+
+	_, err = c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", "")
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *dbusConn) auth() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := io.WriteString(c.conn, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		return err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("dbus: authentication failed: %s", strings.TrimSpace(line))
+	}
+	_, err = io.WriteString(c.conn, "BEGIN\r\n")
+	return err
+}
+
+// setHandler sets the function called with every signal and method call
+// received. It runs on the read loop and must not wait on calls.
+func (c *dbusConn) setHandler(h func(*dbusMessage)) {
+	c.mu.Lock()
+	c.handler = h
+	c.mu.Unlock()
+}
+
+func (c *dbusConn) readLoop() {
+	for {
+		m, err := readDBusMessage(c.r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.mu.Lock()
+		switch m.Type {
+		case dbusTypeMethodReturn, dbusTypeError:
+			if ch, ok := c.calls[m.ReplySerial]; ok {
+				delete(c.calls, m.ReplySerial)
+				ch <- m
+			}
+			c.mu.Unlock()
+		default:
+			h := c.handler
+			c.mu.Unlock()
+			if h != nil {
+				h(m)
+			}
+		}
+	}
+}
+
+func (c *dbusConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+}
+
+// send assigns a serial to m and writes it. If reply is non-nil the reply to
+// m is delivered on it.
+func (c *dbusConn) send(m *dbusMessage, reply chan *dbusMessage) error {
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+	c.serial++
+	m.Serial = c.serial
+	if reply != nil {
+		c.calls[m.Serial] = reply
+	}
+	c.mu.Unlock()
+
+	b, err := m.marshal()
+	if err == nil {
+		c.wmu.Lock()
+		_, err = c.conn.Write(b)
+		c.wmu.Unlock()
+	}
+	if err != nil && reply != nil {
+		c.mu.Lock()
+		delete(c.calls, m.Serial)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// call invokes a method and returns the body of the reply.
+func (c *dbusConn) call(dest string, path dbusObjectPath, iface, member, sig string, args ...interface{}) ([]interface{}, error) {
+	reply := make(chan *dbusMessage, 1)
+	req := &dbusMessage{
+		Type:        dbusTypeMethodCall,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: dest,
+		Signature:   sig,
+		Body:        args,
+	}
+	if err := c.send(req, reply); err != nil {
+		return nil, err
+	}
+	var m *dbusMessage
+	select {
+	case m = <-reply:
+	case <-c.done:
+		return nil, c.err
+	case <-time.After(dbusCallTimeout):
+		// A late reply is dropped instead of kept for a call that is gone.
+		c.mu.Lock()
+		delete(c.calls, req.Serial)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("dbus: %s.%s timed out", iface, member)
+	}
+	if m.Type == dbusTypeError {
+		e := &dbusError{Name: m.ErrorName}
+		if len(m.Body) > 0 {
+			e.Message, _ = m.Body[0].(string)
+		}
+		return nil, e
+	}
+	return m.Body, nil
+}
+
+func (c *dbusConn) Close() error {
+	c.fail(errors.New("dbus: connection closed"))
+	return c.conn.Close()
+}
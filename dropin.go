@@ -0,0 +1,43 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import "errors"
+
+// ErrInvalidDropInName is returned when a drop-in name is empty or contains
+// a path separator.
+var ErrInvalidDropInName = errors.New("invalid drop-in name")
+
+// DropIn is a unit override file placed in the "<unit>.d" directory next to
+// the unit file, such as /etc/systemd/system/<name>.service.d/10-limits.conf.
+// Drop-ins are layered on top of the unit written by Install and are left
+// untouched by Install and Uninstall.
+type DropIn struct {
+	// Name of the file inside the drop-in directory. A ".conf" suffix is
+	// added if missing.
+	Name string
+	// Path is the full path of the file. It is set when reading drop-ins.
+	Path string
+	// Content of the file, for example "[Service]\nLimitNOFILE=65536\n".
+	Content string
+}
+
+// DropInManager is implemented by services whose service manager supports
+// drop-in overrides (linux-systemd). Use a type assertion on Service to
+// access it.
+type DropInManager interface {
+	// DropIns returns the drop-ins of the service sorted by name.
+	DropIns() ([]DropIn, error)
+
+	// WriteDropIn creates or replaces a drop-in and reloads the service manager.
+	WriteDropIn(d DropIn) error
+
+	// RemoveDropIn removes a drop-in by name and reloads the service manager.
+	RemoveDropIn(name string) error
+
+	// EffectiveConfig returns the unit file followed by every drop-in as
+	// merged by the service manager.
+	EffectiveConfig() (string, error)
+}
@@ -0,0 +1,233 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrEnvFileNotSupported is returned when the service does not read an
+	// environment file.
+	ErrEnvFileNotSupported = errors.New("environment files are not supported by this service")
+	// ErrInvalidEnvName is returned for names that cannot be set from an
+	// environment file.
+	ErrInvalidEnvName = errors.New("invalid environment variable name")
+)
+
+// envFiler is implemented by services that read an environment file when
+// they start.
+type envFiler interface {
+	envFile() (string, error)
+}
+
+// EnvFile returns the path of the environment file read by s when it starts.
+// It is set with the "EnvFile" option and defaults to /etc/default/<name> on
+// Debian and its derivatives and to /etc/sysconfig/<name> elsewhere.
+// Variables in the file override Config.EnvVars of the same name on every
+// system, as systemd's EnvironmentFile= overrides Environment=.
+func EnvFile(s Service) (string, error) {
+	e, ok := s.(envFiler)
+	if !ok {
+		return "", ErrEnvFileNotSupported
+	}
+	return e.envFile()
+}
+
+// ReadEnvFile returns the variables in the environment file of s. A missing
+// file is not an error.
+func ReadEnvFile(s Service) (map[string]string, error) {
+	path, err := EnvFile(s)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	return parseEnvFile(string(b))
+}
+
+// WriteEnvFile replaces the environment file of s with env. The file is only
+// readable by its owner as it may hold secrets. Restart the service for the
+// change to take effect.
+func WriteEnvFile(s Service, env map[string]string) error {
+	path, err := EnvFile(s)
+	if err != nil {
+		return err
+	}
+	b, err := formatEnvFile(env)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, b, 0600); err != nil {
+		return err
+	}
+	if c, ok := s.(interface{ chown(path string) error }); ok {
+		return c.chown(path)
+	}
+	return nil
+}
+
+// UpdateEnvFile sets the variables in set and removes the names in unset from
+// the environment file of s, keeping the other variables.
+func UpdateEnvFile(s Service, set map[string]string, unset ...string) error {
+	env, err := ReadEnvFile(s)
+	if err != nil {
+		return err
+	}
+	for k, v := range set {
+		env[k] = v
+	}
+	for _, k := range unset {
+		delete(env, k)
+	}
+	return WriteEnvFile(s, env)
+}
+
+// ParseEnvFile parses the NAME=value lines of an environment file. Values may
+// be unquoted, single quoted or double quoted, and lines may start with
+// "export".
+func ParseEnvFile(b []byte) (map[string]string, error) {
+	return parseEnvFile(string(b))
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// envQuote double quotes v so both sh and systemd read it back unchanged.
+func envQuote(v string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range v {
+		switch c {
+		case '"', '\\', '$', '`':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// formatEnvFile returns env as NAME="value" lines sorted by name.
+func formatEnvFile(env map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(env))
+	for k := range env {
+		if !validEnvName(k) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidEnvName, k)
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k + "=" + envQuote(env[k]) + "\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// parseEnvFile parses NAME=value lines as written by formatEnvFile or by
+// hand: values may be unquoted, single quoted or double quoted, and lines
+// may start with "export".
+func parseEnvFile(content string) (map[string]string, error) {
+	env := map[string]string{}
+	r := bufio.NewReader(strings.NewReader(content))
+	line := 0
+	for {
+		text, err := r.ReadString('\n')
+		if text == "" && err != nil {
+			break
+		}
+		line++
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		name, value, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !validEnvName(name) {
+			return nil, fmt.Errorf("env file line %d: %w", line, ErrInvalidEnvName)
+		}
+		if len(value) == 0 || (value[0] != '"' && value[0] != '\'') {
+			env[name] = value
+			continue
+		}
+		// Quoted values may span lines.
+		for !closedQuote(value) {
+			more, err := r.ReadString('\n')
+			if more == "" && err != nil {
+				return nil, fmt.Errorf("env file line %d: unterminated quote", line)
+			}
+			line++
+			value += "\n" + strings.TrimRight(more, "\r\n")
+		}
+		env[name] = unquoteEnv(value)
+	}
+	return env, nil
+}
+
+// closedQuote reports whether the quoted value v ends with its closing quote.
+func closedQuote(v string) bool {
+	q := v[0]
+	for i := 1; i < len(v); i++ {
+		switch {
+		case v[i] == '\\' && q == '"':
+			i++
+		case v[i] == q:
+			return true
+		}
+	}
+	return false
+}
+
+func unquoteEnv(v string) string {
+	q := v[0]
+	var b strings.Builder
+	for i := 1; i < len(v); i++ {
+		c := v[i]
+		if c == q {
+			break
+		}
+		if c == '\\' && q == '"' && i+1 < len(v) {
+			switch v[i+1] {
+			case '"', '\\', '$', '`':
+				i++
+				c = v[i]
+			case '\n':
+				i++
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,21 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import "os"
+
+var debianVersionFile = "/etc/debian_version"
+
+// envFilePath returns the EnvFile option, or the location of environment
+// files for services on this distribution.
+func envFilePath(c *Config) string {
+	if p := c.Option.string(optionEnvFile, ""); p != "" {
+		return p
+	}
+	if _, err := os.Stat(debianVersionFile); err == nil {
+		return "/etc/default/" + c.Name
+	}
+	return "/etc/sysconfig/" + c.Name
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var envFileValues = map[string]string{
+	"PLAIN":   "value",
+	"EMPTY":   "",
+	"SPACES":  "a b  c",
+	"QUOTES":  `say "hi" it's`,
+	"SPECIAL": "$HOME `id` \\n \\",
+	"NEWLINE": "one\ntwo",
+}
+
+func TestEnvFileRoundTrip(t *testing.T) {
+	b, err := formatEnvFile(envFileValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseEnvFile(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, envFileValues) {
+		t.Errorf("round trip = %q, want %q", got, envFileValues)
+	}
+}
+
+func TestEnvFileShell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	dir, err := ioutil.TempDir("", "envfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "env")
+	b, err := formatEnvFile(envFileValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range envFileValues {
+		out, err := exec.Command(sh, "-c", `. "$1"; printf %s "$`+name+`"`, "sh", path).Output()
+		if err != nil {
+			t.Fatalf("sourcing env file: %v", err)
+		}
+		if string(out) != want {
+			t.Errorf("sh read %s = %q, want %q", name, out, want)
+		}
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		err     error
+	}{
+		{"comments", "# comment\n\nA=1\n", map[string]string{"A": "1"}, nil},
+		{"export", "export A=\"x y\"\n", map[string]string{"A": "x y"}, nil},
+		{"single", "A='$B \\'\n", map[string]string{"A": "$B \\"}, nil},
+		{"continuation", "A=\"a\\\nb\"\n", map[string]string{"A": "ab"}, nil},
+		{"invalid", "1A=x\n", nil, ErrInvalidEnvName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnvFile(tt.content)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseEnvFile() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEnvFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
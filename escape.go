@@ -0,0 +1,167 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// escapeFuncs are available to every built-in and custom template:
+//
+//   - sh          POSIX shell word, single quoted when needed.
+//   - shJoin      list of POSIX shell words.
+//   - shCommand   Path and Arguments as a POSIX shell command line.
+//   - comment     text of a # comment line, with line breaks as spaces.
+//   - systemdExec word of a systemd Exec*= line, escaping quotes, % and $.
+//   - systemdEnv  systemd Environment= value from a name and a value.
+//   - systemdText verbatim systemd value such as a path, escaping % specifiers.
+//   - xml         XML text or attribute value.
+var escapeFuncs = template.FuncMap{
+	"sh":          shQuote,
+	"shJoin":      shJoin,
+	"shCommand":   shCommand,
+	"comment":     commentEscape,
+	"systemdExec": systemdExecQuote,
+	"systemdEnv":  systemdEnvQuote,
+	"systemdText": systemdTextEscape,
+	"xml":         xmlEscape,
+}
+
+// withEscapeFuncs returns funcs with escapeFuncs added.
+func withEscapeFuncs(funcs template.FuncMap) template.FuncMap {
+	m := template.FuncMap{}
+	for k, v := range escapeFuncs {
+		m[k] = v
+	}
+	for k, v := range funcs {
+		m[k] = v
+	}
+	return m
+}
+
+// isSafeWord reports whether s needs no quoting, in a shell or systemd.
+func isSafeWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("_@+=:,./-", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// shQuote quotes s as a single POSIX shell word.
+func shQuote(s string) string {
+	if isSafeWord(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shJoin quotes each of words and joins them with spaces.
+func shJoin(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = shQuote(w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shCommand returns path and args as a POSIX shell command line.
+func shCommand(path string, args []string) string {
+	return strings.TrimSuffix(shQuote(path)+" "+shJoin(args), " ")
+}
+
+// commentEscape keeps s on a single comment line of a script.
+func commentEscape(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+// systemdCQuote returns s in double quotes with C escapes, as read by systemd.
+func systemdCQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// systemdExecQuote quotes s as a single word of a systemd Exec*= line.
+// systemd expands % specifiers and $ variables there.
+func systemdExecQuote(s string) string {
+	if !isSafeWord(s) {
+		s = systemdCQuote(s)
+	}
+	s = strings.Replace(s, "%", "%%", -1)
+	return strings.Replace(s, "$", "$$", -1)
+}
+
+// systemdEnvQuote returns the quoted "name=value" assignment of a systemd
+// Environment= line.
+func systemdEnvQuote(name, value string) string {
+	return strings.Replace(systemdCQuote(name+"="+value), "%", "%%", -1)
+}
+
+// systemdTextEscape escapes % specifiers in a systemd setting that takes the
+// rest of the line verbatim, such as WorkingDirectory= or Description=. Such
+// a setting cannot hold a line break, so control characters, which
+// validateConfig rejects, are replaced by spaces rather than let s add lines
+// to the unit.
+func systemdTextEscape(s string) string {
+	s = strings.Map(func(c rune) rune {
+		if c < 0x20 || c == 0x7f {
+			return ' '
+		}
+		return c
+	}, s)
+	return strings.Replace(s, "%", "%%", -1)
+}
+
+// credEscape escapes the data of a systemd SetCredential= line, which is
+// C unescaped but not specifier expanded.
+func credEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// xmlEscape escapes s for XML text and attribute values.
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"encoding/xml"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var escapeWords = []string{
+	"plain",
+	"",
+	"a b",
+	`say "hi"`,
+	"it's",
+	"$HOME ${PATH} `id` $(id)",
+	"100% %n",
+	`back\slash\n`,
+	"new\nline\ttab",
+	"* ? [a] ~ ; & | < > # !",
+}
+
+func TestShQuoteRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	printArgs := `for a; do printf '%s\0' "$a"; done`
+	read := func(script string) []string {
+		out, err := exec.Command(sh, "-c", script).Output()
+		if err != nil {
+			t.Fatalf("running %q: %v", script, err)
+		}
+		return strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	}
+
+	for _, w := range escapeWords {
+		if got := read("printf '%s\\0' " + shQuote(w)); !reflect.DeepEqual(got, []string{w}) {
+			t.Errorf("sh read %s = %q, want %q", shQuote(w), got, w)
+		}
+	}
+	// Init scripts keep the command in a variable and eval it, as does rc.subr
+	// with command_args.
+	cmd := shCommand("set", append([]string{"--"}, escapeWords...))
+	if got := read("cmd=" + shQuote(cmd) + "; eval \"$cmd\"; " + printArgs); !reflect.DeepEqual(got, escapeWords) {
+		t.Errorf("eval %s = %q, want %q", cmd, got, escapeWords)
+	}
+	// OpenRC splits command_args before it evals them, so whitespace other
+	// than single spaces does not survive.
+	var args []string
+	for _, w := range escapeWords {
+		if !strings.ContainsAny(w, "\n\t") {
+			args = append(args, w)
+		}
+	}
+	if got := read("command_args=" + shQuote(shJoin(args)) + "; eval set -- $command_args; " + printArgs); !reflect.DeepEqual(got, args) {
+		t.Errorf("command_args = %q, want %q", got, args)
+	}
+}
+
+func TestSystemdEscape(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func(string) string
+		in   string
+		want string
+	}{
+		{"exec plain", systemdExecQuote, "/usr/bin/demo", "/usr/bin/demo"},
+		{"exec space", systemdExecQuote, "/opt/my app/demo", `"/opt/my app/demo"`},
+		{"exec quotes", systemdExecQuote, `say "hi" \o/`, `"say \"hi\" \\o/"`},
+		{"exec specifier", systemdExecQuote, "100%", `"100%%"`},
+		{"exec variable", systemdExecQuote, "$HOME", `"$$HOME"`},
+		{"exec empty", systemdExecQuote, "", `""`},
+		{"exec newline", systemdExecQuote, "a\nb", `"a\nb"`},
+		{"text", systemdTextEscape, "/srv/100% done", "/srv/100%% done"},
+		{"text newline", systemdTextEscape, "demo\nExecStartPre=/bin/sh", "demo ExecStartPre=/bin/sh"},
+		{"text control", systemdTextEscape, "a\r\x00\tb", "a   b"},
+		{"env", func(v string) string { return systemdEnvQuote("KEY", v) }, `a "b" 5% $c`, `"KEY=a \"b\" 5%% $c"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.f(tt.in); got != tt.want {
+				t.Errorf("escape(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXMLEscapeRoundTrip(t *testing.T) {
+	for _, w := range escapeWords {
+		var v struct {
+			Attr string `xml:"a,attr"`
+			Text string `xml:",chardata"`
+		}
+		doc := "<v a='" + xmlEscape(w) + "'>" + xmlEscape(w) + "</v>"
+		if err := xml.Unmarshal([]byte(doc), &v); err != nil {
+			t.Fatalf("parsing %s: %v", doc, err)
+		}
+		if v.Attr != w || v.Text != w {
+			t.Errorf("xml read %s = %q, %q, want %q", doc, v.Attr, v.Text, w)
+		}
+	}
+}
@@ -62,9 +62,6 @@ func (p *program) Stop(s service.Service) error {
 //	Handle service controls (optional).
 //	Run the service.
 func main() {
-	svcFlag := flag.String("service", "", "Control the system service.")
-	flag.Parse()
-
 	options := make(service.KeyValue)
 	options["Restart"] = "on-success"
 	options["SuccessExitStatus"] = "1 2 8 SIGKILL"
@@ -78,36 +75,15 @@ func main() {
 		Option: options,
 	}
 
-	prg := &program{}
-	s, err := service.New(prg, svcConfig)
+	cl, err := service.NewCommandLine(&program{}, svcConfig, &service.MainOptions{
+		Logger: &logger,
+		Errors: func(err error) { log.Print(err) },
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
-	errs := make(chan error, 5)
-	logger, err = s.Logger(errs)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	go func() {
-		for {
-			err := <-errs
-			if err != nil {
-				log.Print(err)
-			}
-		}
-	}()
-
-	if len(*svcFlag) != 0 {
-		err := service.Control(s, *svcFlag)
-		if err != nil {
-			log.Printf("Valid actions: %q\n", service.ControlAction)
-			log.Fatal(err)
-		}
-		return
-	}
-	err = s.Run()
-	if err != nil {
-		logger.Error(err)
-	}
+	// Select the command with -service, for example -service=install.
+	cl.Flag(flag.CommandLine, "service")
+	flag.Parse()
+	cl.Exit(cl.Execute(flag.Args()))
 }
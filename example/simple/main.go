@@ -6,8 +6,6 @@
 package main
 
 import (
-	"log"
-
 	"github.com/kardianos/service"
 )
 
@@ -35,17 +33,5 @@ func main() {
 		Description: "This is an example Go service.",
 	}
 
-	prg := &program{}
-	s, err := service.New(prg, svcConfig)
-	if err != nil {
-		log.Fatal(err)
-	}
-	logger, err = s.Logger(nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = s.Run()
-	if err != nil {
-		logger.Error(err)
-	}
+	service.Main(&program{}, svcConfig, &service.MainOptions{Logger: &logger})
 }
@@ -6,8 +6,6 @@
 package main
 
 import (
-	"log"
-	"os"
 	"time"
 
 	"github.com/kardianos/service"
@@ -38,25 +36,5 @@ func main() {
 		Description: "This is an example Go service that pauses on stop.",
 	}
 
-	prg := &program{}
-	s, err := service.New(prg, svcConfig)
-	if err != nil {
-		log.Fatal(err)
-	}
-	if len(os.Args) > 1 {
-		err = service.Control(s, os.Args[1])
-		if err != nil {
-			log.Fatal(err)
-		}
-		return
-	}
-
-	logger, err = s.Logger(nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = s.Run()
-	if err != nil {
-		logger.Error(err)
-	}
+	service.Main(&program{}, svcConfig, &service.MainOptions{Logger: &logger})
 }
@@ -0,0 +1,382 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files so they sort by age.
+const backupTimeFormat = "20060102T150405.000"
+
+// openLogFile opens the log file. Tests replace it to make opening fail.
+var openLogFile = os.OpenFile
+
+// FileLoggerConfig configures a FileLogger.
+type FileLoggerConfig struct {
+	// Path of the log file. Its directory is created if missing.
+	Path string
+	// MaxSize is the size in bytes the file is rotated at. Never if zero.
+	MaxSize int64
+	// MaxAge is how long the file is written to before it is rotated,
+	// measured from when it was opened. Never if zero.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept. All if zero.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+	// Level is the initial level of the logger.
+	Level Level
+}
+
+// FileLogger is a Logger appending to a file, for systems that do not
+// capture the output of a service. Rotated files are renamed to
+// Path.<time> and gzipped to Path.<time>.gz with Compress. On Unix the file
+// is reopened on SIGHUP, after it was moved by logrotate for example.
+//
+// FileLogger is an io.Writer of whole lines and is safe for concurrent use.
+type FileLogger struct {
+	c    FileLoggerConfig
+	errs chan<- error
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	closed bool
+
+	hup  chan os.Signal
+	done chan struct{}
+	wg   sync.WaitGroup // compressions in progress
+
+	*levelVar
+}
+
+// NewFileLogger opens the log file of c. If errs is non-nil errors will be
+// sent on errs as well as returned from Logger's functions. Errors of the
+// background reopening and compression are dropped while errs is full.
+func NewFileLogger(c FileLoggerConfig, errs chan<- error) (*FileLogger, error) {
+	l := &FileLogger{c: c, errs: errs, done: make(chan struct{}), levelVar: newLevelVar(c.Level)}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	l.hup = make(chan os.Signal, 1)
+	notifyReopen(l.hup)
+	go func() {
+		for {
+			select {
+			case <-l.hup:
+				l.report(l.Reopen())
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return l, nil
+}
+
+// open opens the file at Path. l.f is left as is if it fails.
+func (l *FileLogger) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.c.Path), 0755); err != nil {
+		return err
+	}
+	f, err := openLogFile(l.c.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it over
+// MaxSize or it is older than MaxAge.
+func (l *FileLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, os.ErrClosed
+	}
+	if l.size > 0 && (l.c.MaxSize > 0 && l.size+int64(len(p)) > l.c.MaxSize ||
+		l.c.MaxAge > 0 && time.Since(l.opened) >= l.c.MaxAge) {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Rotate renames the file to a backup and opens a new one.
+func (l *FileLogger) Rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return os.ErrClosed
+	}
+	return l.rotate()
+}
+
+func (l *FileLogger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	backup := l.c.Path + "." + time.Now().Format(backupTimeFormat)
+	for i := 1; exists(backup) || exists(backup+".gz"); i++ {
+		backup = fmt.Sprintf("%s.%s-%d", l.c.Path, time.Now().Format(backupTimeFormat), i)
+	}
+	if err := os.Rename(l.c.Path, backup); err != nil && !os.IsNotExist(err) {
+		return l.restore(err, "")
+	}
+	if err := l.open(); err != nil {
+		return l.restore(err, backup)
+	}
+	if !l.c.Compress {
+		return l.prune()
+	}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		if err := compress(backup); err != nil {
+			l.report(err)
+			return
+		}
+		l.mu.Lock()
+		err := l.prune()
+		l.mu.Unlock()
+		l.report(err)
+	}()
+	return nil
+}
+
+// restore reopens the file after the rotation failed with err, first moving
+// backup, if set, back to Path, so writes go on to the old file. The file is
+// rotated again on the next write.
+func (l *FileLogger) restore(err error, backup string) error {
+	if backup != "" {
+		if rerr := os.Rename(backup, l.c.Path); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+	}
+	if rerr := l.open(); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+	return err
+}
+
+// prune removes the oldest backups over MaxBackups.
+func (l *FileLogger) prune() error {
+	if l.c.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := l.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > l.c.MaxBackups {
+		for _, path := range backups[0].paths {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backup is a rotated file. paths holds Path.<time>[-<n>], its .gz or both
+// while it is compressed.
+type backup struct {
+	time  time.Time
+	n     int
+	paths []string
+}
+
+// backups returns the rotated files of the log, oldest first. Other files
+// starting with the name of the log are left out.
+func (l *FileLogger) backups() ([]*backup, error) {
+	dir, base := filepath.Split(l.c.Path)
+	fis, err := ioutil.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*backup{}
+	var backups []*backup
+	for _, fi := range fis {
+		name := strings.TrimSuffix(fi.Name(), ".gz")
+		stamp, ok := strings.CutPrefix(name, base+".")
+		if !ok {
+			continue
+		}
+		n := 0
+		if i := strings.LastIndexByte(stamp, '-'); i >= 0 {
+			if n, err = strconv.Atoi(stamp[i+1:]); err != nil || n < 1 {
+				continue
+			}
+			stamp = stamp[:i]
+		}
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		b := byName[name]
+		if b == nil {
+			b = &backup{time: t, n: n}
+			byName[name] = b
+			backups = append(backups, b)
+		}
+		b.paths = append(b.paths, filepath.Join(dir, fi.Name()))
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].time.Equal(backups[j].time) {
+			return backups[i].time.Before(backups[j].time)
+		}
+		return backups[i].n < backups[j].n
+	})
+	return backups, nil
+}
+
+// Reopen closes and reopens the file, creating it if it was moved. The old
+// file is kept open if the new one cannot be opened.
+func (l *FileLogger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return os.ErrClosed
+	}
+	old := l.f
+	if err := l.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// Close closes the file once pending compressions are done.
+func (l *FileLogger) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	stopReopen(l.hup)
+	close(l.done)
+	l.mu.Unlock()
+	l.wg.Wait()
+	return l.f.Close()
+}
+
+func (l *FileLogger) writesToStd() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.closed && isStdStream(l.f)
+}
+
+func (l *FileLogger) send(err error) error {
+	if err != nil && l.errs != nil {
+		l.errs <- err
+	}
+	return err
+}
+
+// report sends an error of the background work on errs, dropping it if errs
+// is full so that reopening and compressing never stall.
+func (l *FileLogger) report(err error) {
+	if err == nil || l.errs == nil {
+		return
+	}
+	select {
+	case l.errs <- err:
+	default:
+	}
+}
+
+func (l *FileLogger) log(level Level, prefix, msg string) error {
+	if !l.enabled(level) {
+		return nil
+	}
+	line := time.Now().Format(time.RFC3339) + " " + prefix + " " + msg
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	_, err := l.Write([]byte(line))
+	return l.send(err)
+}
+
+func (l *FileLogger) Error(v ...interface{}) error {
+	return l.log(LevelError, "E:", fmt.Sprint(v...))
+}
+func (l *FileLogger) Warning(v ...interface{}) error {
+	return l.log(LevelWarning, "W:", fmt.Sprint(v...))
+}
+func (l *FileLogger) Info(v ...interface{}) error {
+	return l.log(LevelInfo, "I:", fmt.Sprint(v...))
+}
+func (l *FileLogger) Debug(v ...interface{}) error {
+	return l.log(LevelDebug, "D:", fmt.Sprint(v...))
+}
+func (l *FileLogger) Errorf(format string, a ...interface{}) error {
+	return l.log(LevelError, "E:", fmt.Sprintf(format, a...))
+}
+func (l *FileLogger) Warningf(format string, a ...interface{}) error {
+	return l.log(LevelWarning, "W:", fmt.Sprintf(format, a...))
+}
+func (l *FileLogger) Infof(format string, a ...interface{}) error {
+	return l.log(LevelInfo, "I:", fmt.Sprintf(format, a...))
+}
+func (l *FileLogger) Debugf(format string, a ...interface{}) error {
+	return l.log(LevelDebug, "D:", fmt.Sprintf(format, a...))
+}
+
+// compress gzips path to path.gz and removes path.
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(path)
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,14 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+// +build !unix
+
+package service
+
+import "os"
+
+func notifyReopen(c chan os.Signal) {}
+
+func stopReopen(c chan os.Signal) {}
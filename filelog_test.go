@@ -0,0 +1,282 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempLogDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "filelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// logFiles returns the names of the files in dir, sorted.
+func logFiles(t *testing.T, dir string) []string {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFileLoggerRotate(t *testing.T) {
+	dir := tempLogDir(t)
+	l, err := NewFileLogger(FileLoggerConfig{Path: filepath.Join(dir, "demo.log"), MaxSize: 100, MaxBackups: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := l.Infof("line %d", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names := logFiles(t, dir)
+	if len(names) != 3 || names[0] != "demo.log" || !strings.HasPrefix(names[1], "demo.log.") || !strings.HasPrefix(names[2], "demo.log.") {
+		t.Fatalf("files = %q, want demo.log and 2 backups", names)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "demo.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) > 100 || !strings.HasSuffix(string(b), " I: line 19\n") {
+		t.Errorf("demo.log = %q", b)
+	}
+	if err := l.Info("after close"); err != os.ErrClosed {
+		t.Errorf("Info after Close = %v, want os.ErrClosed", err)
+	}
+}
+
+func TestFileLoggerCompress(t *testing.T) {
+	dir := tempLogDir(t)
+	path := filepath.Join(dir, "demo.log")
+	l, err := NewFileLogger(FileLoggerConfig{Path: path, Compress: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Warning("first")
+	if err := l.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	l.Error("second")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names := logFiles(t, dir)
+	if len(names) != 2 || !strings.HasSuffix(names[1], ".gz") {
+		t.Fatalf("files = %q, want demo.log and a gzipped backup", names)
+	}
+	f, err := os.Open(filepath.Join(dir, names[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil || !strings.HasSuffix(string(b), " W: first\n") {
+		t.Errorf("backup = %q, %v", b, err)
+	}
+}
+
+func TestFileLoggerMaxAge(t *testing.T) {
+	dir := tempLogDir(t)
+	l, err := NewFileLogger(FileLoggerConfig{Path: filepath.Join(dir, "demo.log"), MaxAge: 20 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Info("old")
+	time.Sleep(30 * time.Millisecond)
+	l.Info("new")
+	if names := logFiles(t, dir); len(names) != 2 {
+		t.Errorf("files = %q, want the file rotated by age", names)
+	}
+}
+
+func TestFileLoggerReopen(t *testing.T) {
+	dir := tempLogDir(t)
+	path := filepath.Join(dir, "demo.log")
+	l, err := NewFileLogger(FileLoggerConfig{Path: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Info("before")
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("after")
+	b, err := ioutil.ReadFile(path)
+	if err != nil || strings.Contains(string(b), "before") || !strings.Contains(string(b), "after") {
+		t.Errorf("reopened file = %q, %v", b, err)
+	}
+}
+
+func TestFileLoggerReopenFails(t *testing.T) {
+	dir := tempLogDir(t)
+	path := filepath.Join(dir, "demo.log")
+	l, err := NewFileLogger(FileLoggerConfig{Path: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Info("before")
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	// A directory in place of the file cannot be opened for writing.
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err == nil {
+		t.Fatal("Reopen() succeeded over a directory")
+	}
+	if err := l.Info("after"); err != nil {
+		t.Fatalf("Info() after a failed Reopen = %v", err)
+	}
+	b, err := ioutil.ReadFile(path + ".moved")
+	if err != nil || !strings.Contains(string(b), "before") || !strings.Contains(string(b), "after") {
+		t.Errorf("old file = %q, %v, want both lines", b, err)
+	}
+}
+
+func TestFileLoggerRotateFails(t *testing.T) {
+	dir := tempLogDir(t)
+	path := filepath.Join(dir, "demo.log")
+	l, err := NewFileLogger(FileLoggerConfig{Path: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Info("before")
+	open := openLogFile
+	defer func() { openLogFile = open }()
+	fails := 1
+	openLogFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if fails > 0 {
+			fails--
+			return nil, os.ErrPermission
+		}
+		return open(name, flag, perm)
+	}
+	if err := l.Rotate(); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("Rotate() = %v, want %v", err, os.ErrPermission)
+	}
+	if err := l.Info("after"); err != nil {
+		t.Fatalf("Info() after a failed Rotate = %v", err)
+	}
+	if names := logFiles(t, dir); len(names) != 1 || names[0] != "demo.log" {
+		t.Errorf("files = %q, want only demo.log", names)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(b), "before") || !strings.Contains(string(b), "after") {
+		t.Errorf("log file = %q, %v, want both lines", b, err)
+	}
+}
+
+func TestFileLoggerConcurrent(t *testing.T) {
+	dir := tempLogDir(t)
+	path := filepath.Join(dir, "demo.log")
+	l, err := NewFileLogger(FileLoggerConfig{Path: path, MaxSize: 1000}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				l.Infof("goroutine %d line %d", g, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+	l.Close()
+	lines := 0
+	for _, name := range logFiles(t, dir) {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			if !strings.Contains(line, " I: goroutine ") {
+				t.Fatalf("%s: interleaved line %q", name, line)
+			}
+			lines++
+		}
+	}
+	if lines != 400 {
+		t.Errorf("%d lines written, want 400", lines)
+	}
+}
+
+func TestFileLoggerPrune(t *testing.T) {
+	dir := tempLogDir(t)
+	path := filepath.Join(dir, "demo.log")
+	for _, name := range []string{
+		"demo.log.20240101T000000.000-2",
+		"demo.log.20240101T000000.000-10",
+		"demo.log.20240101T000000.000.gz",
+		"demo.log.20240102T000000.000",    // being compressed:
+		"demo.log.20240102T000000.000.gz", // one backup
+		"demo.log.bak",
+		"demo.log.old.gz",
+		"demo.log.20240101T000000.000-x",
+		"other.log.20240101T000000.000",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := NewFileLogger(FileLoggerConfig{Path: path, MaxBackups: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.prune(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"demo.log",
+		"demo.log.20240101T000000.000-10",
+		"demo.log.20240101T000000.000-x",
+		"demo.log.20240102T000000.000",
+		"demo.log.20240102T000000.000.gz",
+		"demo.log.bak",
+		"demo.log.old.gz",
+		"other.log.20240101T000000.000",
+	}
+	if names := logFiles(t, dir); strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("files = %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+// +build unix
+
+package service
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReopen relays SIGHUP to c.
+func notifyReopen(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
+
+func stopReopen(c chan os.Signal) {
+	signal.Stop(c)
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin || solaris || aix || freebsd
+// +build linux darwin solaris aix freebsd
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewLoggerLogFile(t *testing.T) {
+	dir := tempLogDir(t)
+	c := &Config{Name: "demo", Option: KeyValue{
+		optionLogFile:       true,
+		optionLogDirectory:  dir,
+		optionLogMaxSize:    1 << 10,
+		optionLogMaxAge:     "24h",
+		optionLogMaxBackups: 3,
+		optionLogCompress:   true,
+		optionLogLevel:      "debug",
+	}}
+	logger, err := newLogger(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, ok := logger.(*FileLogger)
+	if !ok {
+		t.Fatalf("newLogger() = %T, want *FileLogger", logger)
+	}
+	defer l.Close()
+	want := FileLoggerConfig{Path: filepath.Join(dir, "demo.log"), MaxSize: 1 << 10, MaxAge: 24 * time.Hour, MaxBackups: 3, Compress: true, Level: LevelDebug}
+	if l.c != want {
+		t.Errorf("config = %+v, want %+v", l.c, want)
+	}
+
+	c.Option[optionLogMaxAge] = "a day"
+	if _, err := newLogger(c, nil); err == nil {
+		t.Error("newLogger() accepted an invalid LogMaxAge")
+	}
+}
+
+func TestToggleDebug(t *testing.T) {
+	l, err := NewFileLogger(FileLoggerConfig{Path: filepath.Join(tempLogDir(t), "demo.log"), Level: LevelWarning}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stop := ToggleDebug(l, syscall.SIGUSR1)
+	defer stop()
+	waitLevel := func(want Level) {
+		t.Helper()
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for l.Level() != want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := l.Level(); got != want {
+			t.Fatalf("level after SIGUSR1 = %v, want %v", got, want)
+		}
+	}
+	waitLevel(LevelDebug)
+	waitLevel(LevelWarning)
+}
+
+func TestFileLoggerSIGHUP(t *testing.T) {
+	dir := tempLogDir(t)
+	path := filepath.Join(dir, "demo.log")
+	l, err := NewFileLogger(FileLoggerConfig{Path: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !exists(path) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.Info("after")
+	b, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(b), "after") {
+		t.Errorf("file after SIGHUP = %q, %v", b, err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InstallError is returned by Install when one of its steps fails. The steps
+// done before the failure have been undone, in reverse order.
+type InstallError struct {
+	// Step is the step that failed.
+	Step string
+	// Err is the error returned by Step.
+	Err error
+	// Done lists the steps that succeeded before Step.
+	Done []string
+	// RolledBack lists the steps of Done that have been undone.
+	RolledBack []string
+	// RollbackErrs holds the errors of steps that could not be undone.
+	RollbackErrs []error
+}
+
+func (e *InstallError) Error() string {
+	msg := fmt.Sprintf("install failed at %s: %v", e.Step, e.Err)
+	if len(e.RolledBack) > 0 {
+		msg += "; rolled back: " + strings.Join(e.RolledBack, ", ")
+	}
+	for _, err := range e.RollbackErrs {
+		msg += "; rollback: " + err.Error()
+	}
+	return msg
+}
+
+func (e *InstallError) Unwrap() error {
+	return e.Err
+}
+
+// installTx records the steps of an Install so they can be undone.
+type installTx struct {
+	done []string
+	undo []func() error
+}
+
+// do runs fn as the step name. If fn fails, the steps done so far are undone
+// and an *InstallError is returned. undo reverses fn and may be nil.
+func (tx *installTx) do(name string, fn func() error, undo func() error) error {
+	if err := fn(); err != nil {
+		return tx.fail(name, err)
+	}
+	tx.done = append(tx.done, name)
+	tx.undo = append(tx.undo, undo)
+	return nil
+}
+
+// fail undoes the steps done so far and returns an *InstallError for the
+// failed step.
+func (tx *installTx) fail(step string, err error) error {
+	e := &InstallError{Step: step, Err: err, Done: tx.done}
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if tx.undo[i] == nil {
+			continue
+		}
+		if uerr := tx.undo[i](); uerr != nil {
+			e.RollbackErrs = append(e.RollbackErrs, fmt.Errorf("%s: %w", tx.done[i], uerr))
+			continue
+		}
+		e.RolledBack = append(e.RolledBack, tx.done[i])
+	}
+	tx.done, tx.undo = nil, nil
+	return e
+}
+
+// writeFile atomically writes data to path as a step. Undoing it restores
+// the previous content of path, or removes path if it did not exist.
+func (tx *installTx) writeFile(path string, data []byte, perm os.FileMode) error {
+	old, readErr := ioutil.ReadFile(path)
+	var oldMode os.FileMode
+	if fi, err := os.Stat(path); err == nil {
+		oldMode = fi.Mode().Perm()
+	}
+	return tx.do("write "+path, func() error {
+		return writeFileAtomic(path, data, perm)
+	}, func() error {
+		if readErr != nil {
+			return os.Remove(path)
+		}
+		return writeFileAtomic(path, old, oldMode)
+	})
+}
+
+// symlink creates newname pointing to oldname as a step.
+func (tx *installTx) symlink(oldname, newname string) error {
+	return tx.do("link "+newname, func() error {
+		return os.Symlink(oldname, newname)
+	}, func() error {
+		return os.Remove(newname)
+	})
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so readers see either the old or the new content.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		// TempFile creates the file with mode 0600 regardless of umask.
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
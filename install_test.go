@@ -0,0 +1,106 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "demo")
+
+	if err := writeFileAtomic(path, []byte("a long first version\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("short\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "short\n" {
+		t.Errorf("content = %q, want %q", b, "short\n")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0644))
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %d entries", len(entries))
+	}
+}
+
+func TestInstallTxRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	existing := filepath.Join(dir, "existing")
+	if err := ioutil.WriteFile(existing, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	created := filepath.Join(dir, "created")
+	link := filepath.Join(dir, "link")
+
+	tx := &installTx{}
+	if err := tx.writeFile(created, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.writeFile(existing, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.symlink(created, link); err != nil {
+		t.Fatal(err)
+	}
+	errEnable := errors.New("enable failed")
+	err = tx.do("enable", func() error { return errEnable }, nil)
+
+	var ie *InstallError
+	if !errors.As(err, &ie) {
+		t.Fatalf("error = %v, want *InstallError", err)
+	}
+	if ie.Step != "enable" || !errors.Is(err, errEnable) {
+		t.Errorf("Step = %q, Err = %v", ie.Step, ie.Err)
+	}
+	want := []string{"link " + link, "write " + existing, "write " + created}
+	if !reflect.DeepEqual(ie.RolledBack, want) {
+		t.Errorf("RolledBack = %v, want %v", ie.RolledBack, want)
+	}
+	if len(ie.Done) != 3 || len(ie.RollbackErrs) != 0 {
+		t.Errorf("Done = %v, RollbackErrs = %v", ie.Done, ie.RollbackErrs)
+	}
+
+	if _, err := os.Lstat(created); !os.IsNotExist(err) {
+		t.Errorf("created file not removed: %v", err)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("link not removed: %v", err)
+	}
+	b, err := ioutil.ReadFile(existing)
+	if err != nil || string(b) != "old" {
+		t.Errorf("existing file = %q, %v, want %q", b, err, "old")
+	}
+	if fi, err := os.Stat(existing); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("existing file mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the severity threshold of a Logger. Messages below the level of a
+// logger are discarded. The zero Level is LevelInfo.
+type Level int32
+
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+// LogLevelEnv is the environment variable setting the level of the built-in
+// loggers at startup, over the LogLevel option.
+var LogLevelEnv = "SERVICE_LOG_LEVEL"
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarning:
+		return "warning"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("Level(%d)", int32(l))
+}
+
+// ParseLevel returns the level named s: debug, info, warning (or warn) or
+// error, in any case.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (l *Level) UnmarshalText(b []byte) error {
+	v, err := ParseLevel(string(b))
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+// DebugLogger is a Logger with a debug level. The built-in loggers implement
+// it; use Debug and Debugf to log with any Logger.
+type DebugLogger interface {
+	Logger
+	Debug(v ...interface{}) error
+	Debugf(format string, a ...interface{}) error
+}
+
+// LevelLogger is a Logger whose level can change while it is used. The
+// built-in loggers implement it.
+type LevelLogger interface {
+	Logger
+	Level() Level
+	SetLevel(level Level)
+}
+
+// Debug logs v with l if it is a DebugLogger.
+func Debug(l Logger, v ...interface{}) error {
+	if d, ok := l.(DebugLogger); ok {
+		return d.Debug(v...)
+	}
+	return nil
+}
+
+// Debugf logs with l if it is a DebugLogger.
+func Debugf(l Logger, format string, a ...interface{}) error {
+	if d, ok := l.(DebugLogger); ok {
+		return d.Debugf(format, a...)
+	}
+	return nil
+}
+
+// SetLevel sets the level of l and reports whether l is a LevelLogger.
+func SetLevel(l Logger, level Level) bool {
+	ll, ok := l.(LevelLogger)
+	if ok {
+		ll.SetLevel(level)
+	}
+	return ok
+}
+
+// ToggleDebug switches l between LevelDebug and its previous level on each
+// of the signals sig, such as syscall.SIGUSR1. stop stops the toggling
+// without changing the level.
+func ToggleDebug(l Logger, sig ...os.Signal) (stop func()) {
+	ll, ok := l.(LevelLogger)
+	if !ok || len(sig) == 0 {
+		return func() {}
+	}
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, sig...)
+	go func() {
+		prev := ll.Level()
+		for {
+			select {
+			case <-c:
+				if cur := ll.Level(); cur != LevelDebug {
+					prev = cur
+					ll.SetLevel(LevelDebug)
+				} else {
+					ll.SetLevel(prev)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once int32
+	return func() {
+		if atomic.CompareAndSwapInt32(&once, 0, 1) {
+			signal.Stop(c)
+			close(done)
+		}
+	}
+}
+
+// levelVar holds the level of a logger, shared by its copies. A nil levelVar
+// is at LevelInfo.
+type levelVar struct {
+	v int32
+}
+
+func newLevelVar(level Level) *levelVar {
+	return &levelVar{v: int32(level)}
+}
+
+func (v *levelVar) Level() Level {
+	if v == nil {
+		return LevelInfo
+	}
+	return Level(atomic.LoadInt32(&v.v))
+}
+
+// SetLevel does nothing on a nil levelVar, which stays at LevelInfo.
+func (v *levelVar) SetLevel(level Level) {
+	if v == nil {
+		return
+	}
+	atomic.StoreInt32(&v.v, int32(level))
+}
+
+func (v *levelVar) enabled(level Level) bool {
+	return level >= v.Level()
+}
+
+// envLevel returns the level of LogLevelEnv, or def if it is not set or
+// not valid.
+func envLevel(def Level) Level {
+	if s := os.Getenv(LogLevelEnv); s != "" {
+		if l, err := ParseLevel(s); err == nil {
+			return l
+		}
+	}
+	return def
+}
+
+// configLevel returns the startup level of the loggers of c.
+func configLevel(c *Config) Level {
+	def := LevelInfo
+	if l, err := ParseLevel(c.Option.string(optionLogLevel, "")); err == nil {
+		def = l
+	}
+	return envLevel(def)
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Level
+		ok   bool
+	}{
+		{"debug", LevelDebug, true},
+		{"INFO", LevelInfo, true},
+		{" warn ", LevelWarning, true},
+		{"warning", LevelWarning, true},
+		{"error", LevelError, true},
+		{"", 0, false},
+		{"trace", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+	for _, l := range []Level{LevelDebug, LevelInfo, LevelWarning, LevelError} {
+		var got Level
+		b, _ := l.MarshalText()
+		if err := got.UnmarshalText(b); err != nil || got != l {
+			t.Errorf("%v round trips to %v, %v", l, got, err)
+		}
+	}
+}
+
+func TestConfigLevel(t *testing.T) {
+	c := &Config{Option: KeyValue{optionLogLevel: "warning"}}
+	t.Setenv(LogLevelEnv, "")
+	if got := configLevel(c); got != LevelWarning {
+		t.Errorf("configLevel() = %v, want the option", got)
+	}
+	t.Setenv(LogLevelEnv, "debug")
+	if got := configLevel(c); got != LevelDebug {
+		t.Errorf("configLevel() = %v, want the environment", got)
+	}
+	t.Setenv(LogLevelEnv, "bogus")
+	if got := configLevel(&Config{}); got != LevelInfo {
+		t.Errorf("configLevel() = %v, want info", got)
+	}
+	if got := (&Config{POSIX: &POSIXOptions{LogLevel: LevelError}}).Options()[optionLogLevel]; got != "error" {
+		t.Errorf("POSIXOptions.LogLevel is option %v", got)
+	}
+}
+
+func TestConsoleLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	var l Logger = NewConsoleLogger(ConsoleConfig{Writer: &buf, TimeFormat: "-"})
+	Debug(l, "hidden")
+	l.Info("info")
+	SetLevel(l, LevelDebug)
+	Debugf(l, "%s", "shown")
+	SetLevel(l, LevelError)
+	l.Warning("hidden")
+	l.Errorf("error")
+	if got, want := buf.String(), "I: info\nD: shown\nE: error\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestFileLoggerLevel(t *testing.T) {
+	path := filepath.Join(tempLogDir(t), "demo.log")
+	l, err := NewFileLogger(FileLoggerConfig{Path: path, Level: LevelDebug}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("one")
+	l.SetLevel(LevelWarning)
+	l.Infof("two")
+	l.Warning("three")
+	l.Close()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		got = append(got, line[strings.Index(line, " ")+1:])
+	}
+	if want := "D: one|W: three"; strings.Join(got, "|") != want {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestSyslogLoggerLevel(t *testing.T) {
+	l, err := NewSyslogLogger(SyslogConfig{Network: "udp", Address: "127.0.0.1:514", Level: LevelError}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	child := l.WithFields(map[string]string{"k": "v"})
+	l.SetLevel(LevelDebug)
+	if got := child.Level(); got != LevelDebug {
+		t.Errorf("WithFields logger level = %v, want the shared level", got)
+	}
+	if !SetLevel(child, LevelInfo) || l.Level() != LevelInfo {
+		t.Error("SetLevel did not set the shared level")
+	}
+	if SetLevel(nopLogger{}, LevelDebug) {
+		t.Error("SetLevel reported a Logger without levels")
+	}
+}
+
+func TestNilLevelVar(t *testing.T) {
+	var v *levelVar
+	v.SetLevel(LevelDebug)
+	if v.Level() != LevelInfo || v.enabled(LevelDebug) {
+		t.Errorf("nil levelVar at %v, want %v", v.Level(), LevelInfo)
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Error(v ...interface{}) error                   { return nil }
+func (nopLogger) Warning(v ...interface{}) error                 { return nil }
+func (nopLogger) Info(v ...interface{}) error                    { return nil }
+func (nopLogger) Errorf(format string, a ...interface{}) error   { return nil }
+func (nopLogger) Warningf(format string, a ...interface{}) error { return nil }
+func (nopLogger) Infof(format string, a ...interface{}) error    { return nil }
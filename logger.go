@@ -0,0 +1,217 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LoggerFactory returns the Logger of Service.Logger from the context the
+// service runs in. Set it in Config to replace DefaultLoggerFactory:
+//
+//	c.LoggerFactory = func(ctx service.LoggerContext) (service.Logger, error) {
+//		if ctx.Container || ctx.Platform == "linux-systemd" {
+//			// The journal or the container runtime collects stdout.
+//			return service.NewConsoleLogger(service.ConsoleConfig{
+//				Writer: os.Stdout, Format: service.ConsoleJSON}), nil
+//		}
+//		return service.DefaultLoggerFactory(ctx)
+//	}
+type LoggerFactory func(ctx LoggerContext) (Logger, error)
+
+// LoggerContext describes where the service runs, for a LoggerFactory.
+type LoggerContext struct {
+	Config *Config
+	// Platform is the Platform of the service, such as "linux-systemd" or
+	// "windows-service".
+	Platform string
+	// Interactive is true when the program does not run under the service
+	// manager, or runs in an application container.
+	Interactive bool
+	// Container is true in an application container, see
+	// Container.InContainer. Only detected on Linux.
+	Container bool
+	// Terminal is true when stderr is a terminal.
+	Terminal bool
+	// Errs is the channel passed to Service.Logger.
+	Errs chan<- error
+
+	service Service
+}
+
+// SystemLogger opens the system logger of the service, as
+// Service.SystemLogger with Errs.
+func (ctx LoggerContext) SystemLogger() (Logger, error) {
+	return ctx.service.SystemLogger(ctx.Errs)
+}
+
+// ConsoleLogger returns ConsoleLogger, or the logger of the ConsoleFormat
+// option of Config.
+func (ctx LoggerContext) ConsoleLogger() (Logger, error) {
+	return newConsoleLogger(ctx.Config)
+}
+
+// DefaultLoggerFactory returns the console logger when interactive and the
+// system logger otherwise.
+func DefaultLoggerFactory(ctx LoggerContext) (Logger, error) {
+	if ctx.Interactive {
+		return ctx.ConsoleLogger()
+	}
+	return ctx.SystemLogger()
+}
+
+// selectLogger returns the Logger of s, configured by c, with the
+// LoggerFactory of c or DefaultLoggerFactory.
+func selectLogger(s Service, c *Config, interactive bool, errs chan<- error) (Logger, error) {
+	factory := c.LoggerFactory
+	if factory == nil {
+		factory = DefaultLoggerFactory
+	}
+	return factory(LoggerContext{
+		Config:      c,
+		Platform:    s.Platform(),
+		Interactive: interactive,
+		Container:   inContainer(),
+		Terminal:    isTerminal(os.Stderr),
+		Errs:        errs,
+		service:     s,
+	})
+}
+
+// flusher is a Logger writing in the background.
+type flusher interface {
+	Flush(timeout time.Duration) error
+}
+
+// flushers holds the open loggers writing in the background, flushed by
+// flushLoggers.
+var (
+	flushersMu sync.Mutex
+	flushers   = map[flusher]bool{}
+)
+
+func trackFlusher(f flusher) {
+	flushersMu.Lock()
+	flushers[f] = true
+	flushersMu.Unlock()
+}
+
+func untrackFlusher(f flusher) {
+	flushersMu.Lock()
+	delete(flushers, f)
+	flushersMu.Unlock()
+}
+
+// flushLoggers waits for the open background loggers to write their queued
+// messages, for at most FlushTimeout in all. Run calls it once the service
+// stopped, so the last lines are not lost when the program exits.
+func flushLoggers() {
+	flushersMu.Lock()
+	fs := make([]flusher, 0, len(flushers))
+	for f := range flushers {
+		fs = append(fs, f)
+	}
+	flushersMu.Unlock()
+	deadline := time.Now().Add(FlushTimeout)
+	for _, f := range fs {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return
+		}
+		f.Flush(left)
+	}
+}
+
+// TeeLogger is a Logger writing every message to several loggers.
+type TeeLogger struct {
+	loggers []Logger
+}
+
+// NewTeeLogger returns a Logger writing to each of loggers, such as the
+// system logger and a console logger.
+func NewTeeLogger(loggers ...Logger) *TeeLogger {
+	return &TeeLogger{loggers: append([]Logger(nil), loggers...)}
+}
+
+// each calls log with every logger and joins the errors.
+func (t *TeeLogger) each(log func(l Logger) error) error {
+	var errs []error
+	for _, l := range t.loggers {
+		if err := log(l); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *TeeLogger) writesToStd() bool {
+	for _, l := range t.loggers {
+		if writesToStd(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// Level returns the lowest level of the loggers.
+func (t *TeeLogger) Level() Level {
+	level := LevelError
+	for _, l := range t.loggers {
+		ll, ok := l.(LevelLogger)
+		if !ok {
+			return LevelInfo
+		}
+		if ll.Level() < level {
+			level = ll.Level()
+		}
+	}
+	return level
+}
+
+// SetLevel sets the level of every LevelLogger.
+func (t *TeeLogger) SetLevel(level Level) {
+	for _, l := range t.loggers {
+		SetLevel(l, level)
+	}
+}
+
+// Close closes every logger that is an io.Closer.
+func (t *TeeLogger) Close() error {
+	return t.each(func(l Logger) error {
+		if c, ok := l.(io.Closer); ok {
+			return c.Close()
+		}
+		return nil
+	})
+}
+
+func (t *TeeLogger) Error(v ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Error(v...) })
+}
+func (t *TeeLogger) Warning(v ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Warning(v...) })
+}
+func (t *TeeLogger) Info(v ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Info(v...) })
+}
+func (t *TeeLogger) Debug(v ...interface{}) error {
+	return t.each(func(l Logger) error { return Debug(l, v...) })
+}
+func (t *TeeLogger) Errorf(format string, a ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Errorf(format, a...) })
+}
+func (t *TeeLogger) Warningf(format string, a ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Warningf(format, a...) })
+}
+func (t *TeeLogger) Infof(format string, a ...interface{}) error {
+	return t.each(func(l Logger) error { return l.Infof(format, a...) })
+}
+func (t *TeeLogger) Debugf(format string, a ...interface{}) error {
+	return t.each(func(l Logger) error { return Debugf(l, format, a...) })
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// loggerService is a Service with a system logger.
+type loggerService struct {
+	Service
+	system Logger
+	errs   chan<- error
+}
+
+func (s *loggerService) Platform() string { return "test-platform" }
+func (s *loggerService) SystemLogger(errs chan<- error) (Logger, error) {
+	s.errs = errs
+	return s.system, nil
+}
+
+func TestSelectLogger(t *testing.T) {
+	system := NewConsoleLogger(ConsoleConfig{Writer: &bytes.Buffer{}})
+	s := &loggerService{system: system}
+	errs := make(chan error)
+
+	l, err := selectLogger(s, &Config{}, true, errs)
+	if err != nil || l != ConsoleLogger {
+		t.Errorf("interactive Logger = %v, %v, want ConsoleLogger", l, err)
+	}
+	l, err = selectLogger(s, &Config{}, false, errs)
+	if err != nil || l != system || s.errs != errs {
+		t.Errorf("Logger = %v, %v, want the system logger with errs", l, err)
+	}
+
+	var got LoggerContext
+	c := &Config{LoggerFactory: func(ctx LoggerContext) (Logger, error) {
+		got = ctx
+		system, err := ctx.SystemLogger()
+		if err != nil {
+			return nil, err
+		}
+		return NewTeeLogger(system, ConsoleLogger), nil
+	}}
+	l, err = selectLogger(s, c, true, errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.(*TeeLogger); !ok {
+		t.Errorf("Logger is a %T, want the factory's", l)
+	}
+	if got.Config != c || got.Platform != "test-platform" || !got.Interactive || got.Errs != errs {
+		t.Errorf("factory context = %+v", got)
+	}
+}
+
+type failLogger struct{ nopLogger }
+
+func (failLogger) Info(v ...interface{}) error { return errors.New("info failed") }
+
+func TestTeeLogger(t *testing.T) {
+	var a, b bytes.Buffer
+	la := NewConsoleLogger(ConsoleConfig{Writer: &a, TimeFormat: "-"})
+	lb := NewConsoleLogger(ConsoleConfig{Writer: &b, TimeFormat: "-", Format: ConsoleLogfmt, Level: LevelDebug})
+	tee := NewTeeLogger(la, lb)
+	if got := tee.Level(); got != LevelDebug {
+		t.Errorf("Level() = %v, want the lowest", got)
+	}
+	tee.Debug("debug")
+	tee.Warningf("%d", 2)
+	if got, want := a.String(), "W: 2\n"; got != want {
+		t.Errorf("first logger = %q, want %q", got, want)
+	}
+	if got, want := b.String(), "level=debug msg=debug\nlevel=warning msg=2\n"; got != want {
+		t.Errorf("second logger = %q, want %q", got, want)
+	}
+	tee.SetLevel(LevelError)
+	if la.Level() != LevelError || lb.Level() != LevelError {
+		t.Error("SetLevel did not reach every logger")
+	}
+
+	tee = NewTeeLogger(failLogger{}, la, failLogger{})
+	if tee.Level() != LevelInfo {
+		t.Errorf("Level() = %v with a logger without levels, want info", tee.Level())
+	}
+	la.SetLevel(LevelInfo)
+	err := tee.Info("x")
+	if err == nil || strings.Count(err.Error(), "info failed") != 2 || !strings.HasSuffix(a.String(), "I: x\n") {
+		t.Errorf("Info() = %v, output %q; want both errors and the message written", err, a.String())
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"path/filepath"
+	"text/template"
+)
+
+// logrotateDir holds the logrotate configuration written with the LogRotate
+// option.
+var logrotateDir = "/etc/logrotate.d"
+
+func logrotatePath(c *Config) string {
+	return filepath.Join(logrotateDir, c.Name)
+}
+
+// outputFiles returns the files in LogDirectory named after the service with
+// the extensions exts.
+func outputFiles(c *Config, exts ...string) []string {
+	dir := c.Option.string(optionLogDirectory, defaultLogDirectory)
+	files := make([]string, len(exts))
+	for i, ext := range exts {
+		files[i] = filepath.Join(dir, c.Name+ext)
+	}
+	return files
+}
+
+// renderLogrotate returns the logrotate configuration of files. The files are
+// held open by the init script or the service manager so they are copied and
+// truncated; reload, if not empty, is run once after they are rotated.
+func renderLogrotate(c *Config, files []string, reload string) ([]byte, error) {
+	rotate := c.Option.int(optionLogMaxBackups, optionLogMaxBackupsDefault)
+	if rotate == 0 {
+		// Keep every rotated file.
+		rotate = -1
+	}
+	var to = &struct {
+		Name     string
+		Files    []string
+		MaxSize  int
+		Rotate   int
+		Compress bool
+		Reload   string
+	}{
+		c.Name,
+		files,
+		c.Option.int(optionLogMaxSize, optionLogMaxSizeDefault),
+		rotate,
+		c.Option.bool(optionLogCompress, optionLogCompressDefault),
+		reload,
+	}
+	var buf bytes.Buffer
+	t := template.Must(template.New("").Parse(logrotateConfig))
+	if err := t.Execute(&buf, to); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeLogrotate writes the logrotate configuration of files as a step when
+// the LogRotate option is set.
+func (tx *installTx) writeLogrotate(c *Config, files []string, reload string) error {
+	if !c.Option.bool(optionLogRotate, optionLogRotateDefault) || len(files) == 0 {
+		return nil
+	}
+	b, err := renderLogrotate(c, files, reload)
+	if err != nil {
+		return tx.fail("write logrotate configuration", err)
+	}
+	return tx.writeFile(logrotatePath(c), b, 0644)
+}
+
+func (r *UninstallReport) removeLogrotate(c *Config) error {
+	return r.remove(logrotatePath(c))
+}
+
+const logrotateConfig = `# Log rotation of the {{.Name}} service.
+{{range .Files}}{{printf "%q" .}} {{end}}{
+	missingok
+	notifempty
+	copytruncate
+{{- if .MaxSize}}
+	size {{.MaxSize}}
+{{- end}}
+	rotate {{.Rotate}}
+{{- if .Compress}}
+	compress
+	delaycompress
+{{- end}}
+{{- if .Reload}}
+	sharedscripts
+	postrotate
+		{{.Reload}}
+	endscript
+{{- end}}
+}
+`
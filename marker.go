@@ -0,0 +1,335 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"text/template"
+)
+
+// ErrListNotSupported is returned by List when the chosen system cannot
+// enumerate its services.
+var ErrListNotSupported = errors.New("listing services is not supported on this system")
+
+const (
+	modulePath = "github.com/kardianos/service"
+	markerTag  = "kardianos-service:"
+)
+
+// Options that must not be written into generated files.
+var markerSecretOptions = []string{"Password"}
+
+// Installed describes a service installed by this package, as found by List.
+type Installed struct {
+	// Service controls the installed service. It is created with an
+	// Interface that does nothing, so it must not be Run.
+	Service Service
+	// Config the service was installed with.
+	Config *Config
+	// Path of the file generated by Install. Empty on Windows.
+	Path string
+	// Version of this package that installed the service.
+	Version string
+	// Hash of Config when it was installed, see ConfigHash.
+	Hash string
+}
+
+// Lister is implemented by a System that can find the services installed
+// with this package.
+type Lister interface {
+	List() ([]Installed, error)
+}
+
+// List returns the services installed with this package on the chosen system.
+func List() ([]Installed, error) {
+	l, ok := system.(Lister)
+	if !ok {
+		return nil, ErrListNotSupported
+	}
+	return l.List()
+}
+
+// ConfigHash returns the hash recorded in generated files for c. Compare it
+// with Installed.Hash to find services installed with a different Config.
+func ConfigHash(c *Config) (string, error) {
+	b, err := markerConfig(c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// MarshalJSON encodes kv, leaving out values such as functions that cannot be
+// represented in JSON.
+func (kv KeyValue) MarshalJSON() ([]byte, error) {
+	if kv == nil {
+		return []byte("null"), nil
+	}
+	m := make(map[string]interface{}, len(kv))
+	for k, v := range kv {
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+			continue
+		}
+		m[k] = v
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes kv. Whole numbers are decoded as int and other
+// numbers as float64 so they are read back by the option accessors.
+func (kv *KeyValue) UnmarshalJSON(b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var m map[string]interface{}
+	if err := d.Decode(&m); err != nil {
+		return err
+	}
+	if m == nil {
+		*kv = nil
+		return nil
+	}
+	for k, v := range m {
+		m[k] = jsonNumbers(v)
+	}
+	*kv = KeyValue(m)
+	return nil
+}
+
+// jsonNumbers replaces json.Number values in v by int or float64.
+func jsonNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil && int64(int(i)) == i {
+			return int(i)
+		}
+		f, _ := x.Float64()
+		return f
+	case []interface{}:
+		for i := range x {
+			x[i] = jsonNumbers(x[i])
+		}
+	case map[string]interface{}:
+		for k := range x {
+			x[k] = jsonNumbers(x[k])
+		}
+	}
+	return v
+}
+
+// libraryVersion returns the version of this package linked into the program.
+func libraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(unknown)"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "(unknown)"
+}
+
+// markerConfig returns the JSON form of c recorded in generated files.
+func markerConfig(c *Config) ([]byte, error) {
+	cc := *withOptions(c)
+	if len(cc.Option) > 0 {
+		opts := cc.Option
+		cc.Option = make(KeyValue, len(opts))
+		for k, v := range opts {
+			cc.Option[k] = v
+		}
+		for _, k := range markerSecretOptions {
+			delete(cc.Option, k)
+		}
+	}
+	if len(c.Credentials) > 0 {
+		cc.Credentials = make([]CredentialConfig, len(c.Credentials))
+		for i, cred := range c.Credentials {
+			cred.Value = ""
+			cc.Credentials[i] = cred
+		}
+	}
+	return json.Marshal(&cc)
+}
+
+// marker is the metadata stamped into generated files.
+type marker struct {
+	Version string
+	Hash    string
+	Config  *Config
+}
+
+// markerLine returns the marker of c without comment delimiters.
+func markerLine(c *Config) (string, error) {
+	b, err := markerConfig(c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	version := strings.Replace(libraryVersion(), " ", "", -1)
+	return markerTag + " version=" + version +
+		" hash=" + hex.EncodeToString(sum[:]) +
+		" config=" + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// parseMarkerLine parses the text returned by markerLine, ignoring any comment
+// delimiters around it. It returns nil if line has no marker.
+func parseMarkerLine(line string) (*marker, error) {
+	i := strings.Index(line, markerTag)
+	if i < 0 {
+		return nil, nil
+	}
+	m := &marker{}
+	for _, field := range strings.Fields(line[i+len(markerTag):]) {
+		k, v, _ := strings.Cut(field, "=")
+		switch k {
+		case "version":
+			m.Version = v
+		case "hash":
+			m.Hash = v
+		case "config":
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, err
+			}
+			m.Config = &Config{}
+			if err := json.Unmarshal(b, m.Config); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if m.Config == nil {
+		return nil, errors.New("service marker without config")
+	}
+	return m, nil
+}
+
+// markerStyle is the comment syntax of a generated file.
+type markerStyle int
+
+const (
+	markerHash markerStyle = iota // "# ..." for shell scripts and unit files.
+	markerXML                     // "<!-- ... -->" for plists and manifests.
+)
+
+// stampMarker inserts the marker of c into content as a comment. It goes
+// after a "#!" or "<?xml" first line, which must stay first.
+func stampMarker(content []byte, style markerStyle, c *Config) ([]byte, error) {
+	line, err := markerLine(c)
+	if err != nil {
+		return nil, err
+	}
+	switch style {
+	case markerXML:
+		line = "<!-- " + line + " -->\n"
+	default:
+		line = "# " + line + "\n"
+	}
+	at := 0
+	if bytes.HasPrefix(content, []byte("#!")) || bytes.HasPrefix(content, []byte("<?xml")) {
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			at = i + 1
+		} else {
+			content = append(content, '\n')
+			at = len(content)
+		}
+	}
+	out := make([]byte, 0, len(content)+len(line))
+	out = append(out, content[:at]...)
+	out = append(out, line...)
+	return append(out, content[at:]...), nil
+}
+
+// renderStamped renders t with data, stamped with the marker of c.
+func renderStamped(t *template.Template, data interface{}, style markerStyle, c *Config) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return stampMarker(buf.Bytes(), style, c)
+}
+
+// readMarker returns the marker in the first lines of the file at path, or
+// nil if there is none.
+func readMarker(path string) (*marker, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for i := 0; i < 5; i++ {
+		line, err := r.ReadString('\n')
+		if m, perr := parseMarkerLine(line); m != nil || perr != nil {
+			return m, perr
+		}
+		if err != nil {
+			break
+		}
+	}
+	return nil, nil
+}
+
+type nopInterface struct{}
+
+func (nopInterface) Start(Service) error { return nil }
+func (nopInterface) Stop(Service) error  { return nil }
+
+// listInstalled returns the services of sys whose generated files are in dirs.
+func listInstalled(sys System, dirs []string) ([]Installed, error) {
+	var list []Installed
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.Mode().IsRegular() {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			m, err := readMarker(path)
+			if err != nil || m == nil {
+				continue
+			}
+			s, err := sys.New(nopInterface{}, m.Config)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, Installed{
+				Service: s,
+				Config:  m.Config,
+				Path:    path,
+				Version: m.Version,
+				Hash:    m.Hash,
+			})
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyValueJSON(t *testing.T) {
+	kv := KeyValue{
+		"Restart":     "on-failure",
+		"UserService": true,
+		"LimitNOFILE": 4096,
+		"Ratio":       0.5,
+		"RunWait":     func() {},
+	}
+	b, err := json.Marshal(kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got KeyValue
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := KeyValue{
+		"Restart":     "on-failure",
+		"UserService": true,
+		"LimitNOFILE": 4096,
+		"Ratio":       0.5,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %#v, want %#v", got, want)
+	}
+	if got.int("LimitNOFILE", 0) != 4096 {
+		t.Errorf("int option not read back")
+	}
+}
+
+func TestStampMarker(t *testing.T) {
+	c := &Config{
+		Name:   "demo",
+		Option: KeyValue{"Password": "secret", "UserService": true},
+	}
+	tests := []struct {
+		name    string
+		content string
+		style   markerStyle
+		prefix  string
+	}{
+		{"shebang", "#!/bin/sh\necho\n", markerHash, "#!/bin/sh\n# " + markerTag},
+		{"unit", "[Unit]\n", markerHash, "# " + markerTag},
+		{"xml", "<?xml version=\"1.0\"?>\n<plist/>\n", markerXML, "<?xml version=\"1.0\"?>\n<!-- " + markerTag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := stampMarker([]byte(tt.content), tt.style, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(b)
+			if !strings.HasPrefix(out, tt.prefix) {
+				t.Errorf("stampMarker() = %q, want prefix %q", out, tt.prefix)
+			}
+			if strings.Contains(out, "secret") {
+				t.Errorf("stampMarker() leaked the password")
+			}
+			dir, err := ioutil.TempDir("", "marker")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "demo")
+			if err := ioutil.WriteFile(path, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			m, err := readMarker(path)
+			if err != nil || m == nil {
+				t.Fatalf("readMarker() = %v, %v", m, err)
+			}
+			hash, err := ConfigHash(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Hash != hash {
+				t.Errorf("marker hash = %s, want %s", m.Hash, hash)
+			}
+			if m.Config.Name != "demo" || !m.Config.Option.bool("UserService", false) {
+				t.Errorf("marker config = %+v", m.Config)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"reflect"
+	"time"
+)
+
+// Duration is a time.Duration written in config files as a string such as
+// "24h" or "1m30s".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// POSIXOptions are the typed form of the POSIX options of KeyValue.
+type POSIXOptions struct {
+	// UserService installs the service for the current user.
+	UserService bool `option:"UserService" json:",omitempty"`
+
+	// Custom templates replacing the generated files.
+	SysvScript    string `option:"SysvScript" json:",omitempty"`
+	RCSScript     string `option:"RCSScript" json:",omitempty"`
+	UpstartScript string `option:"UpstartScript" json:",omitempty"`
+	OpenRCScript  string `option:"OpenRCScript" json:",omitempty"`
+
+	// RunWait is waited on by Run instead of a stop signal.
+	RunWait func() `option:"RunWait" json:"-"`
+
+	// ReloadSignal is sent on reload, such as "USR1".
+	ReloadSignal string `option:"ReloadSignal" json:",omitempty"`
+	// PIDFile is the location of the PID file.
+	PIDFile string `option:"PIDFile" json:",omitempty"`
+	// LogOutput redirects stdout and stderr to files in LogDirectory.
+	LogOutput bool `option:"LogOutput" json:",omitempty"`
+	// LogDirectory holds the log files, /var/log by default.
+	LogDirectory string `option:"LogDirectory" json:",omitempty"`
+	// LogFile makes Logger write to LogDirectory/<name>.log with a
+	// FileLogger instead of the system log.
+	LogFile bool `option:"LogFile" json:",omitempty"`
+	// LogMaxSize is the size in bytes the LogFile is rotated at, 10 MiB if
+	// nil and never if 0.
+	LogMaxSize *int `option:"LogMaxSize" json:",omitempty"`
+	// LogMaxAge is the age the LogFile is rotated at. Never if zero.
+	LogMaxAge Duration `option:"LogMaxAge" json:",omitempty"`
+	// LogMaxBackups is the number of rotated LogFiles kept, 5 if nil and all
+	// if 0.
+	LogMaxBackups *int `option:"LogMaxBackups" json:",omitempty"`
+	// LogCompress gzips rotated LogFiles.
+	LogCompress bool `option:"LogCompress" json:",omitempty"`
+	// LogLevel is the initial level of Logger, LevelInfo if zero.
+	LogLevel Level `option:"LogLevel" json:",omitempty"`
+	// ConsoleFormat is the format of Logger when interactive: "text",
+	// "logfmt" or "json". ConsoleLogger if empty.
+	ConsoleFormat string `option:"ConsoleFormat" json:",omitempty"`
+	// RedirectOutput makes Run send stdout, stderr and the log package to
+	// Logger.
+	RedirectOutput bool `option:"RedirectOutput" json:",omitempty"`
+	// RedirectStdoutLevel is the level of the redirected stdout lines.
+	RedirectStdoutLevel Level `option:"RedirectStdoutLevel" json:",omitempty"`
+	// RedirectStderrLevel is the level of the redirected stderr lines,
+	// LevelError if nil.
+	RedirectStderrLevel *Level `option:"RedirectStderrLevel" json:",omitempty"`
+	// SyslogAddress makes Logger send to a syslog server, such as
+	// "tls://logs.example.com:6514", instead of the local system log.
+	SyslogAddress string `option:"SyslogAddress" json:",omitempty"`
+	// SyslogFacility is the facility of the SyslogAddress messages, "daemon"
+	// by default.
+	SyslogFacility string `option:"SyslogFacility" json:",omitempty"`
+	// SyslogFormat is "rfc5424", the default, or "rfc3164".
+	SyslogFormat string `option:"SyslogFormat" json:",omitempty"`
+	// LogRotate writes a logrotate configuration for the output files of the
+	// service on Install. Linux only.
+	LogRotate bool `option:"LogRotate" json:",omitempty"`
+	// Restart is when the service is restarted, "always" by default.
+	Restart string `option:"Restart" json:",omitempty"`
+	// SuccessExitStatus lists the exit statuses and signals considered
+	// successful in addition to the default ones.
+	SuccessExitStatus string `option:"SuccessExitStatus" json:",omitempty"`
+	// EnvFile is the environment file read when the service starts, see
+	// EnvFile. Linux only.
+	EnvFile string `option:"EnvFile" json:",omitempty"`
+	// Prefix is the service FMRI prefix, "application" by default. Solaris
+	// only.
+	Prefix string `option:"Prefix" json:",omitempty"`
+}
+
+// SystemdOptions are the typed form of the systemd options of KeyValue.
+type SystemdOptions struct {
+	// Script is a custom unit template.
+	Script string `option:"SystemdScript" json:",omitempty"`
+	// LimitNOFILE is the maximum number of open files. Unset if nil.
+	LimitNOFILE *int `option:"LimitNOFILE" json:",omitempty"`
+	// Linger runs "loginctl enable-linger" for user services so they start at
+	// boot and keep running after logout.
+	Linger bool `option:"Linger" json:",omitempty"`
+	// UserServiceFor installs and controls a user service for this user
+	// instead of the current one.
+	UserServiceFor string `option:"UserServiceFor" json:",omitempty"`
+	// UserServiceGlobal installs a user service for every user.
+	UserServiceGlobal bool `option:"UserServiceGlobal" json:",omitempty"`
+	// DBus controls the unit through the systemd D-Bus API.
+	DBus bool `option:"SystemdDBus" json:",omitempty"`
+}
+
+// LaunchdOptions are the typed form of the launchd options of KeyValue.
+type LaunchdOptions struct {
+	// Config is a custom property list template.
+	Config string `option:"LaunchdConfig" json:",omitempty"`
+	// KeepAlive prevents the system from stopping the service. True if nil.
+	KeepAlive *bool `option:"KeepAlive" json:",omitempty"`
+	// RunAtLoad runs the service once its job is loaded.
+	RunAtLoad bool `option:"RunAtLoad" json:",omitempty"`
+	// SessionCreate creates a full user session.
+	SessionCreate bool `option:"SessionCreate" json:",omitempty"`
+}
+
+// WindowsOptions are the typed form of the Windows options of KeyValue.
+type WindowsOptions struct {
+	// StartType is "automatic", "manual" or "disabled". Automatic if empty.
+	StartType string `option:"StartType" json:",omitempty"`
+	// Interactive lets the service interact with the desktop.
+	Interactive bool `option:"Interactive" json:",omitempty"`
+	// Password of the UserName account.
+	Password string `option:"Password" json:",omitempty"`
+	// DelayedAutoStart delays automatic start after boot.
+	DelayedAutoStart bool `option:"DelayedAutoStart" json:",omitempty"`
+	// OnFailure is "restart", "reboot" or "noaction".
+	OnFailure string `option:"OnFailure" json:",omitempty"`
+	// OnFailureDelay is the wait before OnFailure is taken, 1s if zero.
+	OnFailureDelay Duration `option:"OnFailureDelayDuration" json:",omitempty"`
+	// OnFailureResetPeriod is the number of seconds without failure after
+	// which the failure count is reset. 10 if nil.
+	OnFailureResetPeriod *int `option:"OnFailureResetPeriod" json:",omitempty"`
+	// LogLevel is the initial level of Logger, LevelInfo if zero.
+	LogLevel Level `option:"LogLevel" json:",omitempty"`
+	// ConsoleFormat is the format of Logger when interactive: "text",
+	// "logfmt" or "json". ConsoleLogger if empty.
+	ConsoleFormat string `option:"ConsoleFormat" json:",omitempty"`
+	// RedirectOutput makes Run send stdout, stderr and the log package to
+	// Logger.
+	RedirectOutput bool `option:"RedirectOutput" json:",omitempty"`
+	// RedirectStdoutLevel is the level of the redirected stdout lines.
+	RedirectStdoutLevel Level `option:"RedirectStdoutLevel" json:",omitempty"`
+	// RedirectStderrLevel is the level of the redirected stderr lines,
+	// LevelError if nil.
+	RedirectStderrLevel *Level `option:"RedirectStderrLevel" json:",omitempty"`
+}
+
+// Options returns the options of c as a KeyValue: Option with the non-zero
+// fields of the typed option structs taking precedence. Zero fields, such as
+// a false bool, do not override Option; nil pointer fields do not either.
+func (c *Config) Options() KeyValue {
+	kv := make(KeyValue, len(c.Option))
+	for k, v := range c.Option {
+		kv[k] = v
+	}
+	for _, o := range []interface{}{c.POSIX, c.Systemd, c.Launchd, c.Windows} {
+		setOptions(kv, o)
+	}
+	return kv
+}
+
+// withOptions returns c, or a copy of c with its typed options folded into
+// Option, so systems only read Option.
+func withOptions(c *Config) *Config {
+	if c.POSIX == nil && c.Systemd == nil && c.Launchd == nil && c.Windows == nil {
+		return c
+	}
+	cc := *c
+	cc.Option = c.Options()
+	cc.POSIX, cc.Systemd, cc.Launchd, cc.Windows = nil, nil, nil, nil
+	return &cc
+}
+
+// setOptions sets in kv the option of every field set in o, a pointer to one
+// of the option structs.
+func setOptions(kv KeyValue, o interface{}) {
+	v := reflect.ValueOf(o)
+	if v.IsNil() {
+		return
+	}
+	v = v.Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		if f.IsZero() {
+			continue
+		}
+		if f.Kind() == reflect.Ptr {
+			f = f.Elem()
+		}
+		name := t.Field(i).Tag.Get("option")
+		switch x := f.Interface().(type) {
+		case Duration:
+			kv[name] = x.String()
+		case Level:
+			kv[name] = x.String()
+		default:
+			kv[name] = x
+		}
+	}
+}
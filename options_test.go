@@ -0,0 +1,82 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	limit, keepAlive := 4096, false
+	wait := func() {}
+	c := &Config{
+		Name:    "demo",
+		Option:  KeyValue{optionLogDirectory: "/var/log/old", optionRestart: "on-failure", optionLogCompress: true},
+		POSIX:   &POSIXOptions{LogDirectory: "/var/log/demo", RunWait: wait},
+		Systemd: &SystemdOptions{LimitNOFILE: &limit},
+		Launchd: &LaunchdOptions{KeepAlive: &keepAlive},
+		Windows: &WindowsOptions{OnFailureDelay: Duration(3 * time.Second)},
+	}
+	kv := c.Options()
+	want := map[string]interface{}{
+		optionLogDirectory:       "/var/log/demo",
+		optionRestart:            "on-failure",
+		optionLogCompress:        true, // a false field does not override Option
+		optionLimitNOFILE:        4096,
+		optionKeepAlive:          false,
+		"OnFailureDelayDuration": "3s",
+	}
+	for k, v := range want {
+		if kv[k] != v {
+			t.Errorf("Options()[%q] = %#v, want %#v", k, kv[k], v)
+		}
+	}
+	if f, ok := kv[optionRunWait].(func()); !ok || f == nil {
+		t.Errorf("Options()[%q] = %#v, want the RunWait func", optionRunWait, kv[optionRunWait])
+	}
+	if len(kv) != len(want)+1 {
+		t.Errorf("Options() = %v, want only the set fields", kv)
+	}
+	if c.Option[optionLogDirectory] != "/var/log/old" {
+		t.Error("Options() modified Option")
+	}
+
+	wc := withOptions(c)
+	if wc.POSIX != nil || wc.Systemd != nil || wc.Option.int(optionLimitNOFILE, -1) != 4096 {
+		t.Errorf("withOptions() = %+v, want typed options folded into Option", wc)
+	}
+	if plain := (&Config{Name: "demo"}); withOptions(plain) != plain {
+		t.Error("withOptions() copied a config without typed options")
+	}
+}
+
+// The option tag of every typed field names a known option of the same type.
+func TestOptionTags(t *testing.T) {
+	for _, o := range []interface{}{POSIXOptions{}, SystemdOptions{}, LaunchdOptions{}, WindowsOptions{}} {
+		typ := reflect.TypeOf(o)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			name := f.Tag.Get("option")
+			kind, known := optionKinds[name]
+			if !known {
+				t.Errorf("%s.%s: unknown option %q", typ.Name(), f.Name, name)
+				continue
+			}
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			got := kindOf(reflect.Zero(ft).Interface())
+			if ft == reflect.TypeOf(Duration(0)) || ft == reflect.TypeOf(Level(0)) {
+				got = "string"
+			}
+			if got != kind {
+				t.Errorf("%s.%s is a %s, option %q is a %s", typ.Name(), f.Name, got, name, kind)
+			}
+		}
+	}
+}
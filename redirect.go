@@ -0,0 +1,255 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRedirectLine is the length lines written to a redirected output are
+// split at.
+const maxRedirectLine = 64 << 10
+
+// ErrRedirectLoop is returned by Redirect for a Logger writing to stdout or
+// stderr, which would log its own output forever.
+var ErrRedirectLoop = errors.New("the logger writes to the redirected output")
+
+// redirectDrainTimeout bounds the wait of restore for the lines written
+// before it. Child processes still holding the pipe do not delay it longer.
+var redirectDrainTimeout = time.Second
+
+// RedirectConfig configures Redirect.
+type RedirectConfig struct {
+	// Stdout is the level of the lines written to stdout.
+	Stdout Level
+	// Stderr is the level of the lines written to stderr and by the standard
+	// log package.
+	Stderr Level
+}
+
+// Redirect sends the lines written to stdout, stderr and the standard log
+// package to l until restore is called. On Unix and Windows the file
+// descriptors or handles are replaced, so output of C code and child
+// processes is included; elsewhere only os.Stdout and os.Stderr are.
+//
+// l must not write to stdout or stderr itself: Redirect returns
+// ErrRedirectLoop for the loggers of this package that do, such as
+// ConsoleLogger. Output written just before a crash may be lost, and output
+// of child processes still running when restore is called may not be logged.
+func Redirect(l Logger, c RedirectConfig) (restore func() error, err error) {
+	if writesToStd(l) {
+		return nil, ErrRedirectLoop
+	}
+	out, err := redirectFile(&os.Stdout, 1, l, c.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	errOut, err := redirectFile(&os.Stderr, 2, l, c.Stderr)
+	if err != nil {
+		out.restore()
+		return nil, err
+	}
+	logWriter := newLineWriter(l, c.Stderr)
+	logOut, logFlags := log.Writer(), log.Flags()
+	log.SetOutput(logWriter)
+	log.SetFlags(0)
+
+	var once sync.Once
+	return func() error {
+		var err error
+		once.Do(func() {
+			log.SetOutput(logOut)
+			log.SetFlags(logFlags)
+			logWriter.Close()
+			err = errors.Join(errOut.restore(), out.restore())
+		})
+		return err
+	}, nil
+}
+
+// redirection is a standard file replaced by a pipe.
+type redirection struct {
+	f       **os.File
+	old     *os.File
+	r, w    *os.File
+	resetFD func() error
+	done    chan struct{}
+}
+
+// redirectFile replaces *f and the descriptor fd with a pipe whose lines are
+// logged to l at level.
+func redirectFile(f **os.File, fd int, l Logger, level Level) (*redirection, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	resetFD, err := redirectFD(fd, w)
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	rd := &redirection{f: f, old: *f, r: r, w: w, resetFD: resetFD, done: make(chan struct{})}
+	*f = w
+	go func() {
+		defer close(rd.done)
+		defer r.Close()
+		forwardLines(r, l, level)
+	}()
+	return rd, nil
+}
+
+// restore puts back the file and waits for the pending lines to be logged.
+// Child processes keep the pipe open, so the wait is bounded.
+func (rd *redirection) restore() error {
+	err := rd.resetFD()
+	*rd.f = rd.old
+	if cerr := rd.w.Close(); err == nil {
+		err = cerr
+	}
+	select {
+	case <-rd.done:
+	case <-time.After(redirectDrainTimeout):
+		// Only children write there now; stop reading where the system
+		// allows it.
+		rd.r.SetReadDeadline(time.Now())
+	}
+	return err
+}
+
+// forwardLines logs each line read from r to l at level, skipping blank
+// lines.
+func forwardLines(r io.Reader, l Logger, level Level) {
+	br := bufio.NewReaderSize(r, maxRedirectLine)
+	for {
+		line, err := br.ReadSlice('\n')
+		if s := strings.TrimRight(string(line), "\r\n"); s != "" {
+			logAt(l, level, s)
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+// logAt logs msg to l at level.
+func logAt(l Logger, level Level, msg string) error {
+	switch level {
+	case LevelError:
+		return l.Error(msg)
+	case LevelWarning:
+		return l.Warning(msg)
+	case LevelDebug:
+		return Debug(l, msg)
+	}
+	return l.Info(msg)
+}
+
+// lineWriter is an io.Writer logging each line to a Logger.
+type lineWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+}
+
+func newLineWriter(l Logger, level Level) *lineWriter {
+	pr, pw := io.Pipe()
+	w := &lineWriter{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		forwardLines(pr, l, level)
+	}()
+	return w
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close logs the last line and waits for it.
+func (w *lineWriter) Close() error {
+	err := w.pw.Close()
+	<-w.done
+	return err
+}
+
+// redirectOutput redirects the output of the service s to its Logger with
+// the RedirectOutput option, unless interactive. The returned function
+// restores it. Errors are logged.
+func redirectOutput(s Service, c *Config, interactive bool) (restore func()) {
+	if interactive || !c.Option.bool(optionRedirectOutput, false) {
+		return func() {}
+	}
+	l, err := s.Logger(nil)
+	if err != nil {
+		return func() {}
+	}
+	rc := RedirectConfig{Stdout: LevelInfo, Stderr: LevelError}
+	if level, err := ParseLevel(c.Option.string(optionRedirectStdoutLevel, "")); err == nil {
+		rc.Stdout = level
+	}
+	if level, err := ParseLevel(c.Option.string(optionRedirectStderrLevel, "")); err == nil {
+		rc.Stderr = level
+	}
+	undo, err := Redirect(l, rc)
+	if err != nil {
+		l.Errorf("redirect output: %v", err)
+		closeLogger(l)
+		return func() {}
+	}
+	return func() {
+		if err := undo(); err != nil {
+			l.Errorf("restore output: %v", err)
+		}
+		closeLogger(l)
+	}
+}
+
+// stdWriter is implemented by the loggers of this package to report whether
+// they write to stdout or stderr.
+type stdWriter interface {
+	writesToStd() bool
+}
+
+// writesToStd reports whether l is known to write to stdout or stderr.
+func writesToStd(l Logger) bool {
+	w, ok := l.(stdWriter)
+	return ok && w.writesToStd()
+}
+
+// isStdStream reports whether w is the file of stdout or stderr, or the same
+// file as one of them.
+func isStdStream(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || f == nil {
+		return false
+	}
+	if f == os.Stdout || f == os.Stderr {
+		return true
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	for _, std := range []*os.File{os.Stdout, os.Stderr} {
+		if sfi, err := std.Stat(); err == nil && os.SameFile(fi, sfi) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeLogger closes l if it is an io.Closer.
+func closeLogger(l Logger) {
+	if c, ok := l.(io.Closer); ok {
+		c.Close()
+	}
+}
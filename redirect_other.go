@@ -0,0 +1,15 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix && !windows
+// +build !unix,!windows
+
+package service
+
+import "os"
+
+// redirectFD does nothing: only os.Stdout and os.Stderr are redirected.
+func redirectFD(fd int, w *os.File) (func() error, error) {
+	return func() error { return nil }, nil
+}
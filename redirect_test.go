@@ -0,0 +1,163 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	stdout, stderr, flags := os.Stdout, os.Stderr, log.Flags()
+	g := &gateLogger{level: LevelDebug}
+	restore, err := Redirect(g, RedirectConfig{Stdout: LevelDebug, Stderr: LevelWarning})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("out 1")
+	fmt.Print("out 2\r\n\n")
+	fmt.Fprintln(os.Stderr, "err 1")
+	log.Print("log 1")
+	fmt.Fprint(os.Stderr, "no newline")
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restore(); err != nil {
+		t.Errorf("second restore: %v", err)
+	}
+
+	if os.Stdout != stdout || os.Stderr != stderr || log.Flags() != flags || log.Writer() != os.Stderr {
+		t.Error("the outputs were not restored")
+	}
+	// Each output keeps its order; the three are read concurrently.
+	var out, errs []string
+	for _, line := range g.got() {
+		if strings.HasPrefix(line, "D:") {
+			out = append(out, line)
+		} else {
+			errs = append(errs, line)
+		}
+	}
+	if want := []string{"D:out 1", "D:out 2"}; !reflect.DeepEqual(out, want) {
+		t.Errorf("stdout lines = %q, want %q", out, want)
+	}
+	for _, want := range []string{"W:err 1", "W:log 1", "W:no newline"} {
+		if !contains(errs, want) {
+			t.Errorf("stderr lines %q miss %q", errs, want)
+		}
+	}
+}
+
+func TestRedirectLongLine(t *testing.T) {
+	g := &gateLogger{}
+	w := newLineWriter(g, LevelInfo)
+	fmt.Fprintln(w, strings.Repeat("x", maxRedirectLine+10))
+	w.Close()
+	got := g.got()
+	if len(got) != 2 || len(got[0]) != len("I:")+maxRedirectLine || got[1] != "I:"+strings.Repeat("x", 10) {
+		t.Errorf("got %d lines, want the line split in 2", len(got))
+	}
+}
+
+// redirectService is a Service whose Logger is a gateLogger.
+type redirectService struct {
+	Service
+	logger *gateLogger
+}
+
+func (s *redirectService) Logger(errs chan<- error) (Logger, error) { return s.logger, nil }
+
+func TestRedirectOutput(t *testing.T) {
+	s := &redirectService{logger: &gateLogger{}}
+	on := &Config{Option: KeyValue{optionRedirectOutput: true, optionRedirectStdoutLevel: "warning"}}
+
+	stdout := os.Stdout
+	redirectOutput(s, on, true)()
+	redirectOutput(s, &Config{}, false)()
+	if os.Stdout != stdout {
+		t.Fatal("redirected without the option or when interactive")
+	}
+
+	restore := redirectOutput(s, on, false)
+	fmt.Println("to the logger")
+	fmt.Fprintln(os.Stderr, "an error")
+	restore()
+	got := s.logger.got()
+	for _, want := range []string{"W:to the logger", "E:an error"} {
+		if !contains(got, want) {
+			t.Errorf("logged %q, want %q", got, want)
+		}
+	}
+	if !s.logger.closed {
+		t.Error("the logger was not closed")
+	}
+}
+
+func TestRedirectLoop(t *testing.T) {
+	file, err := NewFileLogger(FileLoggerConfig{Path: filepath.Join(tempLogDir(t), "demo.log")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stdoutLogger := NewConsoleLogger(ConsoleConfig{Writer: os.Stdout, Format: ConsoleJSON})
+	for name, l := range map[string]Logger{
+		"ConsoleLogger":  ConsoleLogger,
+		"stdout":         stdoutLogger,
+		"tee":            NewTeeLogger(file, stdoutLogger),
+		"async":          NewAsyncLogger(NewConsoleLogger(ConsoleConfig{}), AsyncConfig{}, nil),
+		"async overflow": NewAsyncLogger(file, AsyncConfig{Policy: AsyncStderr}, nil),
+	} {
+		if restore, err := Redirect(l, RedirectConfig{}); err != ErrRedirectLoop {
+			if err == nil {
+				restore()
+			}
+			t.Errorf("Redirect(%s) = %v, want %v", name, err, ErrRedirectLoop)
+		}
+	}
+	for name, l := range map[string]Logger{
+		"file":   file,
+		"buffer": NewConsoleLogger(ConsoleConfig{Writer: &bytes.Buffer{}}),
+	} {
+		restore, err := Redirect(l, RedirectConfig{})
+		if err != nil {
+			t.Errorf("Redirect(%s) = %v", name, err)
+			continue
+		}
+		restore()
+	}
+
+	// redirectOutput leaves the output alone and says why on stderr, here a
+	// file.
+	stdout, stderr := os.Stdout, os.Stderr
+	f, err := ioutil.TempFile(tempLogDir(t), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	os.Stderr = f
+	defer func() { os.Stderr = stderr }()
+	restore := redirectOutput(consoleService{}, &Config{Option: KeyValue{optionRedirectOutput: true}}, false)
+	if os.Stdout != stdout || os.Stderr != f {
+		t.Error("the output was redirected to a console logger")
+	}
+	restore()
+	if b, _ := ioutil.ReadFile(f.Name()); !strings.Contains(string(b), ErrRedirectLoop.Error()) {
+		t.Errorf("stderr = %q, want the reason", b)
+	}
+}
+
+// consoleService is a Service whose Logger writes to stderr.
+type consoleService struct{ Service }
+
+func (consoleService) Logger(errs chan<- error) (Logger, error) {
+	return NewConsoleLogger(ConsoleConfig{TimeFormat: "-"}), nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+// +build unix
+
+package service
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// redirectFD points the descriptor fd to w and returns the function pointing
+// it back.
+func redirectFD(fd int, w *os.File) (func() error, error) {
+	saved, err := unix.Dup(fd)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Dup2(int(w.Fd()), fd); err != nil {
+		unix.Close(saved)
+		return nil, err
+	}
+	return func() error {
+		err := unix.Dup2(saved, fd)
+		unix.Close(saved)
+		return err
+	}, nil
+}
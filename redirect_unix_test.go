@@ -0,0 +1,80 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+// +build unix
+
+package service
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRedirectFD(t *testing.T) {
+	g := &gateLogger{}
+	restore, err := Redirect(g, RedirectConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(1, []byte("fd 1\n"))
+	// The child writes to a duplicate of descriptor 1, not to os.Stdout.
+	fd, err := syscall.Dup(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.NewFile(uintptr(fd), "stdout")
+	cmd := exec.Command("sh", "-c", "echo child")
+	cmd.Stdout = stdout
+	runErr := cmd.Run()
+	stdout.Close()
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	if want := []string{"I:fd 1", "I:child"}; !reflect.DeepEqual(g.got(), want) {
+		t.Errorf("logged %q, want %q", g.got(), want)
+	}
+}
+
+func TestRedirectRunningChild(t *testing.T) {
+	defer func(d time.Duration) { redirectDrainTimeout = d }(redirectDrainTimeout)
+	redirectDrainTimeout = 50 * time.Millisecond
+	g := &gateLogger{}
+	restore, err := Redirect(g, RedirectConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The child inherits the pipe and outlives restore.
+	cmd := exec.Command("sh", "-c", "echo child; exec sleep 10")
+	cmd.Stdout = os.Stdout
+	if err := cmd.Start(); err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	for i := 0; len(g.got()) == 0 && i < 500; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	restored := make(chan error, 1)
+	go func() { restored <- restore() }()
+	select {
+	case err := <-restored:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("restore waits for the child")
+	}
+	if want := []string{"I:child"}; !reflect.DeepEqual(g.got(), want) {
+		t.Errorf("logged %q, want %q", g.got(), want)
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// redirectFD points the standard handle of fd, 1 or 2, to w and returns the
+// function pointing it back.
+func redirectFD(fd int, w *os.File) (func() error, error) {
+	std := uint32(windows.STD_OUTPUT_HANDLE)
+	if fd == 2 {
+		std = uint32(windows.STD_ERROR_HANDLE)
+	}
+	saved, err := windows.GetStdHandle(std)
+	if err != nil {
+		return nil, err
+	}
+	if err := windows.SetStdHandle(std, windows.Handle(w.Fd())); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return windows.SetStdHandle(std, saved)
+	}, nil
+}
@@ -11,7 +11,9 @@
 // It also can be used to detect how a program is called, from an interactive
 // terminal or from a service manager.
 //
-// Examples in the example/ folder.
+// Examples in the example/ folder. Main runs the install, uninstall, start,
+// stop, restart, status and run subcommands of a typical program; the example
+// below shows the steps it takes.
 //
 //	package main
 //
@@ -73,6 +75,12 @@ const (
 	optionRunAtLoadDefault     = false
 	optionUserService          = "UserService"
 	optionUserServiceDefault   = false
+	optionUserServiceFor       = "UserServiceFor"
+	optionUserServiceGlobal    = "UserServiceGlobal"
+	optionLinger               = "Linger"
+	optionLingerDefault        = false
+	optionSystemdDBus          = "SystemdDBus"
+	optionSystemdDBusDefault   = false
 	optionSessionCreate        = "SessionCreate"
 	optionSessionCreateDefault = false
 	optionLogOutput            = "LogOutput"
@@ -97,6 +105,31 @@ const (
 	optionOpenRCScript  = "OpenRCScript"
 
 	optionLogDirectory = "LogDirectory"
+
+	optionLogLevel      = "LogLevel"
+	optionConsoleFormat = "ConsoleFormat"
+
+	optionRedirectOutput      = "RedirectOutput"
+	optionRedirectStdoutLevel = "RedirectStdoutLevel"
+	optionRedirectStderrLevel = "RedirectStderrLevel"
+
+	optionLogFile              = "LogFile"
+	optionLogFileDefault       = false
+	optionLogMaxSize           = "LogMaxSize"
+	optionLogMaxSizeDefault    = 10 << 20
+	optionLogMaxAge            = "LogMaxAge"
+	optionLogMaxBackups        = "LogMaxBackups"
+	optionLogMaxBackupsDefault = 5
+	optionLogCompress          = "LogCompress"
+	optionLogCompressDefault   = false
+	optionLogRotate            = "LogRotate"
+	optionLogRotateDefault     = false
+
+	optionSyslogAddress  = "SyslogAddress"
+	optionSyslogFacility = "SyslogFacility"
+	optionSyslogFormat   = "SyslogFormat"
+
+	optionEnvFile = "EnvFile"
 )
 
 // Status represents service status as an byte value
@@ -138,7 +171,26 @@ type Config struct {
 	// System specific options.
 	Option KeyValue
 
+	// Typed system specific options. Non-zero fields and non-nil pointer
+	// fields take precedence over the same keys in Option. Zero fields leave
+	// Option as it is, so only pointer fields can override an Option with
+	// false or 0.
+	POSIX   *POSIXOptions
+	Systemd *SystemdOptions
+	Launchd *LaunchdOptions
+	Windows *WindowsOptions
+
+	// Environment variables of the service. Those set in the environment
+	// file, see EnvFile, take precedence.
 	EnvVars map[string]string
+
+	// Secrets passed to the service, read with the Credential function.
+	// Not supported on AIX.
+	Credentials []CredentialConfig
+
+	// LoggerFactory chooses the Logger of Service.Logger.
+	// DefaultLoggerFactory if nil.
+	LoggerFactory LoggerFactory `json:"-"`
 }
 
 var (
@@ -166,7 +218,9 @@ func New(i Interface, c *Config) (Service, error) {
 	return system.New(i, c)
 }
 
-// KeyValue provides a list of system specific options.
+// KeyValue provides a list of system specific options. The same options may
+// be set with the typed POSIXOptions, SystemdOptions, LaunchdOptions and
+// WindowsOptions.
 //
 //   - OS X
 //
@@ -193,6 +247,8 @@ func New(i Interface, c *Config) (Service, error) {
 //   - SysvScript    string ()                 - Use custom sysv script.
 //
 //   - OpenRCScript  string ()                 - Use custom OpenRC script.
+//     Custom scripts and configs may quote values with the sh, shJoin, shCommand,
+//     systemdExec, systemdEnv, systemdText and xml template functions.
 //
 //   - RunWait       func() (wait for SIGNAL)  - Do not install signal but wait for this function to return.
 //
@@ -209,11 +265,64 @@ func New(i Interface, c *Config) (Service, error) {
 //
 //   - LogDirectory string(/var/log)           - The path to the log files directory
 //
+//   - LogLevel      string (info)             - Initial level of Logger: debug, info, warning or error.
+//     The LogLevelEnv environment variable takes precedence. See Level.
+//
+//   - ConsoleFormat string ()                 - Format of Logger when interactive, such as in a
+//     container: text, logfmt or json. ConsoleLogger if empty. See NewConsoleLogger.
+//
+//   - RedirectOutput bool  (false)            - Run sends the lines written to stdout, stderr and the
+//     log package to Logger, unless interactive. See Redirect.
+//
+//   - RedirectStdoutLevel string (info)       - Level of the redirected stdout lines.
+//
+//   - RedirectStderrLevel string (error)      - Level of the redirected stderr and log package lines.
+//
+//   - LogFile       bool   (false)            - Logger writes to LogDirectory/<name>.log, see FileLogger,
+//     instead of the system log.
+//
+//   - LogMaxSize    int    (10485760)         - Size in bytes the LogFile is rotated at, 0 for never.
+//
+//   - LogMaxAge     string ()                 - Age the LogFile is rotated at, time.Duration string.
+//
+//   - LogMaxBackups int    (5)                - Rotated LogFiles kept, 0 for all.
+//
+//   - LogCompress   bool   (false)            - Gzip rotated LogFiles.
+//
+//   - SyslogAddress string ()                 - Logger sends to this syslog server, as udp://, tcp:// or
+//     tls://host:port, instead of the local system log. See SyslogLogger.
+//
+//   - SyslogFacility string (daemon)          - Facility of the SyslogAddress messages.
+//
+//   - SyslogFormat  string (rfc5424)          - Format of the SyslogAddress messages. (rfc5424 | rfc3164)
+//
+//   - LogRotate     bool   (false)            - Linux: Install writes /etc/logrotate.d/<name> for the
+//     output files of sysv, rcs, OpenRC, and of upstart and systemd with LogOutput. The files are
+//     copied and truncated as LogMaxSize, LogMaxBackups and LogCompress say; systemd also reloads
+//     the service with a ReloadSignal.
+//
+//   - Linux
+//
+//   - EnvFile       string ()                 - Environment file read when the service starts, see EnvFile.
+//     Defaults to /etc/default/<name> on Debian and to /etc/sysconfig/<name> elsewhere.
+//
 //   - Linux (systemd)
 //
 //   - LimitNOFILE   int    (-1)               - Maximum open files (ulimit -n)
 //     (https://serverfault.com/questions/628610/increasing-nproc-for-processes-launched-by-systemd-on-centos-7)
 //
+//   - Linger            bool   (false)        - With UserService, run "loginctl enable-linger" so the
+//     service starts at boot and keeps running after logout.
+//
+//   - UserServiceFor    string ()             - With UserService, install and control the service for
+//     this user instead of the current one (requires root).
+//
+//   - UserServiceGlobal bool   (false)        - With UserService, install into /etc/systemd/user and
+//     enable the service for every user.
+//
+//   - SystemdDBus       bool   (false)        - Control the unit through the systemd D-Bus API instead of
+//     running systemctl. Falls back to systemctl when the bus is not reachable.
+//
 //   - Windows
 //
 //   - DelayedAutoStart  bool (false)                - After booting, start this service after some delay.
@@ -297,7 +406,7 @@ func Platform() string {
 }
 
 // Interactive returns false if running under the OS service manager
-// and true otherwise.
+// and true otherwise. See DetectContext and ModeEnv.
 func Interactive() bool {
 	if system == nil {
 		return true
@@ -385,6 +494,14 @@ type Shutdowner interface {
 	Shutdown(s Service) error
 }
 
+// Reloader is implemented by services whose service manager can reload them
+// in place (linux-systemd). Use a type assertion on Service to access it.
+type Reloader interface {
+	// ReloadOrRestart reloads the service if its unit supports reloading and
+	// restarts it otherwise.
+	ReloadOrRestart() error
+}
+
 // TODO: Add Configure to Service interface.
 
 // Service represents a service that can be run or controlled.
@@ -414,7 +531,8 @@ type Service interface {
 	// Opens and returns a system logger. If the user program is running
 	// interactively rather then as a service, the returned logger will write to
 	// os.Stderr. If errs is non-nil errors will be sent on errs as well as
-	// returned from Logger's functions.
+	// returned from Logger's functions. Loggers sending in the background,
+	// such as SyslogLogger, are flushed when Run returns.
 	Logger(errs chan<- error) (Logger, error)
 
 	// SystemLogger opens and returns a system logger. If errs is non-nil errors
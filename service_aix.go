@@ -29,6 +29,15 @@ const (
 
 type aixSystem struct{}
 
+var aixSupport = systemSupport{
+	name:    unixServiceName,
+	options: joinOptions([]string{optionSysvScript, optionLogDirectory, optionRunWait}, logOptions, unixLogOptions),
+}
+
+func (aixSystem) support() systemSupport {
+	return aixSupport
+}
+
 func (aixSystem) String() string {
 	return version
 }
@@ -44,10 +53,14 @@ func (aixSystem) Interactive() bool {
 func (aixSystem) New(i Interface, c *Config) (Service, error) {
 	return &aixService{
 		i:      i,
-		Config: c,
+		Config: withOptions(c),
 	}, nil
 }
 
+func (as aixSystem) List() ([]Installed, error) {
+	return listInstalled(as, []string{"/etc/rc.d/init.d"})
+}
+
 // Retrieve process arguments from a PID.
 func getArgsFromPid(pid int) string {
 	cmd := exec.Command("ps", "-o", "args", "-p", strconv.Itoa(pid))
@@ -76,8 +89,14 @@ func init() {
 
 // Check if the process is running interactively.
 func isInteractive() (bool, error) {
-	parentArgs := getArgsFromPid(os.Getppid())
-	return parentArgs != "/usr/sbin/srcmstr", nil
+	return DetectContext().Interactive(), nil
+}
+
+func detectSupervisor(c *RunContext) {
+	c.Parent = getArgsFromPid(getppid())
+	if c.Parent == "/usr/sbin/srcmstr" {
+		c.Supervisor = "srcmstr"
+	}
 }
 
 type aixService struct {
@@ -96,15 +115,23 @@ func (s *aixService) Platform() string {
 	return version
 }
 
+func (s *aixService) validate() error {
+	return s.Config.Validate(aixSystem{})
+}
+
+func (s *aixService) warnings() []FieldError {
+	return s.Config.Warnings(aixSystem{})
+}
+
 func (s *aixService) template() *template.Template {
-	functions := template.FuncMap{
+	functions := withEscapeFuncs(template.FuncMap{
 		"bool": func(v bool) string {
 			if v {
 				return "true"
 			}
 			return "false"
 		},
-	}
+	})
 
 	customConfig := s.Option.string(optionSysvScript, "")
 	if customConfig != "" {
@@ -118,21 +145,16 @@ func (s *aixService) configPath() (string, error) {
 }
 
 func (s *aixService) Install() error {
-	// Install service
-	path, err := s.execPath()
-	if err != nil {
+	if err := s.validate(); err != nil {
 		return err
 	}
-	if len(s.Config.Arguments) > 0 {
-		err = run("mkssys", "-s", s.Name, "-p", path, "-a", strings.Join(s.Config.Arguments, " "), "-u", "0", "-R", "-Q", "-S", "-n", "15", "-f", "9", "-d", "-w", "30")
-	} else {
-		err = run("mkssys", "-s", s.Name, "-p", path, "-u", "0", "-R", "-Q", "-S", "-n", "15", "-f", "9", "-d", "-w", "30")
+	if len(s.Credentials) > 0 {
+		return ErrCredentialsNotSupported
 	}
+	path, err := s.execPath()
 	if err != nil {
 		return err
 	}
-
-	// Write start script
 	confPath, err := s.configPath()
 	if err != nil {
 		return err
@@ -141,12 +163,6 @@ func (s *aixService) Install() error {
 		return fmt.Errorf("Init already exists: %s", confPath)
 	}
 
-	f, err := os.Create(confPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	to := struct {
 		*Config
 		Path string
@@ -154,37 +170,57 @@ func (s *aixService) Install() error {
 		Config: s.Config,
 		Path:   path,
 	}
-
-	if err = s.template().Execute(f, &to); err != nil {
+	b, err := renderStamped(s.template(), &to, markerHash, s.Config)
+	if err != nil {
 		return err
 	}
 
-	if err = os.Chmod(confPath, 0755); err != nil {
+	// Install service
+	tx := &installTx{}
+	err = tx.do("mkssys "+s.Name, func() error {
+		if len(s.Config.Arguments) > 0 {
+			return run("mkssys", "-s", s.Name, "-p", path, "-a", shJoin(s.Config.Arguments), "-u", "0", "-R", "-Q", "-S", "-n", "15", "-f", "9", "-d", "-w", "30")
+		}
+		return run("mkssys", "-s", s.Name, "-p", path, "-u", "0", "-R", "-Q", "-S", "-n", "15", "-f", "9", "-d", "-w", "30")
+	}, func() error {
+		return run("rmssys", "-s", s.Name)
+	})
+	if err != nil {
 		return err
 	}
 
-	rcd := "/etc/rc"
-	if _, err = os.Stat("/etc/rc.d/rc2.d"); err == nil {
-		rcd = "/etc/rc.d/rc"
+	// Write start script
+	if err = tx.writeFile(confPath, b, 0755); err != nil {
+		return err
 	}
 
+	rcd := aixRCPrefix()
 	for _, i := range [...]string{"2", "3"} {
-		if err = os.Symlink(confPath, fmt.Sprintf("%s%s.d/S50%s", rcd, i, s.Name)); err != nil {
-			continue
+		if err = tx.symlink(confPath, fmt.Sprintf("%s%s.d/S50%s", rcd, i, s.Name)); err != nil {
+			return err
 		}
-		if err = os.Symlink(confPath, fmt.Sprintf("%s%s.d/K02%s", rcd, i, s.Name)); err != nil {
-			continue
+		if err = tx.symlink(confPath, fmt.Sprintf("%s%s.d/K02%s", rcd, i, s.Name)); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (s *aixService) Uninstall() error {
-	if err := s.Stop(); err != nil {
-		return err
+// aixRCPrefix returns the prefix of the run level directories.
+func aixRCPrefix() string {
+	if _, err := os.Stat("/etc/rc.d/rc2.d"); err == nil {
+		return "/etc/rc.d/rc"
 	}
+	return "/etc/rc"
+}
 
+func (s *aixService) Uninstall() error {
+	_, err := UninstallWithOptions(s, UninstallOptions{})
+	return err
+}
+
+func (s *aixService) uninstall(opts UninstallOptions, r *UninstallReport) error {
 	if err := run("rmssys", "-s", s.Name); err != nil {
 		return err
 	}
@@ -193,7 +229,19 @@ func (s *aixService) Uninstall() error {
 	if err != nil {
 		return err
 	}
-	return os.Remove(confPath)
+	if err := r.removeInstalled(confPath); err != nil {
+		return err
+	}
+	rcd := aixRCPrefix()
+	for _, i := range [...]string{"2", "3"} {
+		if err := r.remove(fmt.Sprintf("%s%s.d/S50%s", rcd, i, s.Name)); err != nil {
+			return err
+		}
+		if err := r.remove(fmt.Sprintf("%s%s.d/K02%s", rcd, i, s.Name)); err != nil {
+			return err
+		}
+	}
+	return r.purge(opts, s.Config)
 }
 
 func (s *aixService) Status() (Status, error) {
@@ -247,6 +295,9 @@ func (s *aixService) Restart() error {
 }
 
 func (s *aixService) Run() error {
+	defer flushLoggers()
+	defer redirectOutput(s, s.Config, interactive)()
+
 	if err := s.i.Start(s); err != nil {
 		return err
 	}
@@ -261,23 +312,20 @@ func (s *aixService) Run() error {
 }
 
 func (s *aixService) Logger(errs chan<- error) (Logger, error) {
-	if interactive {
-		return ConsoleLogger, nil
-	}
-	return s.SystemLogger(errs)
+	return selectLogger(s, s.Config, interactive, errs)
 }
 
 func (s *aixService) SystemLogger(errs chan<- error) (Logger, error) {
-	return newSysLogger(s.Name, errs)
+	return newLogger(s.Config, errs)
 }
 
 var svcConfig = `#!/bin/ksh
 case "$1" in
 start)
-        startsrc -s {{.Name}}
+        startsrc -s {{.Name|sh}}
         ;;
 stop)
-        stopsrc -s {{.Name}}
+        stopsrc -s {{.Name|sh}}
         ;;
 *)
         echo "Usage: $0 {start|stop}"
@@ -27,6 +27,17 @@ const (
 
 type darwinSystem struct{}
 
+var launchdSupport = systemSupport{
+	name:        unixServiceName,
+	fields:      []string{"UserName", "WorkingDirectory", "ChRoot", "EnvVars", "Credentials"},
+	options:     joinOptions([]string{optionLaunchdConfig, optionKeepAlive, optionRunAtLoad, optionSessionCreate, optionLogDirectory, optionRunWait}, logOptions, unixLogOptions),
+	userService: true,
+}
+
+func (darwinSystem) support() systemSupport {
+	return launchdSupport
+}
+
 func (darwinSystem) String() string {
 	return version
 }
@@ -40,6 +51,7 @@ func (darwinSystem) Interactive() bool {
 }
 
 func (darwinSystem) New(i Interface, c *Config) (Service, error) {
+	c = withOptions(c)
 	s := &darwinLaunchdService{
 		i:      i,
 		Config: c,
@@ -50,6 +62,14 @@ func (darwinSystem) New(i Interface, c *Config) (Service, error) {
 	return s, nil
 }
 
+func (ds darwinSystem) List() ([]Installed, error) {
+	dirs := []string{"/Library/LaunchDaemons"}
+	if homeDir, err := (&darwinLaunchdService{}).getHomeDir(); err == nil {
+		dirs = append(dirs, homeDir+"/Library/LaunchAgents")
+	}
+	return listInstalled(ds, dirs)
+}
+
 func init() {
 	ChooseSystem(darwinSystem{})
 }
@@ -65,8 +85,15 @@ func init() {
 }
 
 func isInteractive() (bool, error) {
+	return DetectContext().Interactive(), nil
+}
+
+func detectSupervisor(c *RunContext) {
 	// TODO: The PPID of Launchd is 1. The PPid of a service process should match launchd's PID.
-	return os.Getppid() != 1, nil
+	if getppid() == 1 {
+		c.Parent = "launchd"
+		c.Supervisor = "launchd"
+	}
 }
 
 type darwinLaunchdService struct {
@@ -87,6 +114,14 @@ func (s *darwinLaunchdService) Platform() string {
 	return version
 }
 
+func (s *darwinLaunchdService) validate() error {
+	return s.Config.Validate(darwinSystem{})
+}
+
+func (s *darwinLaunchdService) warnings() []FieldError {
+	return s.Config.Warnings(darwinSystem{})
+}
+
 func (s *darwinLaunchdService) getHomeDir() (string, error) {
 	u, err := user.Current()
 	if err == nil {
@@ -135,14 +170,14 @@ func (s *darwinLaunchdService) getLogPath(logDir, logType string) string {
 }
 
 func (s *darwinLaunchdService) template() *template.Template {
-	functions := template.FuncMap{
+	functions := withEscapeFuncs(template.FuncMap{
 		"bool": func(v bool) string {
 			if v {
 				return "true"
 			}
 			return "false"
 		},
-	}
+	})
 
 	customConfig := s.Option.string(optionLaunchdConfig, "")
 
@@ -153,6 +188,9 @@ func (s *darwinLaunchdService) template() *template.Template {
 }
 
 func (s *darwinLaunchdService) Install() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
 	confPath, err := s.getServiceFilePath()
 	if err != nil {
 		return err
@@ -170,12 +208,6 @@ func (s *darwinLaunchdService) Install() error {
 		}
 	}
 
-	f, err := os.Create(confPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	path, err := s.execPath()
 	if err != nil {
 		return err
@@ -190,6 +222,8 @@ func (s *darwinLaunchdService) Install() error {
 		SessionCreate        bool
 		StandardOutPath      string
 		StandardErrorPath    string
+
+		CredentialsDirectory string
 	}{
 		Config:            s.Config,
 		Path:              path,
@@ -198,19 +232,46 @@ func (s *darwinLaunchdService) Install() error {
 		SessionCreate:     s.Option.bool(optionSessionCreate, optionSessionCreateDefault),
 		StandardOutPath:   stdOutPath,
 		StandardErrorPath: stdErrPath,
+
+		CredentialsDirectory: credentialsDirectory(s.Config),
+	}
+
+	b, err := renderStamped(s.template(), to, markerXML, s.Config)
+	if err != nil {
+		return err
 	}
 
-	return s.template().Execute(f, to)
+	tx := &installTx{}
+	if err = tx.writeCredentials(s.Config); err != nil {
+		return err
+	}
+	return tx.writeFile(confPath, b, 0644)
 }
 
 func (s *darwinLaunchdService) Uninstall() error {
+	_, err := UninstallWithOptions(s, UninstallOptions{})
+	return err
+}
+
+func (s *darwinLaunchdService) uninstall(opts UninstallOptions, r *UninstallReport) error {
+	// Unload the job even when it is not running.
 	s.Stop()
 
 	confPath, err := s.getServiceFilePath()
 	if err != nil {
 		return err
 	}
-	return os.Remove(confPath)
+	if err := r.removeInstalled(confPath); err != nil {
+		return err
+	}
+	if err := r.removeCredentials(s.Config); err != nil {
+		return err
+	}
+	stdOutPath, stdErrPath, err := s.getLogPaths()
+	if err != nil {
+		return err
+	}
+	return r.purge(opts, s.Config, stdOutPath, stdErrPath)
 }
 
 func (s *darwinLaunchdService) Status() (Status, error) {
@@ -265,6 +326,9 @@ func (s *darwinLaunchdService) Restart() error {
 }
 
 func (s *darwinLaunchdService) Run() error {
+	defer flushLoggers()
+	defer redirectOutput(s, s.Config, interactive)()
+
 	err := s.i.Start(s)
 	if err != nil {
 		return err
@@ -280,14 +344,11 @@ func (s *darwinLaunchdService) Run() error {
 }
 
 func (s *darwinLaunchdService) Logger(errs chan<- error) (Logger, error) {
-	if interactive {
-		return ConsoleLogger, nil
-	}
-	return s.SystemLogger(errs)
+	return selectLogger(s, s.Config, interactive, errs)
 }
 
 func (s *darwinLaunchdService) SystemLogger(errs chan<- error) (Logger, error) {
-	return newSysLogger(s.Name, errs)
+	return newLogger(s.Config, errs)
 }
 
 var launchdConfig = `<?xml version="1.0" encoding="UTF-8"?>
@@ -296,31 +357,35 @@ var launchdConfig = `<?xml version="1.0" encoding="UTF-8"?>
 <dict>
 	<key>Disabled</key>
 	<false/>
-	{{- if .EnvVars}}
+	{{- if or .EnvVars .CredentialsDirectory}}
 	<key>EnvironmentVariables</key>
 	<dict>
 		{{- range $k, $v := .EnvVars}}
-		<key>{{html $k}}</key>
-		<string>{{html $v}}</string>
+		<key>{{xml $k}}</key>
+		<string>{{xml $v}}</string>
+		{{- end}}
+		{{- if .CredentialsDirectory}}
+		<key>CREDENTIALS_DIRECTORY</key>
+		<string>{{xml .CredentialsDirectory}}</string>
 		{{- end}}
 	</dict>
 	{{- end}}
 	<key>KeepAlive</key>
 	<{{bool .KeepAlive}}/>
 	<key>Label</key>
-	<string>{{html .Name}}</string>
+	<string>{{xml .Name}}</string>
 	<key>ProgramArguments</key>
 	<array>
-		<string>{{html .Path}}</string>
+		<string>{{xml .Path}}</string>
 		{{- if .Config.Arguments}}
 		{{- range .Config.Arguments}}
-		<string>{{html .}}</string>
+		<string>{{xml .}}</string>
 		{{- end}}
 	{{- end}}
 	</array>
 	{{- if .ChRoot}}
 	<key>RootDirectory</key>
-	<string>{{html .ChRoot}}</string>
+	<string>{{xml .ChRoot}}</string>
 	{{- end}}
 	<key>RunAtLoad</key>
 	<{{bool .RunAtLoad}}/>
@@ -328,19 +393,19 @@ var launchdConfig = `<?xml version="1.0" encoding="UTF-8"?>
 	<{{bool .SessionCreate}}/>
 	{{- if .StandardErrorPath}}
 	<key>StandardErrorPath</key>
-	<string>{{html .StandardErrorPath}}</string>
+	<string>{{xml .StandardErrorPath}}</string>
 	{{- end}}
 	{{- if .StandardOutPath}}
 	<key>StandardOutPath</key>
-	<string>{{html .StandardOutPath}}</string>
+	<string>{{xml .StandardOutPath}}</string>
 	{{- end}}
 	{{- if .UserName}}
 	<key>UserName</key>
-	<string>{{html .UserName}}</string>
+	<string>{{xml .UserName}}</string>
 	{{- end}}
 	{{- if .WorkingDirectory}}
 	<key>WorkingDirectory</key>
-	<string>{{html .WorkingDirectory}}</string>
+	<string>{{xml .WorkingDirectory}}</string>
 	{{- end}}
 </dict>
 </plist>
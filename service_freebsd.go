@@ -18,6 +18,16 @@ const configDir = "/usr/local/etc/rc.d"
 
 type freebsdSystem struct{}
 
+var freebsdSupport = systemSupport{
+	name:    rcServiceName,
+	fields:  []string{"WorkingDirectory", "Credentials"},
+	options: joinOptions([]string{optionSysvScript, optionLogDirectory, optionRunWait}, logOptions, unixLogOptions),
+}
+
+func (freebsdSystem) support() systemSupport {
+	return freebsdSupport
+}
+
 func (freebsdSystem) String() string {
 	return version
 }
@@ -30,11 +40,14 @@ func (freebsdSystem) Interactive() bool {
 func (freebsdSystem) New(i Interface, c *Config) (Service, error) {
 	s := &freebsdService{
 		i:      i,
-		Config: c,
+		Config: withOptions(c),
 	}
 
 	return s, nil
 }
+func (fs freebsdSystem) List() ([]Installed, error) {
+	return listInstalled(fs, []string{configDir})
+}
 
 func init() {
 	ChooseSystem(freebsdSystem{})
@@ -51,7 +64,17 @@ func init() {
 }
 
 func isInteractive() (bool, error) {
-	return os.Getenv("IS_DAEMON") != "1", nil
+	return DetectContext().Interactive(), nil
+}
+
+func detectSupervisor(c *RunContext) {
+	switch {
+	case getenv("IS_DAEMON") == "1":
+		// Set by the rc.d script, which starts the service with daemon(8).
+		c.Supervisor = "rc"
+	case getppid() == 1:
+		c.Supervisor = "init"
+	}
 }
 
 type freebsdService struct {
@@ -70,15 +93,23 @@ func (s *freebsdService) Platform() string {
 	return version
 }
 
+func (s *freebsdService) validate() error {
+	return s.Config.Validate(freebsdSystem{})
+}
+
+func (s *freebsdService) warnings() []FieldError {
+	return s.Config.Warnings(freebsdSystem{})
+}
+
 func (s *freebsdService) template() *template.Template {
-	functions := template.FuncMap{
+	functions := withEscapeFuncs(template.FuncMap{
 		"bool": func(v bool) string {
 			if v {
 				return "true"
 			}
 			return "false"
 		},
-	}
+	})
 
 	customConfig := s.Option.string(optionSysvScript, "")
 
@@ -99,6 +130,9 @@ func (s *freebsdService) configPath() (cp string, err error) {
 }
 
 func (s *freebsdService) Install() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
 	path, err := s.execPath()
 	if err != nil {
 		return err
@@ -114,38 +148,49 @@ func (s *freebsdService) Install() error {
 		return fmt.Errorf("Init already exists: %s", confPath)
 	}
 
-	f, err := os.Create(confPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	var to = &struct {
 		*Config
 		Path string
+
+		CredentialsDirectory string
 	}{
 		s.Config,
 		path,
+		credentialsDirectory(s.Config),
 	}
 
-	err = s.template().Execute(f, to)
+	b, err := renderStamped(s.template(), to, markerHash, s.Config)
 	if err != nil {
 		return err
 	}
 
-	if err = os.Chmod(confPath, 0755); err != nil {
+	tx := &installTx{}
+	if err = tx.writeCredentials(s.Config); err != nil {
 		return err
 	}
-
-	return nil
+	return tx.writeFile(confPath, b, 0755)
 }
 
 func (s *freebsdService) Uninstall() error {
+	_, err := UninstallWithOptions(s, UninstallOptions{})
+	return err
+}
+
+func (s *freebsdService) uninstall(opts UninstallOptions, r *UninstallReport) error {
 	cp, err := s.configPath()
 	if err != nil {
 		return err
 	}
-	return os.Remove(cp)
+	if err := r.removeInstalled(cp); err != nil {
+		return err
+	}
+	if err := r.remove("/var/run/" + s.Name + ".pid"); err != nil {
+		return err
+	}
+	if err := r.removeCredentials(s.Config); err != nil {
+		return err
+	}
+	return r.purge(opts, s.Config)
 }
 
 func (s *freebsdService) Status() (Status, error) {
@@ -180,6 +225,9 @@ func (s *freebsdService) Restart() error {
 }
 
 func (s *freebsdService) Run() error {
+	defer flushLoggers()
+	defer redirectOutput(s, s.Config, interactive)()
+
 	var err error
 
 	err = s.i.Start(s)
@@ -197,30 +245,27 @@ func (s *freebsdService) Run() error {
 }
 
 func (s *freebsdService) Logger(errs chan<- error) (Logger, error) {
-	if interactive {
-		return ConsoleLogger, nil
-	}
-	return s.SystemLogger(errs)
+	return selectLogger(s, s.Config, interactive, errs)
 }
 
 func (s *freebsdService) SystemLogger(errs chan<- error) (Logger, error) {
-	return newSysLogger(s.Name, errs)
+	return newLogger(s.Config, errs)
 }
 
 var rcScript = `#!/bin/sh
 
-# PROVIDE: {{.Name}}
+# PROVIDE: {{.Name|comment}}
 # REQUIRE: SERVERS
 # KEYWORD: shutdown
 
 . /etc/rc.subr
 
-name="{{.Name}}"
-{{.Name}}_env="IS_DAEMON=1"
+name={{.Name|sh}}
+{{.Name}}_env="IS_DAEMON=1"{{if .CredentialsDirectory}}{{printf " CREDENTIALS_DIRECTORY=%s" (sh .CredentialsDirectory)|sh}}{{end}}
 pidfile="/var/run/${name}.pid"
 command="/usr/sbin/daemon"
-daemon_args="-P ${pidfile} -r -t \"${name}: daemon\"{{if .WorkingDirectory}} -c {{.WorkingDirectory}}{{end}}"
-command_args="${daemon_args} {{.Path}}{{range .Arguments}} {{.}}{{end}}"
+daemon_args="-P ${pidfile} -r -t \"${name}: daemon\""{{if .WorkingDirectory}}{{printf " -c %s" (sh .WorkingDirectory)|sh}}{{end}}
+command_args="${daemon_args} "{{shCommand .Path .Arguments|sh}}
 
 run_rc_command "$1"
 `
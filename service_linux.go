@@ -5,23 +5,28 @@
 package service
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
+	"text/template"
 )
 
 var cgroupFile = "/proc/1/cgroup"
 var mountInfoFile = "/proc/self/mountinfo"
 var dockerEnvFile = "/.dockerenv"
+var selfCgroupFile = "/proc/self/cgroup"
+var procDir = "/proc"
 
 type linuxSystemService struct {
 	name        string
 	detect      func() bool
 	interactive func() bool
 	new         func(i Interface, platform string, c *Config) (Service, error)
+	// listDirs returns the directories the system installs services in.
+	listDirs func() []string
+	supports systemSupport
 }
 
 func (sc linuxSystemService) String() string {
@@ -34,7 +39,17 @@ func (sc linuxSystemService) Interactive() bool {
 	return sc.interactive()
 }
 func (sc linuxSystemService) New(i Interface, c *Config) (Service, error) {
-	return sc.new(i, sc.String(), c)
+	return sc.new(i, sc.String(), withOptions(c))
+}
+func (sc linuxSystemService) support() systemSupport {
+	return sc.supports
+}
+func (sc linuxSystemService) List() ([]Installed, error) {
+	return listInstalled(sc, sc.listDirs())
+}
+
+func initdListDirs() []string {
+	return []string{"/etc/init.d"}
 }
 
 func init() {
@@ -45,7 +60,9 @@ func init() {
 			is, _ := isInteractive()
 			return is
 		},
-		new: newSystemdService,
+		new:      newSystemdService,
+		supports: systemdSupport,
+		listDirs: systemdListDirs,
 	},
 		linuxSystemService{
 			name:   "linux-upstart",
@@ -54,7 +71,9 @@ func init() {
 				is, _ := isInteractive()
 				return is
 			},
-			new: newUpstartService,
+			new:      newUpstartService,
+			supports: upstartSupport,
+			listDirs: upstartListDirs,
 		},
 		linuxSystemService{
 			name:   "linux-openrc",
@@ -63,7 +82,9 @@ func init() {
 				is, _ := isInteractive()
 				return is
 			},
-			new: newOpenRCService,
+			new:      newOpenRCService,
+			supports: openrcSupport,
+			listDirs: initdListDirs,
 		},
 		linuxSystemService{
 			name:   "linux-rcs",
@@ -72,7 +93,9 @@ func init() {
 				is, _ := isInteractive()
 				return is
 			},
-			new: newRCSService,
+			new:      newRCSService,
+			supports: rcsSupport,
+			listDirs: initdListDirs,
 		},
 		linuxSystemService{
 			name:   "linux-procd",
@@ -81,7 +104,9 @@ func init() {
 				is, _ := isInteractive()
 				return is
 			},
-			new: newProcdService,
+			new:      newProcdService,
+			supports: procdSupport,
+			listDirs: initdListDirs,
 		},
 		linuxSystemService{
 			name:   "unix-systemv",
@@ -90,13 +115,15 @@ func init() {
 				is, _ := isInteractive()
 				return is
 			},
-			new: newSystemVService,
+			new:      newSystemVService,
+			supports: sysvSupport,
+			listDirs: initdListDirs,
 		},
 	)
 }
 
 func binaryName(pid int) (string, error) {
-	statPath := fmt.Sprintf("/proc/%d/stat", pid)
+	statPath := fmt.Sprintf("%s/%d/stat", procDir, pid)
 	dataBytes, err := ioutil.ReadFile(statPath)
 	if err != nil {
 		return "", err
@@ -110,44 +137,69 @@ func binaryName(pid int) (string, error) {
 }
 
 func isInteractive() (bool, error) {
-	inContainer, err := isInContainer()
-	if err != nil {
-		return false, err
-	}
+	return DetectContext().Interactive(), nil
+}
 
-	if inContainer {
-		return true, nil
+// detectSupervisor names the supervisor from the parent process, then from
+// the systemd unit the process runs in.
+func detectSupervisor(c *RunContext) {
+	ppid := getppid()
+	c.Parent, _ = binaryName(ppid)
+	if s, ok := supervisorNames[c.Parent]; ok {
+		c.Supervisor = s
+		return
+	}
+
+	unit := cgroupUnit(selfCgroupFile)
+	switch {
+	case strings.HasPrefix(unit, "run-") && strings.HasSuffix(unit, ".scope"):
+		c.Supervisor = "systemd-run"
+		return
+	case strings.HasSuffix(unit, ".service") && !strings.HasPrefix(unit, "user@") && !c.StdinTTY:
+		// Terminals such as tmux or gnome-terminal-server run as user
+		// units too, so a unit on a terminal is not trusted either.
+		c.Supervisor = "systemd"
+		return
+	}
+
+	// Programs started from a terminal inherit INVOCATION_ID from the
+	// terminal emulator's unit, so only trust it without one.
+	if c.InvocationID != "" && !c.StdinTTY {
+		c.Supervisor = "systemd"
+		return
 	}
-
-	ppid := os.Getppid()
 	if ppid == 1 {
-		return false, nil
+		c.Supervisor = "init"
 	}
-
-	binary, _ := binaryName(ppid)
-	return binary != "systemd", nil
 }
 
-// isInContainer checks if the service is being executed in docker or lxc
-// container.
-func isInContainer() (bool, error) {
-	inContainer, err := isInContainerDockerEnv(dockerEnvFile)
+// cgroupUnit returns the last element of the systemd cgroup path in the
+// cgroup file, such as "foo.service" or "session-2.scope".
+func cgroupUnit(path string) string {
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		return false, err
+		return ""
 	}
-	if inContainer {
-		return true, nil
-	}
-
-	inContainer, err = isInContainerCGroup(cgroupFile)
-	if err != nil {
-		return false, err
-	}
-	if inContainer {
-		return true, nil
+	var unit string
+	for _, line := range strings.Split(string(b), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" || parts[1] == "name=systemd" {
+			unit = parts[2][strings.LastIndex(parts[2], "/")+1:]
+			if parts[1] == "name=systemd" {
+				break
+			}
+		}
 	}
+	return unit
+}
 
-	return isInContainerMountInfo(mountInfoFile)
+// isInContainer checks if the service is being executed in an application
+// container. See ContainerInfo.
+func isInContainer() (bool, error) {
+	return ContainerInfo().InContainer(), nil
 }
 
 func isInContainerDockerEnv(filePath string) (bool, error) {
@@ -163,57 +215,23 @@ func isInContainerDockerEnv(filePath string) (bool, error) {
 }
 
 func isInContainerMountInfo(filePath string) (bool, error) {
-	const maxlines = 15 // maximum lines to scan
-	f, err := os.Open(filePath)
-	if err != nil {
-		return false, err
-	}
-	defer f.Close()
-	scan := bufio.NewScanner(f)
-
-	lines := 0
-	for scan.Scan() && !(lines > maxlines) {
-		if strings.Contains(scan.Text(), "/docker/containers") {
-			return true, nil
-		}
-		lines++
-	}
-	if err := scan.Err(); err != nil {
-		return false, err
-	}
-
-	return false, nil
+	runtime, _, err := mountInfoRuntime(filePath)
+	return runtime != "", err
 }
 
 func isInContainerCGroup(cgroupPath string) (bool, error) {
-	const maxlines = 5 // maximum lines to scan
-
-	f, err := os.Open(cgroupPath)
-	if err != nil {
-		return false, err
-	}
-	defer f.Close()
-	scan := bufio.NewScanner(f)
-
-	lines := 0
-	for scan.Scan() && !(lines > maxlines) {
-		if strings.Contains(scan.Text(), "docker") || strings.Contains(scan.Text(), "lxc") {
-			return true, nil
-		}
-		lines++
-	}
-	if err := scan.Err(); err != nil {
-		return false, err
-	}
-
-	return false, nil
+	runtime, _, err := cgroupRuntime(cgroupPath)
+	return runtime != "", err
 }
 
-var tf = map[string]interface{}{
+// tf holds the template functions of the Linux systems. cmd and cmdEscape
+// are kept for custom scripts; the built-in ones use escapeFuncs.
+var tf = withEscapeFuncs(template.FuncMap{
 	"cmd": func(s string) string {
 		return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
 	},
 	"cmdEscape": func(s string) string {
 		return strings.Replace(s, " ", `\x20`, -1)
 	},
-}
+	"credEscape": credEscape,
+})
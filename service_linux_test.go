@@ -5,10 +5,16 @@
 package service
 
 import (
+	"bytes"
 	"errors"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"text/template"
 )
 
 // createTestCgroupFiles creates mock files for tests
@@ -294,3 +300,391 @@ const (
 3548 30 0:137 / /var/lib/docker/overlay2/5f0fd269ad76199040b9b3ca1fa13ce36f9ab6799cd4b0b5406732c2c8407ff6/merged rw,relatime shared:1074 - overlay overlay rw,lowerdir=/var/lib/docker/overlay2/l/SXPONMLN4JW3QBFQWXCZ3RHVST:/var/lib/docker/overlay2/l/LA6JENXEZAPXZZF2FP4ON5WDEA:/var/lib/docker/overlay2/l/XEGVGERQJ7L72RWT3VIXEWGBL4:/var/lib/docker/overlay2/l/BPXXR3DHMVCSQWGSXG5NFNBE5W,upperdir=/var/lib/docker/overlay2/5f0fd269ad76199040b9b3ca1fa13ce36f9ab6799cd4b0b5406732c2c8407ff6/diff,workdir=/var/lib/docker/overlay2/5f0fd269ad76199040b9b3ca1fa13ce36f9ab6799cd4b0b5406732c2c8407ff6/work,nouserxattr
 3700 28 0:4 net:[4026537144] /run/docker/netns/0b489b9c590d rw shared:1094 - nsfs nsfs rw`
 )
+
+func Test_userManagerEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"empty", nil, []string{
+			"XDG_RUNTIME_DIR=/run/user/1000",
+			"DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus",
+		}},
+		{"runtime-dir-set", map[string]string{"XDG_RUNTIME_DIR": "/tmp/rt"}, []string{
+			"DBUS_SESSION_BUS_ADDRESS=unix:path=/tmp/rt/bus",
+		}},
+		{"all-set", map[string]string{
+			"XDG_RUNTIME_DIR":          "/run/user/1000",
+			"DBUS_SESSION_BUS_ADDRESS": "unix:path=/run/user/1000/bus",
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := userManagerEnv(func(k string) string { return tt.env[k] }, 1000)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userManagerEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_systemdWantedBy(t *testing.T) {
+	tests := []struct {
+		name   string
+		option KeyValue
+		want   string
+	}{
+		{"system", nil, "multi-user.target"},
+		{"user", KeyValue{optionUserService: true}, "default.target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &systemd{Config: &Config{Name: "test", Option: tt.option}}
+			if got := s.wantedBy(); got != tt.want {
+				t.Errorf("wantedBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_envFilePath(t *testing.T) {
+	defer func(f string) { debianVersionFile = f }(debianVersionFile)
+	tests := []struct {
+		name   string
+		debian bool
+		option KeyValue
+		want   string
+	}{
+		{"redhat", false, nil, "/etc/sysconfig/test"},
+		{"debian", true, nil, "/etc/default/test"},
+		{"option", true, KeyValue{optionEnvFile: "/srv/test.env"}, "/srv/test.env"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			debianVersionFile = "/nonexistent"
+			if tt.debian {
+				f, err := ioutil.TempFile("", "debian_version")
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.Close()
+				defer os.Remove(f.Name())
+				debianVersionFile = f.Name()
+			}
+			if got := envFilePath(&Config{Name: "test", Option: tt.option}); got != tt.want {
+				t.Errorf("envFilePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_systemdCredentials(t *testing.T) {
+	c := &Config{Name: "test", Credentials: []CredentialConfig{
+		{Name: "key", Path: "/etc/key"},
+		{Name: "enc", Path: "/etc/enc.cred", Encrypted: true},
+		{Name: "inline", Value: "a\\b\nc"},
+	}}
+	s := &systemd{Config: c}
+	var b bytes.Buffer
+	err := s.template().Execute(&b, &struct {
+		*Config
+		Path, EnvFile, WantedBy           string
+		ReloadSignal, PIDFile, Restart    string
+		SuccessExitStatus, LogDirectory   string
+		UserService, HasOutputFileSupport bool
+		LogOutput                         bool
+		LimitNOFILE                       int
+	}{Config: c, Path: "/usr/bin/test", EnvFile: "/etc/default/test", WantedBy: "multi-user.target", LimitNOFILE: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"LoadCredential=key:/etc/key\n",
+		"LoadCredentialEncrypted=enc:/etc/enc.cred\n",
+		`SetCredential=inline:a\\b\x0ac` + "\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("unit does not contain %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func Test_systemdExecStart(t *testing.T) {
+	c := &Config{
+		Name:      "test",
+		Arguments: []string{"-msg", `say "hi" to $USER at 100%`, ""},
+		EnvVars:   map[string]string{"GREETING": `"hello" 5%`},
+	}
+	s := &systemd{Config: c}
+	var b bytes.Buffer
+	err := s.template().Execute(&b, &struct {
+		*Config
+		Path, EnvFile, WantedBy           string
+		ReloadSignal, PIDFile, Restart    string
+		SuccessExitStatus, LogDirectory   string
+		UserService, HasOutputFileSupport bool
+		LogOutput                         bool
+		LimitNOFILE                       int
+	}{Config: c, Path: "/opt/my app/test", EnvFile: "/etc/default/test", WantedBy: "multi-user.target", LimitNOFILE: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`ExecStart="/opt/my app/test" -msg "say \"hi\" to $$USER at 100%%" ""` + "\n",
+		"ConditionFileIsExecutable=/opt/my app/test\n",
+		`Environment="GREETING=\"hello\" 5%%"` + "\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("unit does not contain %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func Test_systemdInjection(t *testing.T) {
+	c := &Config{
+		Name:             "test",
+		Description:      "Runs tests\nExecStartPre=/bin/touch /tmp/injected",
+		UserName:         "nobody\r\nUser=root",
+		WorkingDirectory: "/srv\nExecStartPre=/bin/true",
+	}
+	s := &systemd{Config: c}
+	var b bytes.Buffer
+	err := s.template().Execute(&b, &struct {
+		*Config
+		Path, EnvFile, WantedBy           string
+		ReloadSignal, PIDFile, Restart    string
+		SuccessExitStatus, LogDirectory   string
+		UserService, HasOutputFileSupport bool
+		LogOutput                         bool
+		LimitNOFILE                       int
+	}{Config: c, Path: "/usr/bin/test", EnvFile: "/etc/default/test", PIDFile: "/run/test.pid\nUser=root", WantedBy: "multi-user.target", LimitNOFILE: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.HasPrefix(line, "ExecStartPre=") || line == "User=root" {
+			t.Errorf("injected line %q:\n%s", line, b.String())
+		}
+	}
+	if !strings.Contains(b.String(), "User=nobody  User=root\n") {
+		t.Errorf("unit does not hold the escaped user:\n%s", b.String())
+	}
+	if err := validateConfig(c, "linux-systemd", &systemdSupport); err == nil {
+		t.Error("validateConfig() accepted control characters")
+	}
+}
+
+func Test_scriptQuoting(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	c := &Config{
+		Name:             "test",
+		DisplayName:      "The \"test\" service\nrm -rf /",
+		Description:      "Runs tests\r\ntouch /tmp/injected",
+		Arguments:        []string{"a b", "it's", "$HOME `id`"},
+		WorkingDirectory: "/srv/my app",
+		EnvVars:          map[string]string{"GREETING": "it's $HOME"},
+		Credentials:      []CredentialConfig{{Name: "key", Value: "x"}},
+	}
+	to := &struct {
+		*Config
+		Path                 string
+		LogDirectory         string
+		EnvFile              string
+		CredentialsDirectory string
+	}{c, "/opt/my app/test", "/var/log/my $(id) logs", "/etc/default/my test", "/var/lib/my creds"}
+	for name, s := range map[string]interface{ template() *template.Template }{
+		"sysv":   &sysv{Config: c},
+		"rcs":    &rcs{Config: c},
+		"openrc": &openrc{Config: c},
+		"procd":  &procd{sysv: &sysv{Config: c}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := s.template().Execute(&b, to); err != nil {
+				t.Fatal(err)
+			}
+			out, err := exec.Command(sh, "-n", "-c", b.String()).CombinedOutput()
+			if err != nil {
+				t.Errorf("sh -n: %v: %s\n%s", err, out, b.String())
+			}
+			// Outside single quotes no value may start a line or expand.
+			for _, line := range strings.Split(shUnquoted(b.String()), "\n") {
+				if strings.HasPrefix(line, "touch ") || strings.HasPrefix(line, "rm ") || strings.Contains(line, "$(id)") {
+					t.Errorf("unescaped value in line %q", line)
+				}
+			}
+		})
+	}
+}
+
+// Test_envFileOverridesEnvVars checks that the scripts read the environment
+// file after exporting EnvVars, as systemd lets EnvironmentFile= override
+// Environment=.
+func Test_envFileOverridesEnvVars(t *testing.T) {
+	c := &Config{Name: "test", EnvVars: map[string]string{"GREETING": "hello"}}
+	to := &struct {
+		*Config
+		Path                 string
+		LogDirectory         string
+		EnvFile              string
+		CredentialsDirectory string
+	}{c, "/usr/bin/test", "/var/log", "/etc/default/test", ""}
+	for name, s := range map[string]interface{ template() *template.Template }{
+		"sysv":   &sysv{Config: c},
+		"openrc": &openrc{Config: c},
+	} {
+		var b bytes.Buffer
+		if err := s.template().Execute(&b, to); err != nil {
+			t.Fatal(err)
+		}
+		script := b.String()
+		vars, file := strings.Index(script, "export GREETING="), strings.Index(script, ". /etc/default/test")
+		if vars < 0 || file < 0 || vars > file {
+			t.Errorf("%s: EnvVars at %d, environment file read at %d:\n%s", name, vars, file, script)
+		}
+	}
+}
+
+// shUnquoted drops single quoted strings and backslash escaped characters
+// from script, leaving what the shell expands.
+func shUnquoted(script string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case quoted:
+			quoted = c != '\''
+		case c == '\'':
+			quoted = true
+		case c == '\\':
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func Test_checkSystemd(t *testing.T) {
+	c := &Config{Name: "demo", Option: KeyValue{
+		optionRestart:           "sometimes",
+		optionReloadSignal:      "RELOAD",
+		optionLimitNOFILE:       -2,
+		optionSuccessExitStatus: "0 1 SIGTERM 300 BOGUS",
+	}}
+	err := validateConfig(c, "linux-systemd", &systemdSupport)
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("validateConfig() = %v, want a *ValidationError", err)
+	}
+	var fields []string
+	for _, p := range ve.Problems {
+		fields = append(fields, p.Field)
+	}
+	want := []string{`Option["Restart"]`, `Option["ReloadSignal"]`, `Option["LimitNOFILE"]`, `Option["SuccessExitStatus"]`, `Option["SuccessExitStatus"]`}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("problems = %v, want fields %q", ve.Problems, want)
+	}
+	if err := validateConfig(&Config{Name: "demo", Option: KeyValue{optionUserService: true}}, "linux-systemd", &systemdSupport); err != nil {
+		t.Errorf("user service: %v", err)
+	}
+	if err := validateConfig(&Config{Name: "demo", Option: KeyValue{optionUserService: true}}, "unix-systemv", &sysvSupport); err == nil {
+		t.Error("user service on sysv passed validation")
+	}
+}
+
+func Test_renderLogrotate(t *testing.T) {
+	tests := []struct {
+		name   string
+		option KeyValue
+		files  []string
+		reload string
+		want   string
+	}{
+		{
+			name:  "defaults",
+			files: []string{"/var/log/demo.log", "/var/log/demo.err"},
+			want: `# Log rotation of the demo service.
+"/var/log/demo.log" "/var/log/demo.err" {
+	missingok
+	notifempty
+	copytruncate
+	size 10485760
+	rotate 5
+}
+`,
+		},
+		{
+			name:   "reload",
+			option: KeyValue{optionLogMaxSize: 0, optionLogMaxBackups: 0, optionLogCompress: true},
+			files:  []string{"/var/log/my logs/demo.out"},
+			reload: "systemctl reload demo.service >/dev/null 2>&1 || true",
+			want: `# Log rotation of the demo service.
+"/var/log/my logs/demo.out" {
+	missingok
+	notifempty
+	copytruncate
+	rotate -1
+	compress
+	delaycompress
+	sharedscripts
+	postrotate
+		systemctl reload demo.service >/dev/null 2>&1 || true
+	endscript
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := renderLogrotate(&Config{Name: "demo", Option: tt.option}, tt.files, tt.reload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("renderLogrotate() =\n%s\nwant\n%s", b, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeLogrotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logrotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { logrotateDir = d }(logrotateDir)
+	logrotateDir = dir
+	path := filepath.Join(dir, "demo")
+	files := outputFiles(&Config{Name: "demo"}, ".log", ".err")
+
+	tx := &installTx{}
+	if err := tx.writeLogrotate(&Config{Name: "demo"}, files, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("configuration written without the LogRotate option: %v", err)
+	}
+
+	c := &Config{Name: "demo", Option: KeyValue{optionLogRotate: true}}
+	if err := tx.writeLogrotate(c, files, ""); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(b), `"/var/log/demo.log" "/var/log/demo.err" {`) {
+		t.Fatalf("configuration = %q, %v", b, err)
+	}
+
+	r := &UninstallReport{}
+	if err := r.removeLogrotate(c); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Removed, []string{path}) {
+		t.Errorf("Removed = %q, want %q", r.Removed, path)
+	}
+}
@@ -44,6 +44,12 @@ type openrc struct {
 	*Config
 }
 
+var openrcSupport = systemSupport{
+	name:    unixServiceName,
+	fields:  []string{"Dependencies", "EnvVars", "Credentials"},
+	options: joinOptions([]string{optionOpenRCScript, optionLogDirectory, optionEnvFile, optionLogRotate, optionRunWait}, logOptions, unixLogOptions),
+}
+
 func (s *openrc) String() string {
 	if len(s.DisplayName) > 0 {
 		return s.DisplayName
@@ -55,6 +61,14 @@ func (s *openrc) Platform() string {
 	return s.platform
 }
 
+func (s *openrc) validate() error {
+	return validateConfig(s.Config, s.platform, &openrcSupport)
+}
+
+func (s *openrc) warnings() []FieldError {
+	return configWarnings(s.Config, s.platform, &openrcSupport)
+}
+
 func (s *openrc) template() *template.Template {
 	customScript := s.Option.string(optionOpenRCScript, "")
 
@@ -84,7 +98,14 @@ func (s *openrc) configPath() (cp string, err error) {
 	return
 }
 
+func (s *openrc) envFile() (string, error) {
+	return envFilePath(s.Config), nil
+}
+
 func (s *openrc) Install() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
 	confPath, err := s.configPath()
 	if err != nil {
 		return err
@@ -94,17 +115,6 @@ func (s *openrc) Install() error {
 		return fmt.Errorf("Init already exists: %s", confPath)
 	}
 
-	f, err := os.Create(confPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	err = os.Chmod(confPath, 0755)
-	if err != nil {
-		return err
-	}
-
 	path, err := s.execPath()
 	if err != nil {
 		return err
@@ -114,43 +124,78 @@ func (s *openrc) Install() error {
 		*Config
 		Path         string
 		LogDirectory string
+		EnvFile      string
+
+		CredentialsDirectory string
 	}{
 		s.Config,
 		path,
 		s.Option.string(optionLogDirectory, defaultLogDirectory),
+		envFilePath(s.Config),
+		credentialsDirectory(s.Config),
 	}
 
-	err = s.template().Execute(f, to)
+	b, err := renderStamped(s.template(), to, markerHash, s.Config)
 	if err != nil {
 		return err
 	}
+
+	tx := &installTx{}
+	if err = tx.writeCredentials(s.Config); err != nil {
+		return err
+	}
+	if err = tx.writeFile(confPath, b, 0755); err != nil {
+		return err
+	}
+	if err = tx.writeLogrotate(s.Config, outputFiles(s.Config, ".log", ".err"), ""); err != nil {
+		return err
+	}
 	// run rc-update
-	return s.runAction("add")
+	return tx.do("rc-update add", func() error { return s.runAction("add") }, nil)
 }
 
 func (s *openrc) Uninstall() error {
+	_, err := UninstallWithOptions(s, UninstallOptions{})
+	return err
+}
+
+func (s *openrc) uninstall(opts UninstallOptions, r *UninstallReport) error {
 	confPath, err := s.configPath()
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(confPath); err != nil {
+	if err := r.removeInstalled(confPath); err != nil {
 		return err
 	}
-	return s.runAction("delete")
+	if err := s.runAction("delete"); err != nil {
+		return err
+	}
+	if err := r.removeCredentials(s.Config); err != nil {
+		return err
+	}
+	if err := r.removeLogrotate(s.Config); err != nil {
+		return err
+	}
+	logDir := s.Option.string(optionLogDirectory, defaultLogDirectory)
+	return r.purge(opts, s.Config,
+		logDir+"/"+s.Name+".log",
+		logDir+"/"+s.Name+".err",
+		envFilePath(s.Config),
+	)
 }
 
 func (s *openrc) Logger(errs chan<- error) (Logger, error) {
-	if system.Interactive() {
-		return ConsoleLogger, nil
-	}
-	return s.SystemLogger(errs)
+	return selectLogger(s, s.Config, system.Interactive(), errs)
 }
 
 func (s *openrc) SystemLogger(errs chan<- error) (Logger, error) {
-	return newSysLogger(s.Name, errs)
+	return newLogger(s.Config, errs)
 }
 
 func (s *openrc) Run() (err error) {
+	defer flushLoggers()
+	defer redirectOutput(s, s.Config, system.Interactive())()
+
 	err = s.i.Start(s)
 	if err != nil {
 		return err
@@ -221,19 +266,25 @@ func (s *openrc) run(action string, args ...string) error {
 
 const openRCScript = `#!/sbin/openrc-run
 supervisor=supervise-daemon
-name="{{.DisplayName}}"
-description="{{.Description}}"
-command={{.Path|cmdEscape}}
+name={{.DisplayName|sh}}
+description={{.Description|sh}}
+command={{.Path|sh}}
 {{- if .Arguments }}
-command_args="{{range .Arguments}}{{.}} {{end}}"
+command_args={{.Arguments|shJoin|sh}}
 {{- end }}
-name=$(basename $(readlink -f $command))
-supervise_daemon_args="--stdout {{.LogDirectory}}/${name}.log --stderr {{.LogDirectory}}/${name}.err"
+name=$(basename "$(readlink -f "$command")")
+supervise_daemon_args="--stdout "{{.LogDirectory|sh}}"/${name}.log --stderr "{{.LogDirectory|sh}}"/${name}.err"
 
 {{range $k, $v := .EnvVars -}}
-export {{$k}}={{$v}}
+export {{$k}}={{$v|sh}}
 {{end -}}
-
+if [ -e {{.EnvFile|sh}} ]; then
+	set -a
+	. {{.EnvFile|sh}}
+	set +a
+fi
+{{if .CredentialsDirectory}}export CREDENTIALS_DIRECTORY={{.CredentialsDirectory|sh}}
+{{end}}
 {{- if .Dependencies }}
 depend() {
 {{- range $i, $dep := .Dependencies}} 
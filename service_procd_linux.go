@@ -25,6 +25,12 @@ type procd struct {
 	scriptPath string
 }
 
+var procdSupport = systemSupport{
+	name:    unixServiceName,
+	fields:  []string{"Credentials"},
+	options: joinOptions([]string{optionSysvScript, optionLogDirectory, optionEnvFile, optionRunWait}, logOptions, unixLogOptions),
+}
+
 func newProcdService(i Interface, platform string, c *Config) (Service, error) {
 	sv := &sysv{
 		i:        i,
@@ -39,6 +45,14 @@ func newProcdService(i Interface, platform string, c *Config) (Service, error) {
 	return p, nil
 }
 
+func (p *procd) validate() error {
+	return validateConfig(p.Config, p.platform, &procdSupport)
+}
+
+func (p *procd) warnings() []FieldError {
+	return configWarnings(p.Config, p.platform, &procdSupport)
+}
+
 func (p *procd) template() *template.Template {
 	customScript := p.Option.string(optionSysvScript, "")
 
@@ -49,6 +63,9 @@ func (p *procd) template() *template.Template {
 }
 
 func (p *procd) Install() error {
+	if err := p.validate(); err != nil {
+		return err
+	}
 	confPath, err := p.configPath()
 	if err != nil {
 		return err
@@ -58,12 +75,6 @@ func (p *procd) Install() error {
 		return fmt.Errorf("init already exists: %q", confPath)
 	}
 
-	f, err := os.Create(confPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	path, err := p.execPath()
 	if err != nil {
 		return err
@@ -73,32 +84,41 @@ func (p *procd) Install() error {
 		*Config
 		Path         string
 		LogDirectory string
+		EnvFile      string
+
+		CredentialsDirectory string
 	}{
 		p.Config,
 		path,
 		p.Option.string(optionLogDirectory, defaultLogDirectory),
+		envFilePath(p.Config),
+		credentialsDirectory(p.Config),
 	}
 
-	err = p.template().Execute(f, to)
+	b, err := renderStamped(p.template(), to, markerHash, p.Config)
 	if err != nil {
 		return err
 	}
 
-	if err = os.Chmod(confPath, 0755); err != nil {
+	tx := &installTx{}
+	if err = tx.writeCredentials(p.Config); err != nil {
 		return err
 	}
-
-	if err = os.Symlink(confPath, "/etc/rc.d/S50"+p.Name); err != nil {
+	if err = tx.writeFile(confPath, b, 0755); err != nil {
 		return err
 	}
-	if err = os.Symlink(confPath, "/etc/rc.d/K02"+p.Name); err != nil {
+	if err = tx.symlink(confPath, "/etc/rc.d/S50"+p.Name); err != nil {
 		return err
 	}
-
-	return nil
+	return tx.symlink(confPath, "/etc/rc.d/K02"+p.Name)
 }
 
 func (p *procd) Uninstall() error {
+	_, err := UninstallWithOptions(p, UninstallOptions{})
+	return err
+}
+
+func (p *procd) uninstall(opts UninstallOptions, r *UninstallReport) error {
 	if err := run(p.scriptPath, "disable"); err != nil {
 		return err
 	}
@@ -106,10 +126,18 @@ func (p *procd) Uninstall() error {
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(cp); err != nil {
+	if err := r.removeInstalled(cp); err != nil {
+		return err
+	}
+	for _, link := range [...]string{"/etc/rc.d/S50" + p.Name, "/etc/rc.d/K02" + p.Name, "/var/run/" + p.Name + ".pid"} {
+		if err := r.remove(link); err != nil {
+			return err
+		}
+	}
+	if err := r.removeCredentials(p.Config); err != nil {
 		return err
 	}
-	return nil
+	return r.purge(opts, p.Config, envFilePath(p.Config))
 }
 
 func (p *procd) Status() (Status, error) {
@@ -151,19 +179,20 @@ USE_PROCD=1
 START=21
 # Before network stops
 STOP=89
-cmd="{{.Path}}{{range .Arguments}} {{.|cmd}}{{end}}"
-name="{{.Name}}"
+name={{.Name|sh}}
 pid_file="/var/run/${name}.pid"
 
 start_service() {
     echo "Starting ${name}"
     procd_open_instance
-    procd_set_param command ${cmd}
+    # load the environment file when the instance starts
+    procd_set_param command /bin/sh -c 'if [ -e "$1" ]; then set -a; . "$1"; set +a; fi; shift; exec "$@"' sh {{.EnvFile|sh}} {{shCommand .Path .Arguments}}
 
     # respawn automatically if something died, be careful if you have an alternative process supervisor
     # if process exits sooner than respawn_threshold, it is considered crashed and after 5 retries the service is stopped
     # if process finishes later than respawn_threshold, it is restarted unconditionally, regardless of error code
     # notice that this is literal respawning of the process, no in a respawn-on-failure sense
+    {{if .CredentialsDirectory}}procd_set_param env CREDENTIALS_DIRECTORY={{.CredentialsDirectory|sh}}{{end}}
     procd_set_param respawn ${respawn_threshold:-3600} ${respawn_timeout:-5} ${respawn_retry:-5}
 
     procd_set_param stdout 1             # forward stdout of the command to logd
@@ -24,6 +24,12 @@ type rcs struct {
 	*Config
 }
 
+var rcsSupport = systemSupport{
+	name:    unixServiceName,
+	fields:  []string{"WorkingDirectory", "Credentials"},
+	options: joinOptions([]string{optionRCSScript, optionLogDirectory, optionEnvFile, optionLogRotate, optionRunWait}, logOptions, unixLogOptions),
+}
+
 func isRCS() bool {
 	if _, err := os.Stat("/etc/init.d/rcS"); err != nil {
 		return false
@@ -73,6 +79,14 @@ func (s *rcs) Platform() string {
 	return s.platform
 }
 
+func (s *rcs) validate() error {
+	return validateConfig(s.Config, s.platform, &rcsSupport)
+}
+
+func (s *rcs) warnings() []FieldError {
+	return configWarnings(s.Config, s.platform, &rcsSupport)
+}
+
 // todo
 var errNoUserServiceRCS = errors.New("User services are not supported on rcS.")
 
@@ -85,6 +99,10 @@ func (s *rcs) configPath() (cp string, err error) {
 	return
 }
 
+func (s *rcs) envFile() (string, error) {
+	return envFilePath(s.Config), nil
+}
+
 func (s *rcs) template() *template.Template {
 	customScript := s.Option.string(optionRCSScript, "")
 
@@ -95,6 +113,9 @@ func (s *rcs) template() *template.Template {
 }
 
 func (s *rcs) Install() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
 	confPath, err := s.configPath()
 	if err != nil {
 		return err
@@ -104,12 +125,6 @@ func (s *rcs) Install() error {
 		return fmt.Errorf("Init already exists: %s", confPath)
 	}
 
-	f, err := os.Create(confPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	path, err := s.execPath()
 	if err != nil {
 		return err
@@ -119,53 +134,79 @@ func (s *rcs) Install() error {
 		*Config
 		Path         string
 		LogDirectory string
+		EnvFile      string
+
+		CredentialsDirectory string
 	}{
 		s.Config,
 		path,
 		s.Option.string(optionLogDirectory, defaultLogDirectory),
+		envFilePath(s.Config),
+		credentialsDirectory(s.Config),
 	}
 
-	err = s.template().Execute(f, to)
+	b, err := renderStamped(s.template(), to, markerHash, s.Config)
 	if err != nil {
 		return err
 	}
 
-	if err = os.Chmod(confPath, 0755); err != nil {
+	tx := &installTx{}
+	if err = tx.writeCredentials(s.Config); err != nil {
 		return err
 	}
-
-	if err = os.Symlink(confPath, "/etc/rc.d/S50"+s.Name); err != nil {
+	if err = tx.writeFile(confPath, b, 0755); err != nil {
 		return err
 	}
-
-	return nil
+	if err = tx.writeLogrotate(s.Config, outputFiles(s.Config, ".log", ".err"), ""); err != nil {
+		return err
+	}
+	return tx.symlink(confPath, "/etc/rc.d/S50"+s.Name)
 }
 
 func (s *rcs) Uninstall() error {
+	_, err := UninstallWithOptions(s, UninstallOptions{})
+	return err
+}
+
+func (s *rcs) uninstall(opts UninstallOptions, r *UninstallReport) error {
 	cp, err := s.configPath()
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(cp); err != nil {
+	if err := r.removeInstalled(cp); err != nil {
+		return err
+	}
+	if err := r.remove("/etc/rc.d/S50" + s.Name); err != nil {
 		return err
 	}
-	if err := os.Remove("/etc/rc.d/S50" + s.Name); err != nil {
+	if err := r.remove("/var/run/" + s.Name + ".pid"); err != nil {
 		return err
 	}
-	return nil
+	if err := r.removeCredentials(s.Config); err != nil {
+		return err
+	}
+	if err := r.removeLogrotate(s.Config); err != nil {
+		return err
+	}
+	logDir := s.Option.string(optionLogDirectory, defaultLogDirectory)
+	return r.purge(opts, s.Config,
+		logDir+"/"+s.Name+".log",
+		logDir+"/"+s.Name+".err",
+		envFilePath(s.Config),
+	)
 }
 
 func (s *rcs) Logger(errs chan<- error) (Logger, error) {
-	if system.Interactive() {
-		return ConsoleLogger, nil
-	}
-	return s.SystemLogger(errs)
+	return selectLogger(s, s.Config, system.Interactive(), errs)
 }
 func (s *rcs) SystemLogger(errs chan<- error) (Logger, error) {
-	return newSysLogger(s.Name, errs)
+	return newLogger(s.Config, errs)
 }
 
 func (s *rcs) Run() (err error) {
+	defer flushLoggers()
+	defer redirectOutput(s, s.Config, system.Interactive())()
+
 	err = s.i.Start(s)
 	if err != nil {
 		return err
@@ -216,28 +257,33 @@ func (s *rcs) Restart() error {
 const rcsScript = `#!/bin/sh
 # For RedHat and cousins:
 # chkconfig: - 99 01
-# description: {{.Description}}
-# processname: {{.Path}}
+# description: {{.Description|comment}}
+# processname: {{.Path|comment}}
 
 ### BEGIN INIT INFO
-# Provides:          {{.Path}}
+# Provides:          {{.Path|comment}}
 # Required-Start:
 # Required-Stop:
 # Default-Start:     2 3 4 5
 # Default-Stop:      0 1 6
-# Short-Description: {{.DisplayName}}
-# Description:       {{.Description}}
+# Short-Description: {{.DisplayName|comment}}
+# Description:       {{.Description|comment}}
 ### END INIT INFO
 
-cmd="{{.Path}}{{range .Arguments}} {{.|cmd}}{{end}}"
+cmd={{shCommand .Path .Arguments|sh}}
 
-name={{.Name}}
+name={{.Name|sh}}
 pid_file="/var/run/$name.pid"
-stdout_log="{{.LogDirectory}}/$name.log"
-stderr_log="{{.LogDirectory}}/$name.err"
-
-[ -e /etc/sysconfig/$name ] && . /etc/sysconfig/$name
-
+stdout_log={{.LogDirectory|sh}}"/$name.log"
+stderr_log={{.LogDirectory|sh}}"/$name.err"
+
+if [ -e {{.EnvFile|sh}} ]; then
+    set -a
+    . {{.EnvFile|sh}}
+    set +a
+fi
+{{if .CredentialsDirectory}}export CREDENTIALS_DIRECTORY={{.CredentialsDirectory|sh}}
+{{end}}
 get_pid() {
     cat "$pid_file"
 }
@@ -252,8 +298,8 @@ case "$1" in
             echo "Already started"
         else
             echo "Starting $name"
-            {{if .WorkingDirectory}}cd '{{.WorkingDirectory}}'{{end}}
-            $cmd >> "$stdout_log" 2>> "$stderr_log" &
+            {{if .WorkingDirectory}}cd {{.WorkingDirectory|sh}}{{end}}
+            eval "exec $cmd" >> "$stdout_log" 2>> "$stderr_log" &
             echo $! > "$pid_file"
             if ! is_running; then
                 echo "Unable to start, see $stdout_log and $stderr_log"
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"syscall"
 	"text/template"
@@ -22,6 +23,16 @@ const version = "solaris-smf"
 
 type solarisSystem struct{}
 
+var solarisSupport = systemSupport{
+	name:    unixServiceName,
+	fields:  []string{"Credentials"},
+	options: joinOptions([]string{optionSysvScript, optionPrefix, optionLogDirectory, optionRunWait}, logOptions, unixLogOptions),
+}
+
+func (solarisSystem) support() systemSupport {
+	return solarisSupport
+}
+
 func (solarisSystem) String() string {
 	return version
 }
@@ -34,13 +45,20 @@ func (solarisSystem) Interactive() bool {
 func (solarisSystem) New(i Interface, c *Config) (Service, error) {
 	s := &solarisService{
 		i:      i,
-		Config: c,
+		Config: withOptions(c),
 
 		Prefix: c.Option.string(optionPrefix, optionPrefixDefault),
 	}
 
 	return s, nil
 }
+func (ss solarisSystem) List() ([]Installed, error) {
+	dirs, err := filepath.Glob("/lib/svc/manifest/*")
+	if err != nil {
+		return nil, err
+	}
+	return listInstalled(ss, dirs)
+}
 
 func init() {
 	ChooseSystem(solarisSystem{})
@@ -57,8 +75,14 @@ func init() {
 }
 
 func isInteractive() (bool, error) {
+	return DetectContext().Interactive(), nil
+}
+
+func detectSupervisor(c *RunContext) {
 	// The PPid of a service process be 1 / init.
-	return os.Getppid() != 1, nil
+	if getppid() == 1 {
+		c.Supervisor = "init"
+	}
 }
 
 type solarisService struct {
@@ -79,15 +103,23 @@ func (s *solarisService) Platform() string {
 	return version
 }
 
+func (s *solarisService) validate() error {
+	return s.Config.Validate(solarisSystem{})
+}
+
+func (s *solarisService) warnings() []FieldError {
+	return s.Config.Warnings(solarisSystem{})
+}
+
 func (s *solarisService) template() *template.Template {
-	functions := template.FuncMap{
+	functions := withEscapeFuncs(template.FuncMap{
 		"bool": func(v bool) string {
 			if v {
 				return "true"
 			}
 			return "false"
 		},
-	}
+	})
 
 	customConfig := s.Option.string(optionSysvScript, "")
 
@@ -107,6 +139,9 @@ func (s *solarisService) getFMRI() string {
 }
 
 func (s *solarisService) Install() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
 	// write start script
 	confPath, err := s.configPath()
 	if err != nil {
@@ -117,12 +152,6 @@ func (s *solarisService) Install() error {
 		return fmt.Errorf("Manifest already exists: %s", confPath)
 	}
 
-	f, err := os.Create(confPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	path, err := s.execPath()
 	if err != nil {
 		return err
@@ -137,35 +166,49 @@ func (s *solarisService) Install() error {
 		Prefix  string
 		Display string
 		Path    string
+
+		CredentialsDirectory string
 	}{
 		s.Config,
 		s.Prefix,
 		Display,
 		path,
+		credentialsDirectory(s.Config),
 	}
 
-	err = s.template().Execute(f, to)
+	b, err := renderStamped(s.template(), to, markerXML, s.Config)
 	if err != nil {
 		return err
 	}
 
-	// import service
-	err = run("svcadm", "restart", "manifest-import")
-	if err != nil {
+	tx := &installTx{}
+	if err = tx.writeCredentials(s.Config); err != nil {
+		return err
+	}
+	if err = tx.writeFile(confPath, b, 0644); err != nil {
 		return err
 	}
 
-	return nil
+	// import service
+	return tx.do("import manifest", func() error {
+		return run("svcadm", "restart", "manifest-import")
+	}, nil)
 }
 
 func (s *solarisService) Uninstall() error {
+	_, err := UninstallWithOptions(s, UninstallOptions{})
+	return err
+}
+
+func (s *solarisService) uninstall(opts UninstallOptions, r *UninstallReport) error {
+	// Disable the service even when it is not running.
 	s.Stop()
 
 	confPath, err := s.configPath()
 	if err != nil {
 		return err
 	}
-	err = os.Remove(confPath)
+	err = r.removeInstalled(confPath)
 	if err != nil {
 		return err
 	}
@@ -176,7 +219,10 @@ func (s *solarisService) Uninstall() error {
 		return err
 	}
 
-	return nil
+	if err := r.removeCredentials(s.Config); err != nil {
+		return err
+	}
+	return r.purge(opts, s.Config)
 }
 
 func (s *solarisService) Status() (Status, error) {
@@ -215,6 +261,9 @@ func (s *solarisService) Restart() error {
 }
 
 func (s *solarisService) Run() error {
+	defer flushLoggers()
+	defer redirectOutput(s, s.Config, interactive)()
+
 	var err error
 
 	err = s.i.Start(s)
@@ -232,21 +281,18 @@ func (s *solarisService) Run() error {
 }
 
 func (s *solarisService) Logger(errs chan<- error) (Logger, error) {
-	if interactive {
-		return ConsoleLogger, nil
-	}
-	return s.SystemLogger(errs)
+	return selectLogger(s, s.Config, interactive, errs)
 }
 func (s *solarisService) SystemLogger(errs chan<- error) (Logger, error) {
-	return newSysLogger(s.Name, errs)
+	return newLogger(s.Config, errs)
 }
 
 var manifest = `<?xml version="1.0"?>
 <!DOCTYPE service_bundle SYSTEM "/usr/share/lib/xml/dtd/service_bundle.dtd.1">
 
-<service_bundle type='manifest' name='golang-{{.Name}}'>
+<service_bundle type='manifest' name='golang-{{.Name|xml}}'>
 <service
-	name='{{.Prefix}}/{{.Name}}'
+	name='{{.Prefix|xml}}/{{.Name|xml}}'
 	type='service'
 	version='1'>
 	
@@ -278,13 +324,21 @@ var manifest = `<?xml version="1.0"?>
 	<exec_method
 		type='method'
 		name='start'
-		exec='bash -c {{.Path}} &amp;'
-		timeout_seconds='10' />
+		exec='bash -c {{shCommand .Path .Arguments|sh|xml}} &amp;'
+		timeout_seconds='10'>
+		{{- if .CredentialsDirectory}}
+		<method_context>
+			<method_environment>
+				<envvar name='CREDENTIALS_DIRECTORY' value='{{.CredentialsDirectory|xml}}' />
+			</method_environment>
+		</method_context>
+		{{- end}}
+	</exec_method>
 
 	<exec_method
 		type='method'
 		name='stop'
-		exec='pkill -TERM -f {{.Path}}'
+		exec='pkill -TERM -f {{.Path|sh|xml}}'
 		timeout_seconds='60' />
 
 	<!--
@@ -0,0 +1,354 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobResult is the result of a systemd job, as reported by the JobRemoved
+// signal of the org.freedesktop.systemd1.Manager interface.
+type JobResult string
+
+// Job results reported by systemd.
+const (
+	JobDone       JobResult = "done"
+	JobCanceled   JobResult = "canceled"
+	JobTimeout    JobResult = "timeout"
+	JobFailed     JobResult = "failed"
+	JobDependency JobResult = "dependency"
+	JobSkipped    JobResult = "skipped"
+)
+
+// JobError is returned by the D-Bus transport when a systemd job does not
+// finish with JobDone.
+type JobError struct {
+	Unit   string
+	Job    string // D-Bus object path of the job.
+	Result JobResult
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("systemd job for %s finished with result %q", e.Unit, e.Result)
+}
+
+const systemdJobTimeout = 5 * time.Minute
+
+// systemdTransport performs unit operations for the systemd service.
+type systemdTransport interface {
+	startUnit(unit string) error
+	stopUnit(unit string) error
+	restartUnit(unit string) error
+	reloadOrRestartUnit(unit string) error
+	enableUnit(unit string) error
+	disableUnit(unit string) error
+	daemonReload() error
+	unitStatus(unit string) (Status, error)
+	close() error
+}
+
+// transport returns the D-Bus transport when the SystemdDBus option is set
+// and the bus is reachable, otherwise it returns the systemctl transport.
+// Services installed for another user or for every user always use systemctl.
+func (s *systemd) transport() systemdTransport {
+	if s.Option.bool(optionSystemdDBus, optionSystemdDBusDefault) && s.userServiceFor() == "" && !s.isGlobalUserService() {
+		address := dbusSystemBusAddress()
+		if s.isUserService() {
+			address = "unix:path=/run/user/" + strconv.Itoa(os.Getuid()) + "/bus"
+			if a := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); a != "" {
+				address = a
+			} else if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+				address = "unix:path=" + dir + "/bus"
+			}
+		}
+		if t, err := newSystemdDBus(address); err == nil {
+			return t
+		}
+	}
+	return systemctlTransport{s}
+}
+
+// systemctlTransport runs systemctl for each operation.
+type systemctlTransport struct {
+	s *systemd
+}
+
+func (t systemctlTransport) startUnit(unit string) error {
+	return t.s.run("start", unit)
+}
+
+func (t systemctlTransport) stopUnit(unit string) error {
+	return t.s.run("stop", unit)
+}
+
+func (t systemctlTransport) restartUnit(unit string) error {
+	return t.s.run("restart", unit)
+}
+
+func (t systemctlTransport) reloadOrRestartUnit(unit string) error {
+	return t.s.run("reload-or-restart", unit)
+}
+
+func (t systemctlTransport) enableUnit(unit string) error {
+	if t.s.isGlobalUserService() {
+		return run("systemctl", "--global", "enable", unit)
+	}
+	return t.s.run("enable", unit)
+}
+
+func (t systemctlTransport) disableUnit(unit string) error {
+	if t.s.isGlobalUserService() {
+		return run("systemctl", "--global", "disable", unit)
+	}
+	return t.s.run("disable", unit)
+}
+
+// daemonReload reloads the manager the service is installed in. Global user
+// units are picked up by each user manager on its next start.
+func (t systemctlTransport) daemonReload() error {
+	if t.s.isGlobalUserService() {
+		return nil
+	}
+	return t.s.run("daemon-reload")
+}
+
+func (t systemctlTransport) unitStatus(unit string) (Status, error) {
+	exitCode, out, err := t.s.runWithOutput("systemctl", "is-active", unit)
+	if exitCode == 0 && err != nil {
+		return StatusUnknown, err
+	}
+
+	switch {
+	case strings.HasPrefix(out, "active"):
+		return StatusRunning, nil
+	case strings.HasPrefix(out, "inactive"):
+		// inactive can also mean its not installed, check unit files
+		exitCode, out, err := t.s.runWithOutput("systemctl", "list-unit-files", "-t", "service", unit)
+		if exitCode == 0 && err != nil {
+			return StatusUnknown, err
+		}
+		if strings.Contains(out, t.s.Name) {
+			// unit file exists, installed but not running
+			return StatusStopped, nil
+		}
+		// no unit file
+		return StatusUnknown, ErrNotInstalled
+	case strings.HasPrefix(out, "activating"):
+		return StatusRunning, nil
+	case strings.HasPrefix(out, "failed"):
+		return StatusUnknown, errors.New("service in failed state")
+	default:
+		return StatusUnknown, ErrNotInstalled
+	}
+}
+
+func (t systemctlTransport) close() error {
+	return nil
+}
+
+const (
+	systemdBusName   = "org.freedesktop.systemd1"
+	systemdBusPath   = dbusObjectPath("/org/freedesktop/systemd1")
+	systemdManager   = "org.freedesktop.systemd1.Manager"
+	systemdUnit      = "org.freedesktop.systemd1.Unit"
+	dbusPropertiesIf = "org.freedesktop.DBus.Properties"
+)
+
+// systemdDBus talks to the org.freedesktop.systemd1 D-Bus API.
+type systemdDBus struct {
+	conn *dbusConn
+
+	mu      sync.Mutex
+	pending int
+	waiters map[dbusObjectPath]chan JobResult
+	// results holds jobs that finished before their waiter was registered.
+	results map[dbusObjectPath]JobResult
+}
+
+func newSystemdDBus(address string) (*systemdDBus, error) {
+	conn, err := dialDBus(address)
+	if err != nil {
+		return nil, err
+	}
+	t := &systemdDBus{
+		conn:    conn,
+		waiters: make(map[dbusObjectPath]chan JobResult),
+		results: make(map[dbusObjectPath]JobResult),
+	}
+	conn.setHandler(t.handle)
+
+	_, err = conn.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", "s",
+		"type='signal',sender='"+systemdBusName+"',interface='"+systemdManager+"',member='JobRemoved'")
+	if err == nil {
+		// Without a subscription systemd does not emit JobRemoved.
+		_, err = t.manager("Subscribe", "")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *systemdDBus) handle(m *dbusMessage) {
+	if m.Type != dbusTypeSignal || m.Interface != systemdManager || m.Member != "JobRemoved" || len(m.Body) != 4 {
+		return
+	}
+	job, _ := m.Body[1].(dbusObjectPath)
+	result, _ := m.Body[3].(string)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ch, ok := t.waiters[job]; ok {
+		delete(t.waiters, job)
+		ch <- JobResult(result)
+		return
+	}
+	if t.pending > 0 {
+		t.results[job] = JobResult(result)
+	}
+}
+
+func (t *systemdDBus) manager(method, sig string, args ...interface{}) ([]interface{}, error) {
+	return t.conn.call(systemdBusName, systemdBusPath, systemdManager, method, sig, args...)
+}
+
+// job calls a Manager method that queues a job for unit and waits for the
+// job to finish.
+func (t *systemdDBus) job(method, unit string) error {
+	t.mu.Lock()
+	t.pending++
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.pending--
+		if t.pending == 0 {
+			t.results = make(map[dbusObjectPath]JobResult)
+		}
+		t.mu.Unlock()
+	}()
+
+	body, err := t.manager(method, "ss", unit, "replace")
+	if err != nil {
+		return err
+	}
+	job, ok := body[0].(dbusObjectPath)
+	if !ok {
+		return fmt.Errorf("dbus: unexpected reply to %s: %v", method, body)
+	}
+
+	t.mu.Lock()
+	result, done := t.results[job]
+	ch := make(chan JobResult, 1)
+	if done {
+		delete(t.results, job)
+	} else {
+		t.waiters[job] = ch
+	}
+	t.mu.Unlock()
+
+	if !done {
+		select {
+		case result = <-ch:
+		case <-t.conn.done:
+			return t.conn.err
+		case <-time.After(systemdJobTimeout):
+			t.mu.Lock()
+			delete(t.waiters, job)
+			t.mu.Unlock()
+			return fmt.Errorf("timed out waiting for systemd job %s", job)
+		}
+	}
+	if result != JobDone {
+		return &JobError{Unit: unit, Job: string(job), Result: result}
+	}
+	return nil
+}
+
+func (t *systemdDBus) startUnit(unit string) error {
+	return t.job("StartUnit", unit)
+}
+
+func (t *systemdDBus) stopUnit(unit string) error {
+	return t.job("StopUnit", unit)
+}
+
+func (t *systemdDBus) restartUnit(unit string) error {
+	return t.job("RestartUnit", unit)
+}
+
+func (t *systemdDBus) reloadOrRestartUnit(unit string) error {
+	return t.job("ReloadOrRestartUnit", unit)
+}
+
+func (t *systemdDBus) enableUnit(unit string) error {
+	_, err := t.manager("EnableUnitFiles", "asbb", []string{unit}, false, false)
+	return err
+}
+
+func (t *systemdDBus) disableUnit(unit string) error {
+	_, err := t.manager("DisableUnitFiles", "asb", []string{unit}, false)
+	return err
+}
+
+func (t *systemdDBus) daemonReload() error {
+	_, err := t.manager("Reload", "")
+	return err
+}
+
+// unitProperty reads a property of the org.freedesktop.systemd1.Unit
+// interface, loading the unit if needed.
+func (t *systemdDBus) unitProperty(unit, name string) (interface{}, error) {
+	body, err := t.manager("LoadUnit", "s", unit)
+	if err != nil {
+		return nil, err
+	}
+	path, ok := body[0].(dbusObjectPath)
+	if !ok {
+		return nil, fmt.Errorf("dbus: unexpected reply to LoadUnit: %v", body)
+	}
+	body, err = t.conn.call(systemdBusName, path, dbusPropertiesIf, "Get", "ss", systemdUnit, name)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := body[0].(dbusVariant)
+	if !ok {
+		return nil, fmt.Errorf("dbus: unexpected reply to Get: %v", body)
+	}
+	return v.Value, nil
+}
+
+func (t *systemdDBus) unitStatus(unit string) (Status, error) {
+	load, err := t.unitProperty(unit, "LoadState")
+	if err != nil {
+		return StatusUnknown, err
+	}
+	if load == "not-found" {
+		return StatusUnknown, ErrNotInstalled
+	}
+	active, err := t.unitProperty(unit, "ActiveState")
+	if err != nil {
+		return StatusUnknown, err
+	}
+	switch active {
+	case "active", "activating", "reloading":
+		return StatusRunning, nil
+	case "inactive", "deactivating":
+		return StatusStopped, nil
+	case "failed":
+		return StatusUnknown, errors.New("service in failed state")
+	}
+	return StatusUnknown, fmt.Errorf("unknown unit state %v", active)
+}
+
+func (t *systemdDBus) close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,328 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testBusConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startTestBus starts a private dbus-daemon and returns its address.
+func startTestBus(t *testing.T) string {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	dir, err := ioutil.TempDir("", "dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	conf := filepath.Join(dir, "bus.conf")
+	socket := filepath.Join(dir, "bus")
+	config := strings.Replace(testBusConfig, "%s", socket, 1)
+	if err := ioutil.WriteFile(conf, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(daemon, "--config-file="+conf, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("dbus-daemon did not print its address: %v", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+// fakeSystemd answers the systemd Manager methods used by systemdDBus.
+// Units named "fail.service" fail to start and "missing.service" is not found.
+func fakeSystemd(t *testing.T, address string) {
+	c, err := dialDBus(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	reply := func(m *dbusMessage, sig string, body ...interface{}) {
+		err := c.send(&dbusMessage{
+			Type:        dbusTypeMethodReturn,
+			ReplySerial: m.Serial,
+			Destination: m.Sender,
+			Signature:   sig,
+			Body:        body,
+		}, nil)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	c.setHandler(func(m *dbusMessage) {
+		if m.Type != dbusTypeMethodCall {
+			return
+		}
+		switch m.Member {
+		case "Subscribe", "Reload":
+			reply(m, "")
+		case "StartUnit", "StopUnit", "RestartUnit", "ReloadOrRestartUnit":
+			unit := m.Body[0].(string)
+			job := dbusObjectPath("/org/freedesktop/systemd1/job/" + strings.Replace(unit, ".", "_", -1))
+			reply(m, "o", job)
+			result := "done"
+			if unit == "fail.service" {
+				result = "failed"
+			}
+			c.send(&dbusMessage{
+				Type:      dbusTypeSignal,
+				Path:      systemdBusPath,
+				Interface: systemdManager,
+				Member:    "JobRemoved",
+				Signature: "uoss",
+				Body:      []interface{}{uint32(1), job, unit, result},
+			}, nil)
+		case "EnableUnitFiles":
+			reply(m, "ba(sss)", true, []interface{}{
+				[]interface{}{"symlink", "/etc/systemd/system/multi-user.target.wants/a.service", "/etc/systemd/system/a.service"},
+			})
+		case "DisableUnitFiles":
+			reply(m, "a(sss)", []interface{}{})
+		case "LoadUnit":
+			reply(m, "o", dbusObjectPath("/org/freedesktop/systemd1/unit/"+strings.Replace(m.Body[0].(string), ".", "_", -1)))
+		case "Get":
+			value := "active"
+			switch {
+			case strings.HasSuffix(string(m.Path), "missing_service") && m.Body[1] == "LoadState":
+				value = "not-found"
+			case m.Body[1] == "LoadState":
+				value = "loaded"
+			}
+			reply(m, "v", dbusVariant{Sig: "s", Value: value})
+		}
+	})
+	body, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "RequestName", "su", systemdBusName, uint32(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body[0] != uint32(1) {
+		t.Fatalf("RequestName = %v, want primary owner", body[0])
+	}
+}
+
+func TestSystemdDBus(t *testing.T) {
+	address := startTestBus(t)
+	fakeSystemd(t, address)
+
+	tr, err := newSystemdDBus(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.close()
+
+	if err := tr.startUnit("a.service"); err != nil {
+		t.Errorf("startUnit() = %v", err)
+	}
+	if err := tr.stopUnit("a.service"); err != nil {
+		t.Errorf("stopUnit() = %v", err)
+	}
+	if err := tr.restartUnit("a.service"); err != nil {
+		t.Errorf("restartUnit() = %v", err)
+	}
+	if err := tr.reloadOrRestartUnit("a.service"); err != nil {
+		t.Errorf("reloadOrRestartUnit() = %v", err)
+	}
+	err = tr.startUnit("fail.service")
+	var jobErr *JobError
+	if !errors.As(err, &jobErr) || jobErr.Result != JobFailed || jobErr.Unit != "fail.service" {
+		t.Errorf("startUnit(fail) = %v, want JobError with result failed", err)
+	}
+	if err := tr.enableUnit("a.service"); err != nil {
+		t.Errorf("enableUnit() = %v", err)
+	}
+	if err := tr.disableUnit("a.service"); err != nil {
+		t.Errorf("disableUnit() = %v", err)
+	}
+	if err := tr.daemonReload(); err != nil {
+		t.Errorf("daemonReload() = %v", err)
+	}
+	if status, err := tr.unitStatus("a.service"); status != StatusRunning || err != nil {
+		t.Errorf("unitStatus() = %v, %v, want running", status, err)
+	}
+	if _, err := tr.unitStatus("missing.service"); err != ErrNotInstalled {
+		t.Errorf("unitStatus(missing) error = %v, want %v", err, ErrNotInstalled)
+	}
+}
+
+func TestSystemdDBusUnreachable(t *testing.T) {
+	if _, err := newSystemdDBus("unix:path=/nonexistent/bus"); err == nil {
+		t.Fatal("newSystemdDBus() succeeded on a missing socket")
+	}
+	s := &systemd{Config: &Config{Name: "test", Option: KeyValue{optionSystemdDBus: true}}}
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path=/nonexistent/bus")
+	if _, ok := s.transport().(systemctlTransport); !ok {
+		t.Error("transport() did not fall back to systemctl")
+	}
+}
+
+func TestDBusMarshal(t *testing.T) {
+	m := &dbusMessage{
+		Type:        dbusTypeMethodCall,
+		Serial:      7,
+		Path:        "/a/b",
+		Interface:   "x.y",
+		Member:      "Z",
+		Destination: "x.y",
+		Signature:   "sasba(sv)ytx",
+		Body: []interface{}{
+			"hello",
+			[]interface{}{"a", "bc"},
+			true,
+			[]interface{}{[]interface{}{"k", dbusVariant{Sig: "u", Value: uint32(3)}}},
+			byte(9),
+			uint64(1 << 40),
+			int64(-2),
+		},
+	}
+	b, err := m.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readDBusMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("round trip = %+v, want %+v", got, m)
+	}
+}
+
+func TestDBusDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		sig  string
+		buf  []byte
+	}{
+		{"string length", "s", []byte{0xff, 0xff, 0xff, 0xff, 'a', 0}},
+		{"array length", "ay", []byte{0xff, 0xff, 0xff, 0xff, 1}},
+		{"empty struct variant", "v", []byte{2, '(', ')', 0}},
+		{"empty struct array", "a()", append([]byte{8, 0, 0, 0}, make([]byte, 12)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dbusDecoder{buf: tt.buf, order: binary.LittleEndian}
+			if v, err := d.decode(tt.sig); err == nil {
+				t.Errorf("decode(%q) = %v, want error", tt.sig, v)
+			}
+		})
+	}
+}
+
+func TestDBusDecodeDepth(t *testing.T) {
+	// nested returns the signature and value of n containers, made by wrap,
+	// around a byte.
+	nested := func(n int, wrap func(sig string, v interface{}) (string, interface{})) (string, interface{}) {
+		sig, v := "y", interface{}(byte(1))
+		for i := 0; i < n; i++ {
+			sig, v = wrap(sig, v)
+		}
+		return sig, v
+	}
+	array := func(sig string, v interface{}) (string, interface{}) { return "a" + sig, []interface{}{v} }
+	strct := func(sig string, v interface{}) (string, interface{}) { return "(" + sig + ")", []interface{}{v} }
+	variant := func(sig string, v interface{}) (string, interface{}) {
+		return "v", dbusVariant{Sig: sig, Value: v}
+	}
+	tests := []struct {
+		name  string
+		n     int
+		wrap  func(string, interface{}) (string, interface{})
+		valid bool
+	}{
+		{"arrays", dbusMaxArrayDepth, array, true},
+		{"too many arrays", dbusMaxArrayDepth + 1, array, false},
+		{"structs", dbusMaxStructDepth, strct, true},
+		{"too many structs", dbusMaxStructDepth + 1, strct, false},
+		{"variants", dbusMaxContainerDepth, variant, true},
+		{"too many variants", dbusMaxContainerDepth + 1, variant, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, v := nested(tt.n, tt.wrap)
+			e := &dbusEncoder{}
+			if err := e.encode(sig, v); err != nil {
+				t.Fatal(err)
+			}
+			d := &dbusDecoder{buf: e.buf, order: binary.LittleEndian}
+			got, err := d.decode(sig)
+			if !tt.valid {
+				if !errors.Is(err, errDBusDepth) {
+					t.Errorf("decode() = %v, want %v", err, errDBusDepth)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, v) {
+				t.Errorf("decode() = %v, %v, want %v", got, err, v)
+			}
+		})
+	}
+}
+
+func TestSystemdUninstallKeepsDropIns(t *testing.T) {
+	address := startTestBus(t)
+	fakeSystemd(t, address)
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+
+	s := &systemd{Config: &Config{Name: "a", Option: KeyValue{optionUserService: true, optionSystemdDBus: true}}}
+	unit := filepath.Join(config, "systemd/user/a.service")
+	dropIn := filepath.Join(unit+".d", "override.conf")
+	for _, opts := range []UninstallOptions{{}, {Purge: true}} {
+		if err := os.MkdirAll(filepath.Dir(dropIn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{unit, dropIn} {
+			if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var r UninstallReport
+		if err := s.uninstall(opts, &r); err != nil {
+			t.Fatalf("uninstall(%+v) = %v", opts, err)
+		}
+		if _, err := os.Stat(dropIn); os.IsNotExist(err) != opts.Purge {
+			t.Errorf("uninstall(%+v): drop-in removed = %v, want %v", opts, os.IsNotExist(err), opts.Purge)
+		}
+	}
+}
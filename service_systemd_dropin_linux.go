@@ -0,0 +1,127 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func (s *systemd) dropInDir() (string, error) {
+	cp, err := s.configPath()
+	if err != nil {
+		return "", err
+	}
+	return cp + ".d", nil
+}
+
+// dropInFileName validates name and returns it with a ".conf" suffix.
+func dropInFileName(name string) (string, error) {
+	if len(name) == 0 || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", ErrInvalidDropInName
+	}
+	if !strings.HasSuffix(name, ".conf") {
+		name += ".conf"
+	}
+	return name, nil
+}
+
+// readDropIns reads every "*.conf" file in dir. A missing dir is not an error.
+func readDropIns(dir string) ([]DropIn, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var dropIns []DropIn
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".conf") {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		dropIns = append(dropIns, DropIn{
+			Name:    e.Name(),
+			Path:    p,
+			Content: string(b),
+		})
+	}
+	sort.Slice(dropIns, func(i, j int) bool { return dropIns[i].Name < dropIns[j].Name })
+	return dropIns, nil
+}
+
+func (s *systemd) DropIns() ([]DropIn, error) {
+	dir, err := s.dropInDir()
+	if err != nil {
+		return nil, err
+	}
+	return readDropIns(dir)
+}
+
+func (s *systemd) WriteDropIn(d DropIn) error {
+	name, err := dropInFileName(d.Name)
+	if err != nil {
+		return err
+	}
+	dir, err := s.dropInDir()
+	if err != nil {
+		return err
+	}
+	uid, gid := -1, -1
+	if s.userServiceFor() != "" {
+		if _, uid, gid, err = s.userUnitDir(); err != nil {
+			return err
+		}
+	}
+	if err := mkdirAllOwned(dir, uid, gid); err != nil {
+		return err
+	}
+	content := d.Content
+	if len(content) > 0 && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	p := filepath.Join(dir, name)
+	if err := writeFileAtomic(p, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := s.chown(p); err != nil {
+		return err
+	}
+	return s.daemonReload()
+}
+
+func (s *systemd) RemoveDropIn(name string) error {
+	name, err := dropInFileName(name)
+	if err != nil {
+		return err
+	}
+	dir, err := s.dropInDir()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	// Only succeeds when the directory is empty.
+	os.Remove(dir)
+	return s.daemonReload()
+}
+
+func (s *systemd) EffectiveConfig() (string, error) {
+	_, out, err := s.runWithOutput("systemctl", "cat", s.unitName())
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
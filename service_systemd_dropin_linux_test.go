@@ -0,0 +1,76 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_dropInFileName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"10-limits", "10-limits.conf", false},
+		{"override.conf", "override.conf", false},
+		{"", "", true},
+		{"..", "", true},
+		{"../escape", "", true},
+		{`a\b`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dropInFileName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dropInFileName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("dropInFileName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readDropIns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dropin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"20-env.conf":    "[Service]\nEnvironment=A=1\n",
+		"10-limits.conf": "[Service]\nLimitNOFILE=65536\n",
+		"ignored.txt":    "not a drop-in",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := readDropIns(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DropIn{
+		{Name: "10-limits.conf", Path: filepath.Join(dir, "10-limits.conf"), Content: files["10-limits.conf"]},
+		{Name: "20-env.conf", Path: filepath.Join(dir, "20-env.conf"), Content: files["20-env.conf"]},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readDropIns() = %v, want %v", got, want)
+	}
+
+	got, err = readDropIns(filepath.Join(dir, "missing"))
+	if err != nil || got != nil {
+		t.Errorf("readDropIns(missing) = %v, %v, want nil, nil", got, err)
+	}
+}
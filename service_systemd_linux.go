@@ -6,11 +6,11 @@ package service
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
+	"os/user"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -53,6 +53,15 @@ type systemd struct {
 	*Config
 }
 
+var systemdSupport = systemSupport{
+	name:                 unixServiceName,
+	fields:               []string{"UserName", "WorkingDirectory", "ChRoot", "Dependencies", "EnvVars", "Credentials"},
+	options:              joinOptions([]string{optionSystemdScript, optionUserServiceFor, optionUserServiceGlobal, optionLinger, optionSystemdDBus, optionReloadSignal, optionPIDFile, optionLimitNOFILE, optionRestart, optionSuccessExitStatus, optionLogOutput, optionLogDirectory, optionEnvFile, optionLogRotate, optionRunWait}, logOptions, unixLogOptions),
+	userService:          true,
+	encryptedCredentials: true,
+	check:                checkSystemd,
+}
+
 func newSystemdService(i Interface, platform string, c *Config) (Service, error) {
 	s := &systemd{
 		i:        i,
@@ -74,17 +83,50 @@ func (s *systemd) Platform() string {
 	return s.platform
 }
 
+func (s *systemd) validate() error {
+	return validateConfig(s.Config, s.platform, &systemdSupport)
+}
+
+func (s *systemd) warnings() []FieldError {
+	return configWarnings(s.Config, s.platform, &systemdSupport)
+}
+
+var systemdRestart = []string{"no", "always", "on-success", "on-failure", "on-abnormal", "on-abort", "on-watchdog"}
+
+// checkSystemd checks the values of the options rendered into the unit.
+func checkSystemd(c *Config, e *ValidationError) {
+	field := func(k string) string { return fmt.Sprintf("Option[%q]", k) }
+	if v := c.Option.string(optionRestart, ""); v != "" && !contains(systemdRestart, v) {
+		e.add(field(optionRestart), "%q is not one of %s", v, strings.Join(systemdRestart, ", "))
+	}
+	if v := c.Option.string(optionReloadSignal, ""); v != "" && !validSignal(v) {
+		e.add(field(optionReloadSignal), "unknown signal %q", v)
+	}
+	if v := c.Option.int(optionLimitNOFILE, optionLimitNOFILEDefault); v < -1 {
+		e.add(field(optionLimitNOFILE), "%d is negative", v)
+	}
+	for _, v := range strings.Fields(c.Option.string(optionSuccessExitStatus, "")) {
+		n, err := strconv.Atoi(v)
+		if err == nil && (n < 0 || n > 255) || err != nil && !validSignal(v) {
+			e.add(field(optionSuccessExitStatus), "%q is neither an exit status nor a signal", v)
+		}
+	}
+}
+
 func (s *systemd) configPath() (cp string, err error) {
 	if !s.isUserService() {
 		cp = "/etc/systemd/system/" + s.unitName()
 		return
 	}
-	homeDir, err := os.UserHomeDir()
+	if s.isGlobalUserService() {
+		cp = "/etc/systemd/user/" + s.unitName()
+		return
+	}
+	systemdUserDir, uid, gid, err := s.userUnitDir()
 	if err != nil {
 		return
 	}
-	systemdUserDir := filepath.Join(homeDir, ".config/systemd/user")
-	err = os.MkdirAll(systemdUserDir, os.ModePerm)
+	err = mkdirAllOwned(systemdUserDir, uid, gid)
 	if err != nil {
 		return
 	}
@@ -92,6 +134,96 @@ func (s *systemd) configPath() (cp string, err error) {
 	return
 }
 
+// userUnitDir returns the systemd user unit directory of the user the service
+// is installed for, and the owner files created in it should have.
+// The owner is -1 when the service is installed for the current user.
+func (s *systemd) userUnitDir() (dir string, uid, gid int, err error) {
+	if name := s.userServiceFor(); name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", -1, -1, err
+		}
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return "", -1, -1, err
+		}
+		gid, err = strconv.Atoi(u.Gid)
+		if err != nil {
+			return "", -1, -1, err
+		}
+		return filepath.Join(u.HomeDir, ".config/systemd/user"), uid, gid, nil
+	}
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", -1, -1, err
+		}
+		configDir = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configDir, "systemd/user"), -1, -1, nil
+}
+
+// envFile returns the environment file of the service. User services keep
+// it next to their unit, where the user manager can read it.
+func (s *systemd) envFile() (string, error) {
+	if !s.isUserService() || s.Option.string(optionEnvFile, "") != "" {
+		return envFilePath(s.Config), nil
+	}
+	cp, err := s.configPath()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(cp, ".service") + ".env", nil
+}
+
+// systemdListDirs returns the directories holding system units, units for
+// every user and the units of the current user.
+func systemdListDirs() []string {
+	dirs := []string{"/etc/systemd/system", "/etc/systemd/user"}
+	if dir, _, _, err := (&systemd{Config: &Config{}}).userUnitDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// chown gives path to the user the service is installed for, if that is
+// not the current user.
+func (s *systemd) chown(path string) error {
+	if s.userServiceFor() == "" {
+		return nil
+	}
+	_, uid, gid, err := s.userUnitDir()
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// mkdirAllOwned is like os.MkdirAll but gives every directory it creates to
+// uid and gid, unless they are -1.
+func mkdirAllOwned(dir string, uid, gid int) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || d == filepath.Dir(d) {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	for _, d := range created {
+		if err := os.Chown(d, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *systemd) unitName() string {
 	return s.Config.Name + ".service"
 }
@@ -143,7 +275,49 @@ func (s *systemd) isUserService() bool {
 	return s.Option.bool(optionUserService, optionUserServiceDefault)
 }
 
+// userServiceFor returns the user a user service is installed for, or an
+// empty string for the current user.
+func (s *systemd) userServiceFor() string {
+	if !s.isUserService() {
+		return ""
+	}
+	return s.Option.string(optionUserServiceFor, "")
+}
+
+func (s *systemd) isGlobalUserService() bool {
+	return s.isUserService() && s.Option.bool(optionUserServiceGlobal, false)
+}
+
+// wantedBy returns the target that pulls in the service at boot. The
+// multi-user.target does not exist in a user manager.
+func (s *systemd) wantedBy() string {
+	if s.isUserService() {
+		return "default.target"
+	}
+	return "multi-user.target"
+}
+
+// enableLinger lets the user manager of a user service start at boot and
+// outlive the login session.
+func (s *systemd) enableLinger() error {
+	if !s.isUserService() || s.isGlobalUserService() || !s.Option.bool(optionLinger, optionLingerDefault) {
+		return nil
+	}
+	name := s.userServiceFor()
+	if name == "" {
+		u, err := user.Current()
+		if err != nil {
+			return err
+		}
+		name = u.Username
+	}
+	return run("loginctl", "enable-linger", name)
+}
+
 func (s *systemd) Install() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
 	confPath, err := s.configPath()
 	if err != nil {
 		return err
@@ -153,20 +327,23 @@ func (s *systemd) Install() error {
 		return fmt.Errorf("Init already exists: %s", confPath)
 	}
 
-	f, err := os.OpenFile(confPath, os.O_WRONLY|os.O_CREATE, 0644)
+	path, err := s.execPath()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	path, err := s.execPath()
+	envFile, err := s.envFile()
 	if err != nil {
 		return err
 	}
+	if err = validateCredentials(s.Config, true); err != nil {
+		return err
+	}
 
 	var to = &struct {
 		*Config
 		Path                 string
+		UserService          bool
+		WantedBy             string
 		HasOutputFileSupport bool
 		ReloadSignal         string
 		PIDFile              string
@@ -175,9 +352,12 @@ func (s *systemd) Install() error {
 		SuccessExitStatus    string
 		LogOutput            bool
 		LogDirectory         string
+		EnvFile              string
 	}{
 		s.Config,
 		path,
+		s.isUserService(),
+		s.wantedBy(),
 		s.hasOutputFileSupport(),
 		s.Option.string(optionReloadSignal, ""),
 		s.Option.string(optionPIDFile, ""),
@@ -186,23 +366,62 @@ func (s *systemd) Install() error {
 		s.Option.string(optionSuccessExitStatus, ""),
 		s.Option.bool(optionLogOutput, optionLogOutputDefault),
 		s.Option.string(optionLogDirectory, defaultLogDirectory),
+		envFile,
 	}
 
-	err = s.template().Execute(f, to)
+	b, err := renderStamped(s.template(), to, markerHash, s.Config)
 	if err != nil {
 		return err
 	}
 
-	err = s.runAction("enable")
-	if err != nil {
+	perm := os.FileMode(0644)
+	if hasCredentialValues(s.Config) {
+		// The unit holds secrets.
+		perm = 0600
+	}
+	tx := &installTx{}
+	if err = tx.writeFile(confPath, b, perm); err != nil {
+		return err
+	}
+	if err = tx.do("chown "+confPath, func() error { return s.chown(confPath) }, nil); err != nil {
 		return err
 	}
+	if to.LogOutput && to.HasOutputFileSupport {
+		var reload string
+		if to.ReloadSignal != "" {
+			reload = "systemctl reload " + s.unitName() + " >/dev/null 2>&1 || true"
+		}
+		if err = tx.writeLogrotate(s.Config, outputFiles(s.Config, ".out", ".err"), reload); err != nil {
+			return err
+		}
+	}
 
-	return s.run("daemon-reload")
+	// The user manager must be running before it can be reached.
+	if err = tx.do("enable linger", s.enableLinger, nil); err != nil {
+		return err
+	}
+
+	t := s.transport()
+	defer t.close()
+
+	unit := s.unitName()
+	err = tx.do("enable "+unit, func() error { return t.enableUnit(unit) }, func() error { return t.disableUnit(unit) })
+	if err != nil {
+		return err
+	}
+	return tx.do("daemon-reload", t.daemonReload, nil)
 }
 
 func (s *systemd) Uninstall() error {
-	err := s.runAction("disable")
+	_, err := UninstallWithOptions(s, UninstallOptions{})
+	return err
+}
+
+func (s *systemd) uninstall(opts UninstallOptions, r *UninstallReport) error {
+	t := s.transport()
+	defer t.close()
+
+	err := t.disableUnit(s.unitName())
 	if err != nil {
 		return err
 	}
@@ -210,23 +429,49 @@ func (s *systemd) Uninstall() error {
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(cp); err != nil {
+	if err := r.removeInstalled(cp); err != nil {
+		return err
+	}
+	// Drop-ins belong to the operator; only purging removes them.
+	if opts.Purge {
+		if err := r.removeAll(cp + ".d"); err != nil {
+			return err
+		}
+	}
+	if pidFile := s.Option.string(optionPIDFile, ""); pidFile != "" {
+		if err := r.remove(pidFile); err != nil {
+			return err
+		}
+	}
+	if err := r.removeLogrotate(s.Config); err != nil {
 		return err
 	}
-	return s.run("daemon-reload")
+	if err := t.daemonReload(); err != nil {
+		return err
+	}
+	envFile, err := s.envFile()
+	if err != nil {
+		return err
+	}
+	logDir := s.Option.string(optionLogDirectory, defaultLogDirectory)
+	return r.purge(opts, s.Config,
+		logDir+"/"+s.Name+".out",
+		logDir+"/"+s.Name+".err",
+		envFile,
+	)
 }
 
 func (s *systemd) Logger(errs chan<- error) (Logger, error) {
-	if system.Interactive() {
-		return ConsoleLogger, nil
-	}
-	return s.SystemLogger(errs)
+	return selectLogger(s, s.Config, system.Interactive(), errs)
 }
 func (s *systemd) SystemLogger(errs chan<- error) (Logger, error) {
-	return newSysLogger(s.Name, errs)
+	return newLogger(s.Config, errs)
 }
 
 func (s *systemd) Run() (err error) {
+	defer flushLoggers()
+	defer redirectOutput(s, s.Config, system.Interactive())()
+
 	err = s.i.Start(s)
 	if err != nil {
 		return err
@@ -242,94 +487,123 @@ func (s *systemd) Run() (err error) {
 }
 
 func (s *systemd) Status() (Status, error) {
-	exitCode, out, err := s.runWithOutput("systemctl", "is-active", s.unitName())
-	if exitCode == 0 && err != nil {
-		return StatusUnknown, err
-	}
-
-	switch {
-	case strings.HasPrefix(out, "active"):
-		return StatusRunning, nil
-	case strings.HasPrefix(out, "inactive"):
-		// inactive can also mean its not installed, check unit files
-		exitCode, out, err := s.runWithOutput("systemctl", "list-unit-files", "-t", "service", s.unitName())
-		if exitCode == 0 && err != nil {
-			return StatusUnknown, err
-		}
-		if strings.Contains(out, s.Name) {
-			// unit file exists, installed but not running
-			return StatusStopped, nil
-		}
-		// no unit file
-		return StatusUnknown, ErrNotInstalled
-	case strings.HasPrefix(out, "activating"):
-		return StatusRunning, nil
-	case strings.HasPrefix(out, "failed"):
-		return StatusUnknown, errors.New("service in failed state")
-	default:
-		return StatusUnknown, ErrNotInstalled
-	}
+	t := s.transport()
+	defer t.close()
+	return t.unitStatus(s.unitName())
 }
 
 func (s *systemd) Start() error {
-	return s.runAction("start")
+	t := s.transport()
+	defer t.close()
+	return t.startUnit(s.unitName())
 }
 
 func (s *systemd) Stop() error {
-	return s.runAction("stop")
+	t := s.transport()
+	defer t.close()
+	return t.stopUnit(s.unitName())
 }
 
 func (s *systemd) Restart() error {
-	return s.runAction("restart")
+	t := s.transport()
+	defer t.close()
+	return t.restartUnit(s.unitName())
 }
 
-func (s *systemd) runWithOutput(command string, arguments ...string) (int, string, error) {
-	if s.isUserService() {
-		arguments = append(arguments, "--user")
+func (s *systemd) ReloadOrRestart() error {
+	t := s.transport()
+	defer t.close()
+	return t.reloadOrRestartUnit(s.unitName())
+}
+
+// daemonReload reloads the service manager after unit files change.
+func (s *systemd) daemonReload() error {
+	t := s.transport()
+	defer t.close()
+	return t.daemonReload()
+}
+
+// systemctlArgs prefixes args with the flags that select the service manager.
+func (s *systemd) systemctlArgs(args ...string) []string {
+	switch {
+	case !s.isUserService():
+		return args
+	case s.userServiceFor() != "":
+		return append([]string{"--user", "--machine=" + s.userServiceFor() + "@"}, args...)
+	default:
+		return append([]string{"--user"}, args...)
 	}
-	return runWithOutput(command, arguments...)
 }
 
-func (s *systemd) run(action string, args ...string) error {
-	if s.isUserService() {
-		return run("systemctl", append([]string{action, "--user"}, args...)...)
+// userEnv returns the environment systemctl needs to reach the user manager
+// of the current user.
+func (s *systemd) userEnv() []string {
+	if !s.isUserService() || s.userServiceFor() != "" {
+		return nil
+	}
+	return userManagerEnv(os.Getenv, os.Getuid())
+}
+
+// userManagerEnv returns XDG_RUNTIME_DIR and DBUS_SESSION_BUS_ADDRESS for
+// uid when they are missing from the environment, which is common after
+// su, sudo or in a cron job.
+func userManagerEnv(getenv func(string) string, uid int) []string {
+	var env []string
+	runtimeDir := getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = "/run/user/" + strconv.Itoa(uid)
+		env = append(env, "XDG_RUNTIME_DIR="+runtimeDir)
 	}
-	return run("systemctl", append([]string{action}, args...)...)
+	if getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		env = append(env, "DBUS_SESSION_BUS_ADDRESS=unix:path="+runtimeDir+"/bus")
+	}
+	return env
+}
+
+func (s *systemd) runWithOutput(command string, arguments ...string) (int, string, error) {
+	return runWithOutputEnv(s.userEnv(), command, s.systemctlArgs(arguments...)...)
 }
 
-func (s *systemd) runAction(action string) error {
-	return s.run(action, s.unitName())
+func (s *systemd) run(action string, args ...string) error {
+	_, _, err := runCommandEnv(s.userEnv(), "systemctl", false, s.systemctlArgs(append([]string{action}, args...)...)...)
+	return err
 }
 
 const systemdScript = `[Unit]
-Description={{.Description}}
-ConditionFileIsExecutable={{.Path|cmdEscape}}
+Description={{.Description|systemdText}}
+ConditionFileIsExecutable={{.Path|systemdText}}
 {{range $i, $dep := .Dependencies}} 
 {{$dep}} {{end}}
 
 [Service]
 StartLimitInterval=5
 StartLimitBurst=10
-ExecStart={{.Path|cmdEscape}}{{range .Arguments}} {{.|cmd}}{{end}}
-{{if .ChRoot}}RootDirectory={{.ChRoot|cmd}}{{end}}
-{{if .WorkingDirectory}}WorkingDirectory={{.WorkingDirectory|cmdEscape}}{{end}}
-{{if .UserName}}User={{.UserName}}{{end}}
+ExecStart={{.Path|systemdExec}}{{range .Arguments}} {{.|systemdExec}}{{end}}
+{{if .ChRoot}}RootDirectory={{.ChRoot|systemdText}}{{end}}
+{{if .WorkingDirectory}}WorkingDirectory={{.WorkingDirectory|systemdText}}{{end}}
+{{if and .UserName (not .UserService)}}User={{.UserName|systemdText}}{{end}}
 {{if .ReloadSignal}}ExecReload=/bin/kill -{{.ReloadSignal}} "$MAINPID"{{end}}
-{{if .PIDFile}}PIDFile={{.PIDFile|cmd}}{{end}}
+{{if .PIDFile}}PIDFile={{.PIDFile|systemdText}}{{end}}
 {{if and .LogOutput .HasOutputFileSupport -}}
-StandardOutput=file:{{.LogDirectory}}/{{.Name}}.out
-StandardError=file:{{.LogDirectory}}/{{.Name}}.err
+StandardOutput=file:{{.LogDirectory|systemdText}}/{{.Name}}.out
+StandardError=file:{{.LogDirectory|systemdText}}/{{.Name}}.err
 {{- end}}
 {{if gt .LimitNOFILE -1 }}LimitNOFILE={{.LimitNOFILE}}{{end}}
 {{if .Restart}}Restart={{.Restart}}{{end}}
 {{if .SuccessExitStatus}}SuccessExitStatus={{.SuccessExitStatus}}{{end}}
 RestartSec=120
-EnvironmentFile=-/etc/sysconfig/{{.Name}}
+EnvironmentFile=-{{.EnvFile|systemdText}}
 
 {{range $k, $v := .EnvVars -}}
-Environment={{$k}}={{$v}}
+Environment={{systemdEnv $k $v}}
 {{end -}}
+{{range .Credentials -}}
+{{if not .Path}}SetCredential={{.Name}}:{{.Value|credEscape}}
+{{else if .Encrypted}}LoadCredentialEncrypted={{.Name}}:{{.Path|systemdText}}
+{{else}}LoadCredential={{.Name}}:{{.Path|systemdText}}
+{{end}}
+{{- end}}
 
 [Install]
-WantedBy=multi-user.target
+WantedBy={{.WantedBy}}
 `
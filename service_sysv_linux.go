@@ -21,6 +21,12 @@ type sysv struct {
 	*Config
 }
 
+var sysvSupport = systemSupport{
+	name:    unixServiceName,
+	fields:  []string{"WorkingDirectory", "EnvVars", "Credentials"},
+	options: joinOptions([]string{optionSysvScript, optionLogDirectory, optionEnvFile, optionLogRotate, optionRunWait}, logOptions, unixLogOptions),
+}
+
 func newSystemVService(i Interface, platform string, c *Config) (Service, error) {
 	s := &sysv{
 		i:        i,
@@ -42,6 +48,14 @@ func (s *sysv) Platform() string {
 	return s.platform
 }
 
+func (s *sysv) validate() error {
+	return validateConfig(s.Config, s.platform, &sysvSupport)
+}
+
+func (s *sysv) warnings() []FieldError {
+	return configWarnings(s.Config, s.platform, &sysvSupport)
+}
+
 var errNoUserServiceSystemV = errors.New("User services are not supported on SystemV.")
 
 func (s *sysv) configPath() (cp string, err error) {
@@ -53,6 +67,10 @@ func (s *sysv) configPath() (cp string, err error) {
 	return
 }
 
+func (s *sysv) envFile() (string, error) {
+	return envFilePath(s.Config), nil
+}
+
 func (s *sysv) template() *template.Template {
 	customScript := s.Option.string(optionSysvScript, "")
 
@@ -63,6 +81,9 @@ func (s *sysv) template() *template.Template {
 }
 
 func (s *sysv) Install() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
 	confPath, err := s.configPath()
 	if err != nil {
 		return err
@@ -72,12 +93,6 @@ func (s *sysv) Install() error {
 		return fmt.Errorf("Init already exists: %s", confPath)
 	}
 
-	f, err := os.Create(confPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	path, err := s.execPath()
 	if err != nil {
 		return err
@@ -87,28 +102,48 @@ func (s *sysv) Install() error {
 		*Config
 		Path         string
 		LogDirectory string
+		EnvFile      string
+
+		CredentialsDirectory string
 	}{
 		s.Config,
 		path,
 		s.Option.string(optionLogDirectory, defaultLogDirectory),
+		envFilePath(s.Config),
+		credentialsDirectory(s.Config),
 	}
 
-	err = s.template().Execute(f, to)
+	b, err := renderStamped(s.template(), to, markerHash, s.Config)
 	if err != nil {
 		return err
 	}
 
-	if err = os.Chmod(confPath, 0755); err != nil {
+	tx := &installTx{}
+	if err = tx.writeCredentials(s.Config); err != nil {
 		return err
 	}
+	if err = tx.writeFile(confPath, b, 0755); err != nil {
+		return err
+	}
+	if err = tx.writeLogrotate(s.Config, outputFiles(s.Config, ".log", ".err"), ""); err != nil {
+		return err
+	}
+	link := func(level, name string) error {
+		dir := "/etc/rc" + level + ".d"
+		// Not every distribution has every run level directory.
+		if _, err := os.Stat(dir); err != nil {
+			return nil
+		}
+		return tx.symlink(confPath, dir+"/"+name)
+	}
 	for _, i := range [...]string{"2", "3", "4", "5"} {
-		if err = os.Symlink(confPath, "/etc/rc"+i+".d/S50"+s.Name); err != nil {
-			continue
+		if err = link(i, "S50"+s.Name); err != nil {
+			return err
 		}
 	}
 	for _, i := range [...]string{"0", "1", "6"} {
-		if err = os.Symlink(confPath, "/etc/rc"+i+".d/K02"+s.Name); err != nil {
-			continue
+		if err = link(i, "K02"+s.Name); err != nil {
+			return err
 		}
 	}
 
@@ -116,27 +151,56 @@ func (s *sysv) Install() error {
 }
 
 func (s *sysv) Uninstall() error {
+	_, err := UninstallWithOptions(s, UninstallOptions{})
+	return err
+}
+
+func (s *sysv) uninstall(opts UninstallOptions, r *UninstallReport) error {
 	cp, err := s.configPath()
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(cp); err != nil {
+	if err := r.removeInstalled(cp); err != nil {
 		return err
 	}
-	return nil
+	for _, i := range [...]string{"2", "3", "4", "5"} {
+		if err := r.remove("/etc/rc" + i + ".d/S50" + s.Name); err != nil {
+			return err
+		}
+	}
+	for _, i := range [...]string{"0", "1", "6"} {
+		if err := r.remove("/etc/rc" + i + ".d/K02" + s.Name); err != nil {
+			return err
+		}
+	}
+	if err := r.remove("/var/run/" + s.Name + ".pid"); err != nil {
+		return err
+	}
+	if err := r.removeCredentials(s.Config); err != nil {
+		return err
+	}
+	if err := r.removeLogrotate(s.Config); err != nil {
+		return err
+	}
+	logDir := s.Option.string(optionLogDirectory, defaultLogDirectory)
+	return r.purge(opts, s.Config,
+		logDir+"/"+s.Name+".log",
+		logDir+"/"+s.Name+".err",
+		envFilePath(s.Config),
+	)
 }
 
 func (s *sysv) Logger(errs chan<- error) (Logger, error) {
-	if system.Interactive() {
-		return ConsoleLogger, nil
-	}
-	return s.SystemLogger(errs)
+	return selectLogger(s, s.Config, system.Interactive(), errs)
 }
 func (s *sysv) SystemLogger(errs chan<- error) (Logger, error) {
-	return newSysLogger(s.Name, errs)
+	return newLogger(s.Config, errs)
 }
 
 func (s *sysv) Run() (err error) {
+	defer flushLoggers()
+	defer redirectOutput(s, s.Config, system.Interactive())()
+
 	err = s.i.Start(s)
 	if err != nil {
 		return err
@@ -187,32 +251,37 @@ func (s *sysv) Restart() error {
 const sysvScript = `#!/bin/sh
 # For RedHat and cousins:
 # chkconfig: - 99 01
-# description: {{.Description}}
-# processname: {{.Path}}
+# description: {{.Description|comment}}
+# processname: {{.Path|comment}}
 
 ### BEGIN INIT INFO
-# Provides:          {{.Path}}
+# Provides:          {{.Path|comment}}
 # Required-Start:
 # Required-Stop:
 # Default-Start:     2 3 4 5
 # Default-Stop:      0 1 6
-# Short-Description: {{.DisplayName}}
-# Description:       {{.Description}}
+# Short-Description: {{.DisplayName|comment}}
+# Description:       {{.Description|comment}}
 ### END INIT INFO
 
-cmd="{{.Path}}{{range .Arguments}} {{.|cmd}}{{end}}"
+cmd={{shCommand .Path .Arguments|sh}}
 
 name=$(basename $(readlink -f $0))
 pid_file="/var/run/$name.pid"
-stdout_log="{{.LogDirectory}}/$name.log"
-stderr_log="{{.LogDirectory}}/$name.err"
+stdout_log={{.LogDirectory|sh}}"/$name.log"
+stderr_log={{.LogDirectory|sh}}"/$name.err"
 
 {{range $k, $v := .EnvVars -}}
-export {{$k}}={{$v}}
+export {{$k}}={{$v|sh}}
 {{end -}}
 
-[ -e /etc/sysconfig/$name ] && . /etc/sysconfig/$name
-
+if [ -e {{.EnvFile|sh}} ]; then
+    set -a
+    . {{.EnvFile|sh}}
+    set +a
+fi
+{{if .CredentialsDirectory}}export CREDENTIALS_DIRECTORY={{.CredentialsDirectory|sh}}
+{{end}}
 get_pid() {
     cat "$pid_file"
 }
@@ -227,8 +296,8 @@ case "$1" in
             echo "Already started"
         else
             echo "Starting $name"
-            {{if .WorkingDirectory}}cd '{{.WorkingDirectory}}'{{end}}
-            $cmd >> "$stdout_log" 2>> "$stderr_log" &
+            {{if .WorkingDirectory}}cd {{.WorkingDirectory|sh}}{{end}}
+            eval "exec $cmd" >> "$stdout_log" 2>> "$stderr_log" &
             echo $! > "$pid_file"
             if ! is_running; then
                 echo "Unable to start, see $stdout_log and $stderr_log"
@@ -13,23 +13,90 @@ import (
 	"io"
 	"io/ioutil"
 	"log/syslog"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"syscall"
+	"time"
 )
 
 const defaultLogDirectory = "/var/log"
 
-func newSysLogger(name string, errs chan<- error) (Logger, error) {
+// newLogger returns the system logger of c, a FileLogger with the LogFile
+// option or a SyslogLogger with SyslogAddress, at the level of configLevel.
+func newLogger(c *Config, errs chan<- error) (Logger, error) {
+	if c.Option.bool(optionLogFile, optionLogFileDefault) {
+		l, err := newConfigFileLogger(c, errs)
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+	if c.Option.string(optionSyslogAddress, "") != "" {
+		l, err := newConfigSyslogLogger(c, errs)
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+	return newSysLogger(c.Name, configLevel(c), errs)
+}
+
+// newConfigSyslogLogger returns the SyslogLogger of the SyslogAddress of c,
+// sending as the service name.
+func newConfigSyslogLogger(c *Config, errs chan<- error) (*SyslogLogger, error) {
+	network, address, err := parseSyslogAddress(c.Option.string(optionSyslogAddress, ""))
+	if err != nil {
+		return nil, err
+	}
+	format, err := parseSyslogFormat(c.Option.string(optionSyslogFormat, ""))
+	if err != nil {
+		return nil, err
+	}
+	return NewSyslogLogger(SyslogConfig{
+		Network:  network,
+		Address:  address,
+		Format:   format,
+		Facility: c.Option.string(optionSyslogFacility, ""),
+		AppName:  c.Name,
+		Level:    configLevel(c),
+	}, errs)
+}
+
+// newConfigFileLogger returns the FileLogger of c, writing to
+// LogDirectory/<name>.log.
+func newConfigFileLogger(c *Config, errs chan<- error) (*FileLogger, error) {
+	var maxAge time.Duration
+	if s := c.Option.string(optionLogMaxAge, ""); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", optionLogMaxAge, err)
+		}
+		maxAge = d
+	}
+	return NewFileLogger(FileLoggerConfig{
+		Path:       filepath.Join(c.Option.string(optionLogDirectory, defaultLogDirectory), c.Name+".log"),
+		MaxSize:    int64(c.Option.int(optionLogMaxSize, optionLogMaxSizeDefault)),
+		MaxAge:     maxAge,
+		MaxBackups: c.Option.int(optionLogMaxBackups, optionLogMaxBackupsDefault),
+		Compress:   c.Option.bool(optionLogCompress, optionLogCompressDefault),
+		Level:      configLevel(c),
+	}, errs)
+}
+
+func newSysLogger(name string, level Level, errs chan<- error) (Logger, error) {
 	w, err := syslog.New(syslog.LOG_INFO, name)
 	if err != nil {
 		return nil, err
 	}
-	return sysLogger{w, errs}, nil
+	return sysLogger{w, errs, newLevelVar(level)}, nil
 }
 
 type sysLogger struct {
 	*syslog.Writer
 	errs chan<- error
+	*levelVar
 }
 
 func (s sysLogger) send(err error) error {
@@ -39,23 +106,36 @@ func (s sysLogger) send(err error) error {
 	return err
 }
 
+func (s sysLogger) write(level Level, write func(string) error, msg string) error {
+	if !s.enabled(level) {
+		return nil
+	}
+	return s.send(write(msg))
+}
+
 func (s sysLogger) Error(v ...interface{}) error {
-	return s.send(s.Writer.Err(fmt.Sprint(v...)))
+	return s.write(LevelError, s.Writer.Err, fmt.Sprint(v...))
 }
 func (s sysLogger) Warning(v ...interface{}) error {
-	return s.send(s.Writer.Warning(fmt.Sprint(v...)))
+	return s.write(LevelWarning, s.Writer.Warning, fmt.Sprint(v...))
 }
 func (s sysLogger) Info(v ...interface{}) error {
-	return s.send(s.Writer.Info(fmt.Sprint(v...)))
+	return s.write(LevelInfo, s.Writer.Info, fmt.Sprint(v...))
+}
+func (s sysLogger) Debug(v ...interface{}) error {
+	return s.write(LevelDebug, s.Writer.Debug, fmt.Sprint(v...))
 }
 func (s sysLogger) Errorf(format string, a ...interface{}) error {
-	return s.send(s.Writer.Err(fmt.Sprintf(format, a...)))
+	return s.write(LevelError, s.Writer.Err, fmt.Sprintf(format, a...))
 }
 func (s sysLogger) Warningf(format string, a ...interface{}) error {
-	return s.send(s.Writer.Warning(fmt.Sprintf(format, a...)))
+	return s.write(LevelWarning, s.Writer.Warning, fmt.Sprintf(format, a...))
 }
 func (s sysLogger) Infof(format string, a ...interface{}) error {
-	return s.send(s.Writer.Info(fmt.Sprintf(format, a...)))
+	return s.write(LevelInfo, s.Writer.Info, fmt.Sprintf(format, a...))
+}
+func (s sysLogger) Debugf(format string, a ...interface{}) error {
+	return s.write(LevelDebug, s.Writer.Debug, fmt.Sprintf(format, a...))
 }
 
 func run(command string, arguments ...string) error {
@@ -67,8 +147,21 @@ func runWithOutput(command string, arguments ...string) (int, string, error) {
 	return runCommand(command, true, arguments...)
 }
 
+// runWithOutputEnv is like runWithOutput but adds env to the environment of
+// the command.
+func runWithOutputEnv(env []string, command string, arguments ...string) (int, string, error) {
+	return runCommandEnv(env, command, true, arguments...)
+}
+
 func runCommand(command string, readStdout bool, arguments ...string) (int, string, error) {
+	return runCommandEnv(nil, command, readStdout, arguments...)
+}
+
+func runCommandEnv(env []string, command string, readStdout bool, arguments ...string) (int, string, error) {
 	cmd := exec.Command(command, arguments...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	var output string
 	var stdout io.ReadCloser
@@ -140,3 +233,16 @@ func isExitError(err error) (int, bool) {
 
 	return 0, false
 }
+
+// removeUser deletes the named account.
+func removeUser(name string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return run("dscl", ".", "-delete", "/Users/"+name)
+	case "freebsd":
+		return run("pw", "userdel", "-n", name)
+	case "aix":
+		return run("rmuser", "-p", name)
+	}
+	return run("userdel", name)
+}
@@ -35,6 +35,12 @@ type upstart struct {
 	*Config
 }
 
+var upstartSupport = systemSupport{
+	name:    unixServiceName,
+	fields:  []string{"UserName", "WorkingDirectory", "ChRoot", "Credentials"},
+	options: joinOptions([]string{optionUpstartScript, optionLogOutput, optionLogDirectory, optionEnvFile, optionLogRotate, optionRunWait}, logOptions, unixLogOptions),
+}
+
 func newUpstartService(i Interface, platform string, c *Config) (Service, error) {
 	s := &upstart{
 		i:        i,
@@ -56,6 +62,14 @@ func (s *upstart) Platform() string {
 	return s.platform
 }
 
+func (s *upstart) validate() error {
+	return validateConfig(s.Config, s.platform, &upstartSupport)
+}
+
+func (s *upstart) warnings() []FieldError {
+	return configWarnings(s.Config, s.platform, &upstartSupport)
+}
+
 // Upstart has some support for user services in graphical sessions.
 // Due to the mix of actual support for user services over versions, just don't bother.
 // Upstart will be replaced by systemd in most cases anyway.
@@ -70,6 +84,14 @@ func (s *upstart) configPath() (cp string, err error) {
 	return
 }
 
+func (s *upstart) envFile() (string, error) {
+	return envFilePath(s.Config), nil
+}
+
+func upstartListDirs() []string {
+	return []string{"/etc/init"}
+}
+
 func (s *upstart) hasKillStanza() bool {
 	defaultValue := true
 	version := s.getUpstartVersion()
@@ -126,6 +148,9 @@ func (s *upstart) template() *template.Template {
 }
 
 func (s *upstart) Install() error {
+	if err := s.validate(); err != nil {
+		return err
+	}
 	confPath, err := s.configPath()
 	if err != nil {
 		return err
@@ -135,12 +160,6 @@ func (s *upstart) Install() error {
 		return fmt.Errorf("Init already exists: %s", confPath)
 	}
 
-	f, err := os.Create(confPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	path, err := s.execPath()
 	if err != nil {
 		return err
@@ -153,6 +172,9 @@ func (s *upstart) Install() error {
 		HasSetUIDStanza bool
 		LogOutput       bool
 		LogDirectory    string
+		EnvFile         string
+
+		CredentialsDirectory string
 	}{
 		s.Config,
 		path,
@@ -160,33 +182,66 @@ func (s *upstart) Install() error {
 		s.hasSetUIDStanza(),
 		s.Option.bool(optionLogOutput, optionLogOutputDefault),
 		s.Option.string(optionLogDirectory, defaultLogDirectory),
+		envFilePath(s.Config),
+		credentialsDirectory(s.Config),
 	}
 
-	return s.template().Execute(f, to)
+	b, err := renderStamped(s.template(), to, markerHash, s.Config)
+	if err != nil {
+		return err
+	}
+
+	tx := &installTx{}
+	if err = tx.writeCredentials(s.Config); err != nil {
+		return err
+	}
+	if err = tx.writeFile(confPath, b, 0644); err != nil {
+		return err
+	}
+	if !to.LogOutput {
+		return nil
+	}
+	return tx.writeLogrotate(s.Config, outputFiles(s.Config, ".out", ".err"), "")
 }
 
 func (s *upstart) Uninstall() error {
+	_, err := UninstallWithOptions(s, UninstallOptions{})
+	return err
+}
+
+func (s *upstart) uninstall(opts UninstallOptions, r *UninstallReport) error {
 	cp, err := s.configPath()
 	if err != nil {
 		return err
 	}
-	if err := os.Remove(cp); err != nil {
+	if err := r.removeInstalled(cp); err != nil {
+		return err
+	}
+	if err := r.removeCredentials(s.Config); err != nil {
 		return err
 	}
-	return nil
+	if err := r.removeLogrotate(s.Config); err != nil {
+		return err
+	}
+	logDir := s.Option.string(optionLogDirectory, defaultLogDirectory)
+	return r.purge(opts, s.Config,
+		logDir+"/"+s.Name+".out",
+		logDir+"/"+s.Name+".err",
+		envFilePath(s.Config),
+	)
 }
 
 func (s *upstart) Logger(errs chan<- error) (Logger, error) {
-	if system.Interactive() {
-		return ConsoleLogger, nil
-	}
-	return s.SystemLogger(errs)
+	return selectLogger(s, s.Config, system.Interactive(), errs)
 }
 func (s *upstart) SystemLogger(errs chan<- error) (Logger, error) {
-	return newSysLogger(s.Name, errs)
+	return newLogger(s.Config, errs)
 }
 
 func (s *upstart) Run() (err error) {
+	defer flushLoggers()
+	defer redirectOutput(s, s.Config, system.Interactive())()
+
 	err = s.i.Start(s)
 	if err != nil {
 		return err
@@ -236,8 +291,8 @@ const upstartScript = `# {{.Description}}
 {{if .DisplayName}}description    "{{.DisplayName}}"{{end}}
 
 {{if .HasKillStanza}}kill signal INT{{end}}
-{{if .ChRoot}}chroot {{.ChRoot}}{{end}}
-{{if .WorkingDirectory}}chdir {{.WorkingDirectory}}{{end}}
+{{if .ChRoot}}chroot {{.ChRoot|sh}}{{end}}
+{{if .WorkingDirectory}}chdir {{.WorkingDirectory|sh}}{{end}}
 start on filesystem or runlevel [2345]
 stop on runlevel [!2345]
 
@@ -250,22 +305,23 @@ umask 022
 console none
 
 pre-start script
-    test -x {{.Path}} || { stop; exit 0; }
+    test -x {{.Path|sh}} || { stop; exit 0; }
 end script
 
 # Start
 script
 	{{if .LogOutput}}
-	stdout_log="{{.LogDirectory}}/{{.Name}}.out"
-	stderr_log="{{.LogDirectory}}/{{.Name}}.err"
+	stdout_log={{printf "%s/%s.out" .LogDirectory .Name|sh}}
+	stderr_log={{printf "%s/%s.err" .LogDirectory .Name|sh}}
 	{{end}}
 	
-	if [ -f "/etc/sysconfig/{{.Name}}" ]; then
+	if [ -f {{.EnvFile|sh}} ]; then
 		set -a
-		source /etc/sysconfig/{{.Name}}
+		. {{.EnvFile|sh}}
 		set +a
 	fi
+	{{if .CredentialsDirectory}}export CREDENTIALS_DIRECTORY={{.CredentialsDirectory|sh}}{{end}}
 
-	exec {{if and .UserName (not .HasSetUIDStanza)}}sudo -E -u {{.UserName}} {{end}}{{.Path}}{{range .Arguments}} {{.|cmd}}{{end}}{{if .LogOutput}} >> $stdout_log 2>> $stderr_log{{end}}
+	exec {{if and .UserName (not .HasSetUIDStanza)}}sudo -E -u {{.UserName|sh}} {{end}}{{shCommand .Path .Arguments}}{{if .LogOutput}} >> "$stdout_log" 2>> "$stderr_log"{{end}}
 end script
 `
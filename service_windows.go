@@ -47,14 +47,64 @@ type windowsService struct {
 	stopStartErr error
 }
 
-// WindowsLogger allows using windows specific logging methods.
+// WindowsLogger allows using windows specific logging methods. The event log
+// has no debug type, so debug messages are logged as information.
 type WindowsLogger struct {
 	ev   *eventlog.Log
 	errs chan<- error
+	*levelVar
 }
 
 type windowsSystem struct{}
 
+var windowsSupport = systemSupport{
+	name:    windowsServiceName,
+	fields:  []string{"UserName", "Dependencies", "EnvVars", "Credentials"},
+	options: joinOptions([]string{StartType, "Interactive", "Password", "DelayedAutoStart", OnFailure, OnFailureDelayDuration, OnFailureResetPeriod}, logOptions),
+	check:   checkWindows,
+}
+
+func (windowsSystem) support() systemSupport {
+	return windowsSupport
+}
+
+// windowsServiceName checks a name for the service control manager, which
+// takes up to 256 characters other than / and \.
+func windowsServiceName(name string) string {
+	if len(name) > 256 {
+		return "is longer than 256 characters"
+	}
+	for _, c := range name {
+		if c == '/' || c == '\\' || c < 0x20 || c == 0x7f {
+			return `must not hold /, \ or control characters`
+		}
+	}
+	return ""
+}
+
+// checkWindows checks the values of the service options.
+func checkWindows(c *Config, e *ValidationError) {
+	field := func(k string) string { return fmt.Sprintf("Option[%q]", k) }
+	switch v := c.Option.string(StartType, ServiceStartAutomatic); v {
+	case ServiceStartAutomatic, ServiceStartManual, ServiceStartDisabled:
+	default:
+		e.add(field(StartType), "%q is not one of %s, %s, %s", v, ServiceStartAutomatic, ServiceStartManual, ServiceStartDisabled)
+	}
+	switch v := c.Option.string(OnFailure, ""); v {
+	case "", OnFailureRestart, OnFailureReboot, OnFailureNoAction:
+	default:
+		e.add(field(OnFailure), "%q is not one of %s, %s, %s", v, OnFailureRestart, OnFailureReboot, OnFailureNoAction)
+	}
+	if v := c.Option.string(OnFailureDelayDuration, ""); v != "" {
+		if _, err := time.ParseDuration(v); err != nil {
+			e.add(field(OnFailureDelayDuration), "%v", err)
+		}
+	}
+	if v := c.Option.int(OnFailureResetPeriod, 10); v < 0 {
+		e.add(field(OnFailureResetPeriod), "%d is negative", v)
+	}
+}
+
 func (windowsSystem) String() string {
 	return version
 }
@@ -67,7 +117,7 @@ func (windowsSystem) Interactive() bool {
 func (windowsSystem) New(i Interface, c *Config) (Service, error) {
 	ws := &windowsService{
 		i:      i,
-		Config: c,
+		Config: withOptions(c),
 	}
 	return ws, nil
 }
@@ -86,74 +136,96 @@ func (l WindowsLogger) send(err error) error {
 	return err
 }
 
+func (l WindowsLogger) write(level Level, write func(uint32, string) error, eventID uint32, msg string) error {
+	if !l.enabled(level) {
+		return nil
+	}
+	return l.send(write(eventID, msg))
+}
+
 // Error logs an error message.
 func (l WindowsLogger) Error(v ...interface{}) error {
-	return l.send(l.ev.Error(3, fmt.Sprint(v...)))
+	return l.write(LevelError, l.ev.Error, 3, fmt.Sprint(v...))
 }
 
 // Warning logs an warning message.
 func (l WindowsLogger) Warning(v ...interface{}) error {
-	return l.send(l.ev.Warning(2, fmt.Sprint(v...)))
+	return l.write(LevelWarning, l.ev.Warning, 2, fmt.Sprint(v...))
 }
 
 // Info logs an info message.
 func (l WindowsLogger) Info(v ...interface{}) error {
-	return l.send(l.ev.Info(1, fmt.Sprint(v...)))
+	return l.write(LevelInfo, l.ev.Info, 1, fmt.Sprint(v...))
+}
+
+// Debug logs a debug message.
+func (l WindowsLogger) Debug(v ...interface{}) error {
+	return l.write(LevelDebug, l.ev.Info, 1, fmt.Sprint(v...))
 }
 
 // Errorf logs an error message.
 func (l WindowsLogger) Errorf(format string, a ...interface{}) error {
-	return l.send(l.ev.Error(3, fmt.Sprintf(format, a...)))
+	return l.write(LevelError, l.ev.Error, 3, fmt.Sprintf(format, a...))
 }
 
 // Warningf logs an warning message.
 func (l WindowsLogger) Warningf(format string, a ...interface{}) error {
-	return l.send(l.ev.Warning(2, fmt.Sprintf(format, a...)))
+	return l.write(LevelWarning, l.ev.Warning, 2, fmt.Sprintf(format, a...))
 }
 
 // Infof logs an info message.
 func (l WindowsLogger) Infof(format string, a ...interface{}) error {
-	return l.send(l.ev.Info(1, fmt.Sprintf(format, a...)))
+	return l.write(LevelInfo, l.ev.Info, 1, fmt.Sprintf(format, a...))
+}
+
+// Debugf logs a debug message.
+func (l WindowsLogger) Debugf(format string, a ...interface{}) error {
+	return l.write(LevelDebug, l.ev.Info, 1, fmt.Sprintf(format, a...))
 }
 
 // NError logs an error message and an event ID.
 func (l WindowsLogger) NError(eventID uint32, v ...interface{}) error {
-	return l.send(l.ev.Error(eventID, fmt.Sprint(v...)))
+	return l.write(LevelError, l.ev.Error, eventID, fmt.Sprint(v...))
 }
 
 // NWarning logs an warning message and an event ID.
 func (l WindowsLogger) NWarning(eventID uint32, v ...interface{}) error {
-	return l.send(l.ev.Warning(eventID, fmt.Sprint(v...)))
+	return l.write(LevelWarning, l.ev.Warning, eventID, fmt.Sprint(v...))
 }
 
 // NInfo logs an info message and an event ID.
 func (l WindowsLogger) NInfo(eventID uint32, v ...interface{}) error {
-	return l.send(l.ev.Info(eventID, fmt.Sprint(v...)))
+	return l.write(LevelInfo, l.ev.Info, eventID, fmt.Sprint(v...))
 }
 
 // NErrorf logs an error message and an event ID.
 func (l WindowsLogger) NErrorf(eventID uint32, format string, a ...interface{}) error {
-	return l.send(l.ev.Error(eventID, fmt.Sprintf(format, a...)))
+	return l.write(LevelError, l.ev.Error, eventID, fmt.Sprintf(format, a...))
 }
 
 // NWarningf logs an warning message and an event ID.
 func (l WindowsLogger) NWarningf(eventID uint32, format string, a ...interface{}) error {
-	return l.send(l.ev.Warning(eventID, fmt.Sprintf(format, a...)))
+	return l.write(LevelWarning, l.ev.Warning, eventID, fmt.Sprintf(format, a...))
 }
 
 // NInfof logs an info message and an event ID.
 func (l WindowsLogger) NInfof(eventID uint32, format string, a ...interface{}) error {
-	return l.send(l.ev.Info(eventID, fmt.Sprintf(format, a...)))
+	return l.write(LevelInfo, l.ev.Info, eventID, fmt.Sprintf(format, a...))
 }
 
 var interactive = false
 
 func init() {
-	isService, err := svc.IsWindowsService()
-	if err != nil {
-		panic(err)
+	interactive = DetectContext().Interactive()
+}
+
+// detectSupervisor sets the supervisor if the process is a Windows service.
+// It is left empty if that cannot be told.
+func detectSupervisor(c *RunContext) {
+	if isService, err := svc.IsWindowsService(); err == nil && isService {
+		c.Parent = "services.exe"
+		c.Supervisor = "windows-service"
 	}
-	interactive = !isService
 }
 
 func (ws *windowsService) String() string {
@@ -167,6 +239,14 @@ func (ws *windowsService) Platform() string {
 	return version
 }
 
+func (ws *windowsService) validate() error {
+	return ws.Config.Validate(windowsSystem{})
+}
+
+func (ws *windowsService) warnings() []FieldError {
+	return ws.Config.Warnings(windowsSystem{})
+}
+
 func (ws *windowsService) setError(err error) {
 	ws.errSync.Lock()
 	defer ws.errSync.Unlock()
@@ -240,8 +320,63 @@ func lowPrivSvc(m *mgr.Mgr, name string) (*mgr.Service, error) {
 	return &mgr.Service{Handle: h, Name: name}, nil
 }
 
+// markerValue is the registry value of the service key that marks services
+// installed with this package.
+const markerValue = "KardianosService"
+
+// setMarkerInRegistry records the version of this package and the Config in
+// the service key, so List can find the service.
+func (ws *windowsService) setMarkerInRegistry() error {
+	line, err := markerLine(ws.Config)
+	if err != nil {
+		return err
+	}
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+ws.Name, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed opening service registry key, err = %v", err)
+	}
+	defer k.Close()
+	return k.SetStringValue(markerValue, line)
+}
+
+func (windowsSystem) List() ([]Installed, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer m.Disconnect()
+	names, err := m.ListServices()
+	if err != nil {
+		return nil, err
+	}
+	var list []Installed
+	for _, name := range names {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+name, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		line, _, err := k.GetStringValue(markerValue)
+		k.Close()
+		if err != nil {
+			continue
+		}
+		mk, err := parseMarkerLine(line)
+		if err != nil || mk == nil {
+			continue
+		}
+		list = append(list, Installed{
+			Service: &windowsService{i: nopInterface{}, Config: mk.Config},
+			Config:  mk.Config,
+			Version: mk.Version,
+			Hash:    mk.Hash,
+		})
+	}
+	return list, nil
+}
+
 func (ws *windowsService) setEnvironmentVariablesInRegistry() error {
-	if len(ws.EnvVars) == 0 {
+	credDir := credentialsDirectory(ws.Config)
+	if len(ws.EnvVars) == 0 && credDir == "" {
 		return nil
 	}
 
@@ -255,6 +390,9 @@ func (ws *windowsService) setEnvironmentVariablesInRegistry() error {
 	for k, v := range ws.EnvVars {
 		envStrings = append(envStrings, k+"="+v)
 	}
+	if credDir != "" {
+		envStrings = append(envStrings, "CREDENTIALS_DIRECTORY="+credDir)
+	}
 
 	if err := k.SetStringsValue("Environment", envStrings); err != nil {
 		return fmt.Errorf("failed setting env var registry key, err = %v", err)
@@ -266,6 +404,9 @@ func (ws *windowsService) setEnvironmentVariablesInRegistry() error {
 }
 
 func (ws *windowsService) Install() error {
+	if err := ws.validate(); err != nil {
+		return err
+	}
 	exepath, err := ws.execPath()
 	if err != nil {
 		return err
@@ -277,15 +418,26 @@ func (ws *windowsService) Install() error {
 	}
 	defer m.Disconnect()
 
-	if err := ws.setEnvironmentVariablesInRegistry(); err != nil {
-		return err
-	}
-
 	s, err := m.OpenService(ws.Name)
 	if err == nil {
 		s.Close()
 		return fmt.Errorf("service %s already exists", ws.Name)
 	}
+
+	tx := &installTx{}
+	if err = tx.writeCredentials(ws.Config); err != nil {
+		return err
+	}
+	err = tx.do("set environment", ws.setEnvironmentVariablesInRegistry, func() error {
+		if len(ws.EnvVars) == 0 && len(ws.Credentials) == 0 {
+			return nil
+		}
+		// The key was created for the service, which does not exist yet.
+		return registry.DeleteKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+ws.Name)
+	})
+	if err != nil {
+		return err
+	}
 	var startType int32
 	switch ws.Option.string(StartType, ServiceStartAutomatic) {
 	case ServiceStartAutomatic:
@@ -301,19 +453,26 @@ func (ws *windowsService) Install() error {
 		serviceType = serviceType | windows.SERVICE_INTERACTIVE_PROCESS
 	}
 
-	s, err = m.CreateService(ws.Name, exepath, mgr.Config{
-		DisplayName:      ws.DisplayName,
-		Description:      ws.Description,
-		StartType:        uint32(startType),
-		ServiceStartName: ws.UserName,
-		Password:         ws.Option.string("Password", ""),
-		Dependencies:     ws.Dependencies,
-		DelayedAutoStart: ws.Option.bool("DelayedAutoStart", false),
-		ServiceType:      uint32(serviceType),
-	}, ws.Arguments...)
+	err = tx.do("create service "+ws.Name, func() (err error) {
+		s, err = m.CreateService(ws.Name, exepath, mgr.Config{
+			DisplayName:      ws.DisplayName,
+			Description:      ws.Description,
+			StartType:        uint32(startType),
+			ServiceStartName: ws.UserName,
+			Password:         ws.Option.string("Password", ""),
+			Dependencies:     ws.Dependencies,
+			DelayedAutoStart: ws.Option.bool("DelayedAutoStart", false),
+			ServiceType:      uint32(serviceType),
+		}, ws.Arguments...)
+		return err
+	}, func() error {
+		return s.Delete()
+	})
 	if err != nil {
 		return err
 	}
+	defer s.Close()
+
 	if onFailure := ws.Option.string(OnFailure, ""); onFailure != "" {
 		var delay = 1 * time.Second
 		if d, err := time.ParseDuration(ws.Option.string(OnFailureDelayDuration, "1s")); err == nil {
@@ -330,27 +489,47 @@ func (ws *windowsService) Install() error {
 		default:
 			actionType = mgr.ServiceRestart
 		}
-		if err := s.SetRecoveryActions([]mgr.RecoveryAction{
-			{
-				Type:  actionType,
-				Delay: delay,
-			},
-		}, uint32(ws.Option.int(OnFailureResetPeriod, 10))); err != nil {
+		err = tx.do("set recovery actions", func() error {
+			return s.SetRecoveryActions([]mgr.RecoveryAction{
+				{
+					Type:  actionType,
+					Delay: delay,
+				},
+			}, uint32(ws.Option.int(OnFailureResetPeriod, 10)))
+		}, nil)
+		if err != nil {
 			return err
 		}
 	}
-	defer s.Close()
-	err = eventlog.InstallAsEventCreate(ws.Name, eventlog.Error|eventlog.Warning|eventlog.Info)
-	if err != nil {
-		if !strings.Contains(err.Error(), "exists") {
-			s.Delete()
-			return fmt.Errorf("SetupEventLogSource() failed: %s", err)
+	created := false
+	err = tx.do("set up event log source", func() error {
+		err := eventlog.InstallAsEventCreate(ws.Name, eventlog.Error|eventlog.Warning|eventlog.Info)
+		if err != nil {
+			if !strings.Contains(err.Error(), "exists") {
+				return fmt.Errorf("SetupEventLogSource() failed: %s", err)
+			}
+			return nil
+		}
+		created = true
+		return nil
+	}, func() error {
+		if !created {
+			return nil
 		}
+		return eventlog.Remove(ws.Name)
+	})
+	if err != nil {
+		return err
 	}
-	return nil
+	return tx.do("set marker", ws.setMarkerInRegistry, nil)
 }
 
 func (ws *windowsService) Uninstall() error {
+	_, err := UninstallWithOptions(ws, UninstallOptions{})
+	return err
+}
+
+func (ws *windowsService) uninstall(opts UninstallOptions, r *UninstallReport) error {
 	m, err := mgr.Connect()
 	if err != nil {
 		return err
@@ -369,10 +548,21 @@ func (ws *windowsService) Uninstall() error {
 	if err != nil {
 		return fmt.Errorf("RemoveEventLogSource() failed: %s", err)
 	}
-	return nil
+	if err := r.removeCredentials(ws.Config); err != nil {
+		return err
+	}
+	return r.purge(opts, ws.Config)
+}
+
+// removeUser deletes the named account.
+func removeUser(name string) error {
+	return fmt.Errorf("removing user %s is not supported on windows", name)
 }
 
 func (ws *windowsService) Run() error {
+	defer flushLoggers()
+	defer redirectOutput(ws, ws.Config, interactive)()
+
 	ws.setError(nil)
 	if !interactive {
 		// Return error messages from start and stop routines
@@ -544,15 +734,12 @@ func getStopTimeout() time.Duration {
 }
 
 func (ws *windowsService) Logger(errs chan<- error) (Logger, error) {
-	if interactive {
-		return ConsoleLogger, nil
-	}
-	return ws.SystemLogger(errs)
+	return selectLogger(ws, ws.Config, interactive, errs)
 }
 func (ws *windowsService) SystemLogger(errs chan<- error) (Logger, error) {
 	el, err := eventlog.Open(ws.Name)
 	if err != nil {
 		return nil, err
 	}
-	return WindowsLogger{el, errs}, nil
+	return WindowsLogger{el, errs, newLevelVar(configLevel(ws.Config))}, nil
 }
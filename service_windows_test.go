@@ -5,6 +5,11 @@
 package service
 
 import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -12,3 +17,54 @@ func TestTimeout(t *testing.T) {
 	stopSpan := getStopTimeout()
 	t.Log("Max Stop Duration", stopSpan)
 }
+
+func TestWindowsServiceName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"My Service":             true,
+		"demo (x86)":             true,
+		`a\b`:                    false,
+		"a/b":                    false,
+		"new\nline":              false,
+		strings.Repeat("a", 257): false,
+	} {
+		if got := windowsServiceName(name) == ""; got != want {
+			t.Errorf("windowsServiceName(%q) valid = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestWindowsInstallCredentials(t *testing.T) {
+	root, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(r string) { credentialsRoot = r }(credentialsRoot)
+	credentialsRoot = root
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The service runs as the current user so the test can read the
+	// credential back through the restricted permissions.
+	ws := &windowsService{Config: &Config{
+		Name:        "demo",
+		UserName:    u.Username,
+		Credentials: []CredentialConfig{{Name: "token", Value: "secret"}},
+	}}
+	if err := ws.validate(); err != nil {
+		t.Fatalf("validate() = %v", err)
+	}
+	if w := ws.warnings(); len(w) != 0 {
+		t.Errorf("warnings() = %v, want none", w)
+	}
+	tx := &installTx{}
+	if err := tx.writeCredentials(ws.Config); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(credentialsDirectory(ws.Config), "token"))
+	if err != nil || string(b) != "secret" {
+		t.Errorf("token = %q, %v, want %q", b, err, "secret")
+	}
+}
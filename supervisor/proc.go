@@ -0,0 +1,203 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package supervisor
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// proc runs a Child.
+type proc struct {
+	Child
+	deps   []*proc
+	logger service.Logger
+
+	started  chan struct{} // closed once the child first started or gave up
+	stopping chan struct{} // closed by stop
+	done     chan struct{} // closed when run returns
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd // the running command, or nil
+	state    State
+	since    time.Time
+	restarts int
+	lastErr  error
+}
+
+func (p *proc) init(logger service.Logger) {
+	p.logger = logger
+	p.started = make(chan struct{})
+	p.stopping = make(chan struct{})
+	p.done = make(chan struct{})
+	p.setState(StateStarting)
+}
+
+func (p *proc) setState(s State) {
+	p.mu.Lock()
+	p.state, p.since = s, time.Now()
+	p.mu.Unlock()
+}
+
+func (p *proc) markStarted() {
+	p.startOnce.Do(func() { close(p.started) })
+}
+
+// run waits for the dependencies of the child to start, then runs it until
+// it is stopped or exits without being restarted. It returns the error of
+// the last run.
+func (p *proc) run() (err error) {
+	defer func() {
+		p.markStarted()
+		p.setState(StateExited)
+		close(p.done)
+	}()
+	for _, d := range p.deps {
+		select {
+		case <-d.started:
+		case <-p.stopping:
+			return nil
+		}
+	}
+
+	delay := p.RestartDelay
+	for {
+		started := time.Now()
+		err = p.runOnce()
+		select {
+		case <-p.stopping:
+			return nil
+		default:
+		}
+		p.mu.Lock()
+		p.lastErr = err
+		p.mu.Unlock()
+		if err != nil {
+			p.logger.Warningf("%s exited: %v", p.Name, err)
+		} else {
+			p.logger.Infof("%s exited", p.Name)
+		}
+		if p.Restart == RestartNever || p.Restart == RestartOnFailure && err == nil {
+			return err
+		}
+		if time.Since(started) >= p.MaxRestartDelay {
+			delay = p.RestartDelay
+		}
+		p.setState(StateBackoff)
+		p.logger.Infof("restarting %s in %v", p.Name, delay)
+		select {
+		case <-p.stopping:
+			return nil
+		case <-time.After(delay):
+		}
+		p.mu.Lock()
+		p.restarts++
+		p.mu.Unlock()
+		if delay *= 2; delay > p.MaxRestartDelay {
+			delay = p.MaxRestartDelay
+		}
+	}
+}
+
+// runOnce starts the command of the child and waits for it to exit.
+func (p *proc) runOnce() error {
+	p.setState(StateStarting)
+	cmd, err := p.Command()
+	if err != nil {
+		return err
+	}
+	setProcessGroup(cmd)
+	if cmd.WaitDelay == 0 {
+		cmd.WaitDelay = waitDelay
+	}
+
+	p.mu.Lock()
+	select {
+	case <-p.stopping:
+		p.mu.Unlock()
+		return nil
+	default:
+	}
+	err = cmd.Start()
+	if err == nil {
+		p.cmd = cmd
+		p.state, p.since = StateRunning, time.Now()
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	p.markStarted()
+	p.logger.Infof("started %s, pid %d", p.Name, cmd.Process.Pid)
+
+	err = cmd.Wait()
+	p.mu.Lock()
+	p.cmd = nil
+	p.mu.Unlock()
+	return err
+}
+
+// stop asks the child to exit and not to restart.
+func (p *proc) stop() {
+	p.stopOnce.Do(func() {
+		p.mu.Lock()
+		close(p.stopping)
+		p.mu.Unlock()
+		if err := p.signal(p.StopSignal, stopSignal); err != nil {
+			p.logger.Warningf("stopping %s: %v", p.Name, err)
+		}
+		p.mu.Lock()
+		if p.state != StateExited {
+			p.state, p.since = StateStopping, time.Now()
+		}
+		p.mu.Unlock()
+	})
+}
+
+// signal sends sig, or def if sig is nil, to the process group of the child
+// if it is running.
+func (p *proc) signal(sig, def os.Signal) error {
+	if sig == nil {
+		sig = def
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return nil
+	}
+	return signalGroup(p.cmd.Process, sig)
+}
+
+// kill kills the process group of the child if it is running.
+func (p *proc) kill() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd != nil {
+		killGroup(p.cmd.Process)
+	}
+}
+
+func (p *proc) status() ChildStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := ChildStatus{
+		Name:     p.Name,
+		State:    p.state,
+		Since:    p.since,
+		Restarts: p.restarts,
+		LastErr:  p.lastErr,
+	}
+	if p.cmd != nil {
+		s.Pid = p.cmd.Process.Pid
+	}
+	return s
+}
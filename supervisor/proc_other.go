@@ -0,0 +1,39 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix && !windows
+// +build !unix,!windows
+
+package supervisor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// There is no default reload signal.
+var (
+	stopSignal   os.Signal = os.Interrupt
+	reloadSignal os.Signal
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup sends sig to p only, as there are no process groups.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	if sig == nil {
+		return errors.New("no reload signal on this system")
+	}
+	return p.Signal(sig)
+}
+
+// killGroup kills p.
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+func notifyReload(c chan os.Signal) {}
+
+func stopReload(c chan os.Signal) {}
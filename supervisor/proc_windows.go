@@ -0,0 +1,43 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package supervisor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	stopSignal   os.Signal = os.Interrupt
+	reloadSignal os.Signal = syscall.SIGHUP
+)
+
+// setProcessGroup starts cmd in its own process group, so it can be sent a
+// CTRL_BREAK_EVENT.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: windows.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalGroup sends CTRL_BREAK_EVENT to the process group of p for the stop
+// signal. Other signals are not supported.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	if sig != stopSignal {
+		return errors.New("signals other than the stop signal are not supported on Windows")
+	}
+	return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(p.Pid))
+}
+
+// killGroup kills p.
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+func notifyReload(c chan os.Signal) {}
+
+func stopReload(c chan os.Signal) {}
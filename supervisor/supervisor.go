@@ -0,0 +1,358 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+// Package supervisor runs child processes for a service.
+//
+// A Supervisor is a service.Interface: it starts its children when the
+// service starts and stops them when it stops. Each child runs in its own
+// process group, is restarted with a growing delay when it exits, and is
+// stopped after the children that depend on it. SIGHUP received by the
+// service is forwarded to the children as a reload.
+//
+//	sup, err := supervisor.New(
+//		supervisor.Child{Name: "db", Command: func() (*exec.Cmd, error) {
+//			return exec.Command("/usr/bin/db"), nil
+//		}},
+//		supervisor.Child{Name: "web", DependsOn: []string{"db"}, Command: ...},
+//	)
+//	s, err := service.New(sup, &service.Config{Name: "app"})
+//	err = s.Run()
+package supervisor // import "github.com/kardianos/service/supervisor"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// Policy is when a child is restarted after it exits.
+type Policy int
+
+const (
+	RestartAlways    Policy = iota // Restart whenever the child exits.
+	RestartOnFailure               // Restart when the child exits with an error.
+	RestartNever                   // Never restart the child.
+)
+
+func (p Policy) String() string {
+	switch p {
+	case RestartAlways:
+		return "always"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartNever:
+		return "never"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// Child is a process run by a Supervisor.
+type Child struct {
+	// Name identifies the child in DependsOn, Status and log messages.
+	Name string
+	// Command returns the command to run. It is called before every start
+	// as an exec.Cmd cannot be reused. Its SysProcAttr is replaced to start
+	// it in its own process group.
+	Command func() (*exec.Cmd, error)
+	// DependsOn names the children started before this one and stopped
+	// after it.
+	DependsOn []string
+
+	Restart Policy
+	// RestartDelay is the first wait before a restart, 1s if zero. It doubles
+	// after each restart up to MaxRestartDelay, 1m if zero, and is reset once
+	// the child has run for MaxRestartDelay.
+	RestartDelay    time.Duration
+	MaxRestartDelay time.Duration
+
+	// StopSignal asks the child to exit, SIGTERM if nil. Windows always
+	// sends CTRL_BREAK_EVENT.
+	StopSignal os.Signal
+	// ReloadSignal is sent by Reload, SIGHUP if nil. Not supported on
+	// Windows.
+	ReloadSignal os.Signal
+}
+
+// State of a child.
+type State int
+
+const (
+	StateStarting State = iota // Waiting for its dependencies or starting.
+	StateRunning               // Running.
+	StateBackoff               // Exited and waiting to be restarted.
+	StateStopping              // Asked to exit by Stop.
+	StateExited                // Exited and not restarted.
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateBackoff:
+		return "backoff"
+	case StateStopping:
+		return "stopping"
+	case StateExited:
+		return "exited"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// ChildStatus is the state of a child returned by Status.
+type ChildStatus struct {
+	Name     string
+	State    State
+	Pid      int       // Pid of the running process, or 0.
+	Since    time.Time // When State was entered.
+	Restarts int
+	LastErr  error // Error of the last exit, nil if it succeeded.
+}
+
+// ErrNotStarted is returned by Reload before Start.
+var ErrNotStarted = errors.New("supervisor is not started")
+
+// waitDelay is how long the exit of a child waits for its output to be
+// closed by the processes it started. Those may run in another process group
+// and outlive the child.
+var waitDelay = time.Second
+
+// Supervisor runs children for a service. Create it with New.
+type Supervisor struct {
+	// StopTimeout is how long Stop waits for the children to exit before
+	// killing them, 10s if zero.
+	StopTimeout time.Duration
+	// Logger receives state changes of the children. The service logger is
+	// used if nil.
+	Logger service.Logger
+
+	order []*proc // children in start order
+
+	mu       sync.Mutex
+	started  bool
+	stopping bool
+	reload   chan os.Signal
+	done     chan struct{} // closed when every child has exited
+	err      error         // first error of a child exiting for good
+}
+
+// New returns a Supervisor of children. Names must be unique and
+// dependencies must exist and not form a cycle.
+func New(children ...Child) (*Supervisor, error) {
+	byName := map[string]*proc{}
+	for i := range children {
+		c := children[i]
+		if c.Name == "" || c.Command == nil {
+			return nil, fmt.Errorf("supervisor: child %d needs a Name and a Command", i)
+		}
+		if byName[c.Name] != nil {
+			return nil, fmt.Errorf("supervisor: duplicate child %q", c.Name)
+		}
+		if c.RestartDelay == 0 {
+			c.RestartDelay = time.Second
+		}
+		if c.MaxRestartDelay == 0 {
+			c.MaxRestartDelay = time.Minute
+		}
+		byName[c.Name] = &proc{Child: c}
+	}
+	for _, p := range byName {
+		for _, d := range p.DependsOn {
+			dep := byName[d]
+			if dep == nil {
+				return nil, fmt.Errorf("supervisor: %q depends on unknown child %q", p.Name, d)
+			}
+			p.deps = append(p.deps, dep)
+		}
+	}
+
+	// Order children so each comes after its dependencies.
+	s := &Supervisor{}
+	visiting := map[*proc]bool{}
+	visited := map[*proc]bool{}
+	var visit func(p *proc) error
+	visit = func(p *proc) error {
+		if visited[p] {
+			return nil
+		}
+		if visiting[p] {
+			return fmt.Errorf("supervisor: dependency cycle through %q", p.Name)
+		}
+		visiting[p] = true
+		for _, d := range p.deps {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		visited[p] = true
+		s.order = append(s.order, p)
+		return nil
+	}
+	for i := range children {
+		if err := visit(byName[children[i].Name]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Start starts the children in dependency order and returns. It implements
+// service.Interface.
+func (s *Supervisor) Start(svc service.Service) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("supervisor: already started")
+	}
+	if s.Logger == nil {
+		s.Logger = nopLogger{}
+		if svc != nil {
+			if l, err := svc.Logger(nil); err == nil {
+				s.Logger = l
+			}
+		}
+	}
+	if s.StopTimeout == 0 {
+		s.StopTimeout = 10 * time.Second
+	}
+	s.started = true
+	s.done = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, p := range s.order {
+		p.init(s.Logger)
+		wg.Add(1)
+		go func(p *proc) {
+			defer wg.Done()
+			err := p.run()
+			s.mu.Lock()
+			if s.err == nil && err != nil && !s.stopping {
+				s.err = fmt.Errorf("%s: %w", p.Name, err)
+			}
+			s.mu.Unlock()
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		close(s.done)
+	}()
+
+	s.reload = make(chan os.Signal, 1)
+	notifyReload(s.reload)
+	go func(c chan os.Signal) {
+		for range c {
+			if err := s.Reload(); err != nil {
+				s.Logger.Warningf("reload: %v", err)
+			}
+		}
+	}(s.reload)
+	return nil
+}
+
+// Stop stops the children, those depending on others first, and kills what
+// is still running after StopTimeout. It returns an error if a killed child
+// has still not exited StopTimeout later. It implements service.Interface.
+func (s *Supervisor) Stop(svc service.Service) error {
+	s.mu.Lock()
+	if !s.started || s.stopping {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopping = true
+	stopReload(s.reload)
+	close(s.reload)
+	s.mu.Unlock()
+
+	deadline := time.After(s.StopTimeout)
+	for i := len(s.order) - 1; i >= 0; i-- {
+		p := s.order[i]
+		p.stop()
+		select {
+		case <-p.done:
+			continue
+		case <-deadline:
+		}
+		s.Logger.Warningf("children did not exit within %v, killing them", s.StopTimeout)
+		for ; i >= 0; i-- {
+			s.order[i].stop()
+			s.order[i].kill()
+		}
+		break
+	}
+	// Killed children are reaped within waitDelay, even if their output is
+	// held open.
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(waitDelay + s.StopTimeout):
+	}
+	var running []string
+	for _, p := range s.order {
+		select {
+		case <-p.done:
+		default:
+			running = append(running, p.Name)
+		}
+	}
+	return fmt.Errorf("supervisor: %s still running after being killed", strings.Join(running, ", "))
+}
+
+// Reload sends the ReloadSignal of every running child.
+func (s *Supervisor) Reload() error {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if !started {
+		return ErrNotStarted
+	}
+	var errs []error
+	for _, p := range s.order {
+		if err := p.signal(p.ReloadSignal, reloadSignal); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status returns the state of every child, in start order.
+func (s *Supervisor) Status() []ChildStatus {
+	status := make([]ChildStatus, len(s.order))
+	for i, p := range s.order {
+		status[i] = p.status()
+	}
+	return status
+}
+
+// Done is closed once every child has exited and will not be restarted,
+// either because of its Restart policy or because of Stop. It is nil before
+// Start.
+func (s *Supervisor) Done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+// Err returns the error of the first child that exited for good with an
+// error, other than because of Stop.
+func (s *Supervisor) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Error(v ...interface{}) error                   { return nil }
+func (nopLogger) Warning(v ...interface{}) error                 { return nil }
+func (nopLogger) Info(v ...interface{}) error                    { return nil }
+func (nopLogger) Errorf(format string, a ...interface{}) error   { return nil }
+func (nopLogger) Warningf(format string, a ...interface{}) error { return nil }
+func (nopLogger) Infof(format string, a ...interface{}) error    { return nil }
@@ -0,0 +1,321 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package supervisor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func shell(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	return sh
+}
+
+// script returns a Child running script with sh, its standard output written
+// to out. Standard error is dropped as sh reports there the sleep killed with
+// its process group.
+func script(sh, name, script string, out *syncBuffer) Child {
+	return Child{
+		Name: name,
+		Command: func() (*exec.Cmd, error) {
+			cmd := exec.Command(sh, "-c", script)
+			cmd.Stdout = out
+			return cmd, nil
+		},
+	}
+}
+
+// waitFor waits up to 5s for the output to contain s.
+func waitFor(t *testing.T, out *syncBuffer, s string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), s) {
+		if time.Now().After(deadline) {
+			t.Fatalf("output %q does not contain %q", out.String(), s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNew(t *testing.T) {
+	cmd := func() (*exec.Cmd, error) { return nil, nil }
+	tests := []struct {
+		name     string
+		children []Child
+		order    []string
+		err      string
+	}{
+		{
+			name: "order",
+			children: []Child{
+				{Name: "web", DependsOn: []string{"db", "cache"}, Command: cmd},
+				{Name: "cache", Command: cmd},
+				{Name: "db", DependsOn: []string{"cache"}, Command: cmd},
+			},
+			order: []string{"cache", "db", "web"},
+		},
+		{
+			name:     "missing command",
+			children: []Child{{Name: "a"}},
+			err:      "needs a Name and a Command",
+		},
+		{
+			name:     "duplicate",
+			children: []Child{{Name: "a", Command: cmd}, {Name: "a", Command: cmd}},
+			err:      `duplicate child "a"`,
+		},
+		{
+			name:     "unknown dependency",
+			children: []Child{{Name: "a", DependsOn: []string{"b"}, Command: cmd}},
+			err:      `"a" depends on unknown child "b"`,
+		},
+		{
+			name: "cycle",
+			children: []Child{
+				{Name: "a", DependsOn: []string{"b"}, Command: cmd},
+				{Name: "b", DependsOn: []string{"a"}, Command: cmd},
+			},
+			err: "dependency cycle",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.children...)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("New() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var order []string
+			for _, st := range s.Status() {
+				order = append(order, st.Name)
+			}
+			if strings.Join(order, ",") != strings.Join(tt.order, ",") {
+				t.Errorf("order = %q, want %q", order, tt.order)
+			}
+		})
+	}
+}
+
+func TestRestart(t *testing.T) {
+	sh := shell(t)
+	out := &syncBuffer{}
+	c := script(sh, "fail", "echo run; exit 1", out)
+	c.Restart = RestartOnFailure
+	c.RestartDelay = time.Millisecond
+	c.MaxRestartDelay = 5 * time.Millisecond
+	ok := script(sh, "ok", "exit 0", out)
+	ok.Restart = RestartOnFailure
+	s, err := New(c, ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(out.String(), "run") < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := strings.Count(out.String(), "run"); n < 3 {
+		t.Errorf("command ran %d times, want at least 3", n)
+	}
+	status := s.Status()
+	if status[0].Restarts == 0 || status[0].LastErr == nil {
+		t.Errorf("status of fail = %+v, want restarts and an error", status[0])
+	}
+	if status[1].State != StateExited || status[1].LastErr != nil || status[1].Restarts != 0 {
+		t.Errorf("status of ok = %+v, want exited without restarts", status[1])
+	}
+	if err := s.Stop(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after Stop", err)
+	}
+}
+
+func TestDone(t *testing.T) {
+	sh := shell(t)
+	out := &syncBuffer{}
+	c := script(sh, "once", "exit 3", out)
+	c.Restart = RestartNever
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done not closed after the child exited")
+	}
+	var exitErr *exec.ExitError
+	if err := s.Err(); err == nil || !strings.HasPrefix(err.Error(), "once: ") || !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("Err() = %v, want exit status 3 of once", err)
+	}
+	s.Stop(nil)
+}
+
+func TestStopOrder(t *testing.T) {
+	sh := shell(t)
+	out := &syncBuffer{}
+	run := `trap 'echo stop %[1]s; exit 0' TERM; echo start %[1]s; while :; do sleep 0.05; done`
+	db := script(sh, "db", strings.ReplaceAll(run, "%[1]s", "db"), out)
+	web := script(sh, "web", strings.ReplaceAll(run, "%[1]s", "web"), out)
+	web.DependsOn = []string{"db"}
+	s, err := New(web, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, out, "start web")
+	waitFor(t, out, "start db")
+	for _, st := range s.Status() {
+		if st.State != StateRunning || st.Pid == 0 {
+			t.Errorf("status = %+v, want running", st)
+		}
+	}
+	if err := s.Stop(nil); err != nil {
+		t.Fatal(err)
+	}
+	// A child starts once its dependencies' processes have, so only the
+	// stop order is deterministic.
+	var stops []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "stop ") {
+			stops = append(stops, line)
+		}
+	}
+	if want := []string{"stop web", "stop db"}; strings.Join(stops, ",") != strings.Join(want, ",") {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+	for _, st := range s.Status() {
+		if st.State != StateExited {
+			t.Errorf("status after Stop = %+v, want exited", st)
+		}
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	sh := shell(t)
+	out := &syncBuffer{}
+	c := script(sh, "stubborn", `trap '' TERM; echo ready; while :; do sleep 0.05; done`, out)
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.StopTimeout = 200 * time.Millisecond
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, out, "ready")
+	start := time.Now()
+	if err := s.Stop(nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < s.StopTimeout || d > 5*time.Second {
+		t.Errorf("Stop took %v, want the child killed after %v", d, s.StopTimeout)
+	}
+}
+
+func TestStopGrandchildHoldsOutput(t *testing.T) {
+	sh := shell(t)
+	setsid, err := exec.LookPath("setsid")
+	if err != nil {
+		t.Skip("setsid not found")
+	}
+	out := &syncBuffer{}
+	// The grandchild runs in its own session, out of reach of the signals
+	// to the process group of the child, and keeps its standard output.
+	c := script(sh, "parent", setsid+` sleep 30 & echo "ready $!"; wait`, out)
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.StopTimeout = 200 * time.Millisecond
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, out, "ready")
+	defer func() {
+		var pid int
+		if _, err := fmt.Sscanf(out.String(), "ready %d", &pid); err == nil {
+			if p, err := os.FindProcess(pid); err == nil {
+				p.Kill()
+			}
+		}
+	}()
+	start := time.Now()
+	if err := s.Stop(nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Stop took %v, want it not to wait for the grandchild", d)
+	}
+}
+
+func TestReload(t *testing.T) {
+	sh := shell(t)
+	out := &syncBuffer{}
+	c := script(sh, "app", `trap 'echo reload' HUP; echo ready; while :; do sleep 0.05; done`, out)
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err != ErrNotStarted {
+		t.Errorf("Reload() before Start = %v, want ErrNotStarted", err)
+	}
+	if err := s.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(nil)
+	waitFor(t, out, "ready")
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, out, "reload")
+}
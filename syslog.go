@@ -0,0 +1,486 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogFormat is the message format of a SyslogLogger.
+type SyslogFormat int
+
+const (
+	RFC5424 SyslogFormat = iota // The syslog protocol, with structured data.
+	RFC3164                     // The BSD syslog protocol.
+)
+
+// syslogFacilities are the facility codes by name.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog severities of the Logger levels.
+const (
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+	severityDebug   = 7
+)
+
+// SyslogConfig configures a SyslogLogger.
+type SyslogConfig struct {
+	// Network is "udp", "tcp" or "tls".
+	Network string
+	// Address is the host:port of the syslog server.
+	Address string
+	// TLSConfig is used with "tls". The server name is the host of Address
+	// if nil.
+	TLSConfig *tls.Config
+
+	Format SyslogFormat
+	// Facility is the facility name, such as "daemon" or "local0". Daemon if
+	// empty.
+	Facility string
+	// Hostname, AppName and ProcID identify the sender. They default to the
+	// host name, the base name of the executable and the process id.
+	Hostname, AppName, ProcID string
+	// MsgID is the RFC 5424 message type. Nil if empty.
+	MsgID string
+	// SDID is the ID of the structured data element holding the fields,
+	// "fields@32473" if empty. RFC 3164 messages append the fields to the
+	// text as key=value.
+	SDID string
+	// Fields are sent with every message.
+	Fields map[string]string
+
+	// BufferSize is the number of messages queued while the server is not
+	// reachable, 1000 if zero. The oldest messages are dropped first.
+	BufferSize int
+	// DialTimeout and WriteTimeout bound connecting and sending, 5s if zero.
+	DialTimeout, WriteTimeout time.Duration
+	// ReconnectDelay is the wait between connection attempts, 1s if zero.
+	ReconnectDelay time.Duration
+
+	// Level is the initial level of the logger.
+	Level Level
+}
+
+// SyslogLogger is a Logger sending to a remote syslog server. TCP and TLS
+// messages are framed by octet counting (RFC 6587).
+//
+// Messages are queued and sent in the background, so the Logger functions
+// only fail after Close. Run flushes the queue once the service is stopped.
+// Connection errors are sent on errs, once per outage, and dropped while errs
+// is full.
+type SyslogLogger struct {
+	*syslogConn
+	fields map[string]string
+}
+
+// syslogConn is the connection and level shared by a SyslogLogger and those
+// returned by its WithFields.
+type syslogConn struct {
+	c        SyslogConfig
+	facility int
+	errs     chan<- error
+	*levelVar
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   [][]byte
+	head    int // sequence number of queue[0]
+	dropped int
+	closed  bool
+	stopped bool // run returned
+	done    chan struct{}
+}
+
+// NewSyslogLogger returns a Logger sending to the server of c. If errs is
+// non-nil connection errors are sent on errs.
+func NewSyslogLogger(c SyslogConfig, errs chan<- error) (*SyslogLogger, error) {
+	switch c.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("syslog network %q is not one of udp, tcp, tls", c.Network)
+	}
+	host, _, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("syslog address: %v", err)
+	}
+	if c.Facility == "" {
+		c.Facility = "daemon"
+	}
+	facility, ok := syslogFacilities[c.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", c.Facility)
+	}
+	if c.Network == "tls" && c.TLSConfig == nil {
+		c.TLSConfig = &tls.Config{ServerName: host}
+	}
+	if c.Hostname == "" {
+		c.Hostname, _ = os.Hostname()
+	}
+	if c.AppName == "" {
+		c.AppName = filepath.Base(os.Args[0])
+	}
+	if c.ProcID == "" {
+		c.ProcID = strconv.Itoa(os.Getpid())
+	}
+	if c.SDID == "" {
+		c.SDID = "fields@32473"
+	}
+	if c.BufferSize == 0 {
+		c.BufferSize = 1000
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = 5 * time.Second
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = 5 * time.Second
+	}
+	if c.ReconnectDelay == 0 {
+		c.ReconnectDelay = time.Second
+	}
+	sc := &syslogConn{c: c, facility: facility, errs: errs, levelVar: newLevelVar(c.Level), done: make(chan struct{})}
+	sc.cond = sync.NewCond(&sc.mu)
+	go sc.run()
+	trackFlusher(sc)
+	return &SyslogLogger{syslogConn: sc, fields: c.Fields}, nil
+}
+
+// WithFields returns a Logger sending fields with every message in addition
+// to those of l. It shares the connection of l.
+func (l *SyslogLogger) WithFields(fields map[string]string) *SyslogLogger {
+	merged := make(map[string]string, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &SyslogLogger{syslogConn: l.syslogConn, fields: merged}
+}
+
+func (l *SyslogLogger) log(level Level, severity int, msg string) error {
+	if !l.enabled(level) {
+		return nil
+	}
+	return l.enqueue(l.format(severity, msg, l.fields, time.Now()))
+}
+
+func (l *SyslogLogger) Error(v ...interface{}) error {
+	return l.log(LevelError, severityError, fmt.Sprint(v...))
+}
+func (l *SyslogLogger) Warning(v ...interface{}) error {
+	return l.log(LevelWarning, severityWarning, fmt.Sprint(v...))
+}
+func (l *SyslogLogger) Info(v ...interface{}) error {
+	return l.log(LevelInfo, severityInfo, fmt.Sprint(v...))
+}
+func (l *SyslogLogger) Debug(v ...interface{}) error {
+	return l.log(LevelDebug, severityDebug, fmt.Sprint(v...))
+}
+func (l *SyslogLogger) Errorf(format string, a ...interface{}) error {
+	return l.log(LevelError, severityError, fmt.Sprintf(format, a...))
+}
+func (l *SyslogLogger) Warningf(format string, a ...interface{}) error {
+	return l.log(LevelWarning, severityWarning, fmt.Sprintf(format, a...))
+}
+func (l *SyslogLogger) Infof(format string, a ...interface{}) error {
+	return l.log(LevelInfo, severityInfo, fmt.Sprintf(format, a...))
+}
+func (l *SyslogLogger) Debugf(format string, a ...interface{}) error {
+	return l.log(LevelDebug, severityDebug, fmt.Sprintf(format, a...))
+}
+
+// Close sends the queued messages, giving up if the server is not reachable,
+// and closes the connection shared with the loggers of WithFields.
+func (l *SyslogLogger) Close() error {
+	return l.close()
+}
+
+func (sc *syslogConn) close() error {
+	untrackFlusher(sc)
+	sc.mu.Lock()
+	if !sc.closed {
+		sc.closed = true
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+	<-sc.done
+	return nil
+}
+
+// Flush waits until the queued messages are sent, for at most timeout if it
+// is positive. It is shared with the loggers of WithFields.
+func (sc *syslogConn) Flush(timeout time.Duration) error {
+	expired := false
+	if timeout > 0 {
+		t := time.AfterFunc(timeout, func() {
+			sc.mu.Lock()
+			expired = true
+			sc.cond.Broadcast()
+			sc.mu.Unlock()
+		})
+		defer t.Stop()
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for len(sc.queue) > 0 && !expired && !sc.stopped {
+		sc.cond.Wait()
+	}
+	if len(sc.queue) > 0 {
+		return ErrFlushTimeout
+	}
+	return nil
+}
+
+// format returns the message of msg at severity, without framing.
+func (sc *syslogConn) format(severity int, msg string, fields map[string]string, t time.Time) []byte {
+	c := &sc.c
+	pri := sc.facility*8 + severity
+	msg = strings.TrimRight(msg, "\n")
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	if c.Format == RFC3164 {
+		fmt.Fprintf(&b, "<%d>%s %s %s[%s]: %s", pri, t.Format(time.Stamp),
+			syslogHeader(c.Hostname, 255), syslogHeader(c.AppName, 32), syslogHeader(c.ProcID, 128), msg)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%q", k, fields[k])
+		}
+		return []byte(b.String())
+	}
+
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s ", pri, t.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeader(c.Hostname, 255), syslogHeader(c.AppName, 48), syslogHeader(c.ProcID, 128), syslogHeader(c.MsgID, 32))
+	if len(keys) == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + sdName(c.SDID))
+		for _, k := range keys {
+			b.WriteString(" " + sdName(k) + `="` + sdEscape.Replace(fields[k]) + `"`)
+		}
+		b.WriteString("]")
+	}
+	if msg != "" {
+		b.WriteString(" " + msg)
+	}
+	return []byte(b.String())
+}
+
+// parseSyslogAddress splits an address such as "tcp://logs:514" into its
+// network and host:port.
+func parseSyslogAddress(s string) (network, address string, err error) {
+	i := strings.Index(s, "://")
+	if i < 0 {
+		return "", "", fmt.Errorf("syslog address %q is not network://host:port", s)
+	}
+	network, address = s[:i], s[i+3:]
+	switch network {
+	case "udp", "tcp", "tls":
+	default:
+		return "", "", fmt.Errorf("syslog network %q is not one of udp, tcp, tls", network)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", "", fmt.Errorf("syslog address: %v", err)
+	}
+	return network, address, nil
+}
+
+// parseSyslogFormat returns the format named s, RFC 5424 if empty.
+func parseSyslogFormat(s string) (SyslogFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "rfc5424":
+		return RFC5424, nil
+	case "rfc3164":
+		return RFC3164, nil
+	}
+	return 0, fmt.Errorf("syslog format %q is not rfc5424 or rfc3164", s)
+}
+
+// syslogHeader returns s as a header field of at most max printable ASCII
+// characters, or "-" if empty.
+func syslogHeader(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+// sdName returns s as a structured data name.
+func sdName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	return s
+}
+
+var sdEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// enqueue queues msg, dropping the oldest message if the queue is full.
+func (sc *syslogConn) enqueue(msg []byte) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return os.ErrClosed
+	}
+	if len(sc.queue) >= sc.c.BufferSize {
+		sc.queue = sc.queue[1:]
+		sc.head++
+		sc.dropped++
+	}
+	sc.queue = append(sc.queue, msg)
+	sc.cond.Signal()
+	return nil
+}
+
+// next waits for the oldest queued message and returns it with its sequence
+// number. It returns false once closed with an empty queue.
+func (sc *syslogConn) next() ([]byte, int, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for len(sc.queue) == 0 && !sc.closed {
+		sc.cond.Wait()
+	}
+	if len(sc.queue) == 0 {
+		return nil, 0, false
+	}
+	return sc.queue[0], sc.head, true
+}
+
+// sent removes the message seq from the queue unless it was dropped
+// meanwhile.
+func (sc *syslogConn) sent(seq int) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.queue) > 0 && sc.head == seq {
+		sc.queue = sc.queue[1:]
+		sc.head++
+		sc.cond.Broadcast()
+	}
+}
+
+func (sc *syslogConn) isClosed() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.closed
+}
+
+func (sc *syslogConn) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: sc.c.DialTimeout}
+	if sc.c.Network == "tls" {
+		return tls.DialWithDialer(d, "tcp", sc.c.Address, sc.c.TLSConfig)
+	}
+	return d.Dial(sc.c.Network, sc.c.Address)
+}
+
+// send passes err on errs, dropping it if errs is full so that a slow reader
+// does not stop the messages.
+func (sc *syslogConn) send(err error) {
+	if err == nil || sc.errs == nil {
+		return
+	}
+	select {
+	case sc.errs <- err:
+	default:
+	}
+}
+
+// run sends the queued messages, reconnecting as needed.
+func (sc *syslogConn) run() {
+	defer close(sc.done)
+	defer func() {
+		sc.mu.Lock()
+		sc.stopped = true
+		sc.cond.Broadcast()
+		sc.mu.Unlock()
+	}()
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	failing := false
+	for {
+		msg, seq, ok := sc.next()
+		if !ok {
+			return
+		}
+		if conn == nil {
+			var err error
+			conn, err = sc.dial()
+			if err != nil {
+				conn = nil
+				if !failing {
+					sc.send(fmt.Errorf("syslog: %v", err))
+					failing = true
+				}
+				if sc.isClosed() {
+					return
+				}
+				time.Sleep(sc.c.ReconnectDelay)
+				continue
+			}
+			if failing {
+				failing = false
+				sc.mu.Lock()
+				dropped := sc.dropped
+				sc.dropped = 0
+				sc.mu.Unlock()
+				if dropped > 0 {
+					sc.send(fmt.Errorf("syslog: dropped %d messages while disconnected", dropped))
+				}
+			}
+		}
+		frame := msg
+		if sc.c.Network != "udp" {
+			frame = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+		conn.SetWriteDeadline(time.Now().Add(sc.c.WriteTimeout))
+		if _, err := conn.Write(frame); err != nil {
+			conn.Close()
+			conn = nil
+			if !failing {
+				sc.send(fmt.Errorf("syslog: %v", err))
+				failing = true
+			}
+			continue
+		}
+		sc.sent(seq)
+	}
+}
@@ -0,0 +1,399 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	at := time.Date(2024, 3, 5, 7, 8, 9, 123456000, time.UTC)
+	tests := []struct {
+		name   string
+		c      SyslogConfig
+		sev    int
+		msg    string
+		fields map[string]string
+		want   string
+	}{
+		{
+			name: "rfc5424",
+			c:    SyslogConfig{Hostname: "host", AppName: "app", ProcID: "42"},
+			sev:  severityInfo,
+			msg:  "started\n",
+			want: "<30>1 2024-03-05T07:08:09.123456Z host app 42 - - started",
+		},
+		{
+			name:   "rfc5424 fields",
+			c:      SyslogConfig{Facility: "local0", Hostname: "host", AppName: "app", ProcID: "42", MsgID: "ID1"},
+			sev:    severityError,
+			msg:    "failed",
+			fields: map[string]string{"b": `a "quoted" \ value]`, "a": "1"},
+			want:   `<131>1 2024-03-05T07:08:09.123456Z host app 42 ID1 [fields@32473 a="1" b="a \"quoted\" \\ value\]"] failed`,
+		},
+		{
+			name:   "rfc5424 header",
+			c:      SyslogConfig{Hostname: "my host", AppName: "app", ProcID: "42", SDID: "x@1"},
+			sev:    severityWarning,
+			msg:    "",
+			fields: map[string]string{"k": "v"},
+			want:   `<28>1 2024-03-05T07:08:09.123456Z my_host app 42 - [x@1 k="v"]`,
+		},
+		{
+			name:   "rfc3164",
+			c:      SyslogConfig{Format: RFC3164, Hostname: "host", AppName: "app", ProcID: "42"},
+			sev:    severityWarning,
+			msg:    "slow",
+			fields: map[string]string{"ms": "900"},
+			want:   `<28>Mar  5 07:08:09 host app[42]: slow ms="900"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.c.Network, tt.c.Address = "udp", "127.0.0.1:514"
+			l, err := NewSyslogLogger(tt.c, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			if got := string(l.format(tt.sev, tt.msg, tt.fields, at)); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSyslogLoggerErrors(t *testing.T) {
+	tests := []SyslogConfig{
+		{Network: "unix", Address: "127.0.0.1:514"},
+		{Network: "udp", Address: "127.0.0.1"},
+		{Network: "udp", Address: "127.0.0.1:514", Facility: "nope"},
+	}
+	for _, c := range tests {
+		if _, err := NewSyslogLogger(c, nil); err == nil {
+			t.Errorf("NewSyslogLogger(%+v) succeeded", c)
+		}
+	}
+}
+
+func TestParseSyslogAddress(t *testing.T) {
+	tests := []struct {
+		in, network, address string
+		ok                   bool
+	}{
+		{"udp://logs:514", "udp", "logs:514", true},
+		{"tls://[::1]:6514", "tls", "[::1]:6514", true},
+		{"logs:514", "", "", false},
+		{"http://logs:514", "", "", false},
+		{"tcp://logs", "", "", false},
+	}
+	for _, tt := range tests {
+		network, address, err := parseSyslogAddress(tt.in)
+		if (err == nil) != tt.ok || network != tt.network || address != tt.address {
+			t.Errorf("parseSyslogAddress(%q) = %q, %q, %v", tt.in, network, address, err)
+		}
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	l, err := NewSyslogLogger(SyslogConfig{Network: "udp", Address: pc.LocalAddr().String(),
+		Hostname: "host", AppName: "app", ProcID: "1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.WithFields(map[string]string{"k": "v"}).Infof("hello %d", 1)
+	l.Close()
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	if want := ` host app 1 - [fields@32473 k="v"] hello 1`; !strings.HasPrefix(got, "<30>1 ") || !strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want suffix %q", got, want)
+	}
+}
+
+// readFrames reads n octet-counted messages from r.
+func readFrames(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	var msgs []string
+	for len(msgs) < n {
+		size, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatalf("after %q: %v", msgs, err)
+		}
+		length, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, string(msg))
+	}
+	return msgs
+}
+
+func checkMessages(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range got {
+		if !strings.HasSuffix(got[i], " "+want[i]) {
+			t.Errorf("message %d is %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func testSyslogStream(t *testing.T, ln net.Listener, c SyslogConfig) {
+	defer ln.Close()
+	c.Address = ln.Addr().String()
+	l, err := NewSyslogLogger(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The TLS handshake needs the server reading while the logger connects.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if tc, ok := conn.(*tls.Conn); ok {
+			tc.Handshake()
+		}
+		accepted <- conn
+	}()
+	l.Info("one")
+	l.Error("two\nlines")
+	l.Close()
+
+	conn, ok := <-accepted
+	if !ok {
+		t.Fatal("no connection")
+	}
+	defer conn.Close()
+	checkMessages(t, readFrames(t, bufio.NewReader(conn), 2), "one", "two\nlines")
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSyslogStream(t, ln, SyslogConfig{Network: "tcp"})
+}
+
+func TestSyslogTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSyslogStream(t, ln, SyslogConfig{Network: "tls",
+		TLSConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"}})
+}
+
+// testCertificate returns a self-signed certificate of localhost and a pool
+// trusting it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestSyslogReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// Messages are buffered while the server is down, dropping the oldest.
+	errs := make(chan error, 10)
+	l, err := NewSyslogLogger(SyslogConfig{Network: "tcp", Address: addr,
+		BufferSize: 2, ReconnectDelay: 10 * time.Millisecond}, errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Info("lost")
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection error")
+	}
+	l.Info("kept 1")
+	l.Info("kept 2")
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip("cannot listen again:", err)
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	checkMessages(t, readFrames(t, bufio.NewReader(conn), 2), "kept 1", "kept 2")
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "dropped 1") {
+			t.Errorf("got %v, want the dropped count", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no dropped error")
+	}
+
+	// After the server goes away the logger connects again. The write of the
+	// first message may succeed before the closed connection is noticed.
+	conn.Close()
+	conn = nil
+	for i := 0; conn == nil; i++ {
+		l.Info("again ", i)
+		ln.(*net.TCPListener).SetDeadline(time.Now().Add(100 * time.Millisecond))
+		conn, _ = ln.Accept()
+		if i > 50 {
+			t.Fatal("no reconnection")
+		}
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg := readFrames(t, bufio.NewReader(conn), 1)[0]
+	if !strings.Contains(msg, " again ") {
+		t.Errorf("got %q after reconnecting", msg)
+	}
+}
+
+func TestSyslogErrorsNotRead(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// Nobody reads errs: the connection error must not stop Close.
+	l, err := NewSyslogLogger(SyslogConfig{Network: "tcp", Address: addr}, make(chan error))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("lost")
+	closed := make(chan struct{})
+	go func() {
+		l.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on the error channel")
+	}
+}
+
+func TestSyslogFlush(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l, err := NewSyslogLogger(SyslogConfig{Network: "tcp", Address: addr, ReconnectDelay: 10 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("queued while down")
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			close(accepted)
+			return
+		}
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	// Run flushes the open loggers when it returns.
+	flushLoggers()
+	l.mu.Lock()
+	queued := len(l.queue)
+	l.mu.Unlock()
+	if queued != 0 {
+		t.Errorf("%d messages queued after flushLoggers", queued)
+	}
+	conn, ok := <-accepted
+	if !ok {
+		t.Skip("cannot listen again")
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	checkMessages(t, readFrames(t, bufio.NewReader(conn), 1), "queued while down")
+
+	l.Close()
+	flushersMu.Lock()
+	tracked := flushers[l.syslogConn]
+	flushersMu.Unlock()
+	if tracked {
+		t.Error("a closed logger is still flushed by Run")
+	}
+	if err := l.Flush(time.Second); err != nil {
+		t.Errorf("Flush after Close = %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"os"
+)
+
+// UninstallOptions controls what UninstallWithOptions removes besides the
+// files created by Install.
+type UninstallOptions struct {
+	// Purge also removes log files, environment files, systemd drop-ins and
+	// StateDirectories.
+	Purge bool
+	// StateDirectories are removed with all their contents when Purge is set.
+	StateDirectories []string
+	// RemoveUser deletes the account named by Config.UserName when Purge is
+	// set. Only set it when the account was created for the service.
+	RemoveUser bool
+}
+
+// UninstallReport describes what UninstallWithOptions did.
+type UninstallReport struct {
+	// Stopped is true if the service was running and has been stopped.
+	Stopped bool
+	// Removed lists the files and directories that have been removed.
+	Removed []string
+	// RemovedUser is the account that has been deleted, if any.
+	RemovedUser string
+}
+
+// uninstaller is implemented by services that report what Uninstall removes.
+type uninstaller interface {
+	uninstall(opts UninstallOptions, r *UninstallReport) error
+}
+
+// UninstallWithOptions stops s if it is running and removes everything its
+// Install created. See UninstallOptions for what else can be removed.
+// The report lists what was removed even when an error is returned.
+func UninstallWithOptions(s Service, opts UninstallOptions) (*UninstallReport, error) {
+	r := &UninstallReport{}
+	if status, err := s.Status(); err == nil && status == StatusRunning {
+		if err := s.Stop(); err != nil {
+			return r, err
+		}
+		r.Stopped = true
+	}
+	var err error
+	if u, ok := s.(uninstaller); ok {
+		err = u.uninstall(opts, r)
+	} else {
+		err = s.Uninstall()
+	}
+	if err != nil || !opts.Purge {
+		return r, err
+	}
+	for _, dir := range opts.StateDirectories {
+		if err := r.removeAll(dir); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// purge removes paths and the service user when opts.Purge is set.
+func (r *UninstallReport) purge(opts UninstallOptions, c *Config, paths ...string) error {
+	if !opts.Purge {
+		return nil
+	}
+	for _, p := range paths {
+		if err := r.remove(p); err != nil {
+			return err
+		}
+	}
+	if opts.RemoveUser && c.UserName != "" {
+		if err := removeUser(c.UserName); err != nil {
+			return err
+		}
+		r.RemovedUser = c.UserName
+	}
+	return nil
+}
+
+// removeInstalled deletes path, which must exist, and records it.
+func (r *UninstallReport) removeInstalled(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	r.Removed = append(r.Removed, path)
+	return nil
+}
+
+// remove deletes path if it exists and records it.
+func (r *UninstallReport) remove(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.Removed = append(r.Removed, path)
+	return nil
+}
+
+// removeAll deletes path and its contents if it exists and records it.
+func (r *UninstallReport) removeAll(path string) error {
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	r.Removed = append(r.Removed, path)
+	return nil
+}
@@ -0,0 +1,18 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !solaris && !aix && !freebsd && !windows
+// +build !linux,!darwin,!solaris,!aix,!freebsd,!windows
+
+package service
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// removeUser deletes the named account.
+func removeUser(name string) error {
+	return fmt.Errorf("removing users is not supported on %s", runtime.GOOS)
+}
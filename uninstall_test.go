@@ -0,0 +1,83 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeUninstallService removes files under dir like a backend would.
+type fakeUninstallService struct {
+	Service
+	dir     string
+	status  Status
+	stopped bool
+}
+
+func (f *fakeUninstallService) Status() (Status, error) { return f.status, nil }
+func (f *fakeUninstallService) Stop() error             { f.stopped = true; return nil }
+
+func (f *fakeUninstallService) uninstall(opts UninstallOptions, r *UninstallReport) error {
+	if err := r.removeInstalled(filepath.Join(f.dir, "unit")); err != nil {
+		return err
+	}
+	if err := r.remove(filepath.Join(f.dir, "missing.pid")); err != nil {
+		return err
+	}
+	return r.purge(opts, &Config{}, filepath.Join(f.dir, "demo.log"))
+}
+
+func TestUninstallWithOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  Status
+		opts    UninstallOptions
+		removed []string
+	}{
+		{"stopped", StatusStopped, UninstallOptions{}, []string{"unit"}},
+		{"running", StatusRunning, UninstallOptions{}, []string{"unit"}},
+		{"purge", StatusStopped, UninstallOptions{Purge: true, StateDirectories: []string{"state"}}, []string{"unit", "demo.log", "state"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "uninstall")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for _, name := range []string{"unit", "demo.log", "state/data"} {
+				p := filepath.Join(dir, name)
+				os.MkdirAll(filepath.Dir(p), 0755)
+				if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opts := tt.opts
+			for i, d := range opts.StateDirectories {
+				opts.StateDirectories[i] = filepath.Join(dir, d)
+			}
+
+			s := &fakeUninstallService{dir: dir, status: tt.status}
+			r, err := UninstallWithOptions(s, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, name := range tt.removed {
+				want = append(want, filepath.Join(dir, name))
+			}
+			if !reflect.DeepEqual(r.Removed, want) {
+				t.Errorf("Removed = %v, want %v", r.Removed, want)
+			}
+			if r.Stopped != (tt.status == StatusRunning) || s.stopped != r.Stopped {
+				t.Errorf("Stopped = %v, service stopped = %v", r.Stopped, s.stopped)
+			}
+		})
+	}
+}
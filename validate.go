@@ -0,0 +1,438 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError is a problem with one field of a Config.
+type FieldError struct {
+	// Field is the path of the field, such as "Name", "Credentials[0].Name"
+	// or `Option["LimitNOFILE"]`.
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError is returned by Validate with every problem found in a
+// Config.
+type ValidationError struct {
+	System   string
+	Problems []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return fmt.Sprintf("invalid config for %s: %s", e.System, strings.Join(msgs, "; "))
+}
+
+// add records a problem with field.
+func (e *ValidationError) add(field, format string, a ...interface{}) {
+	e.Problems = append(e.Problems, FieldError{Field: field, Message: fmt.Sprintf(format, a...)})
+}
+
+// optionKinds holds the type of the value of every known option.
+var optionKinds = map[string]string{
+	optionKeepAlive:           "bool",
+	optionRunAtLoad:           "bool",
+	optionUserService:         "bool",
+	optionUserServiceFor:      "string",
+	optionUserServiceGlobal:   "bool",
+	optionLinger:              "bool",
+	optionSystemdDBus:         "bool",
+	optionSessionCreate:       "bool",
+	optionLogOutput:           "bool",
+	optionPrefix:              "string",
+	optionRunWait:             "func()",
+	optionReloadSignal:        "string",
+	optionPIDFile:             "string",
+	optionLimitNOFILE:         "int",
+	optionRestart:             "string",
+	optionSuccessExitStatus:   "string",
+	optionSystemdScript:       "string",
+	optionSysvScript:          "string",
+	optionRCSScript:           "string",
+	optionUpstartScript:       "string",
+	optionLaunchdConfig:       "string",
+	optionOpenRCScript:        "string",
+	optionLogDirectory:        "string",
+	optionEnvFile:             "string",
+	optionLogLevel:            "string",
+	optionConsoleFormat:       "string",
+	optionRedirectOutput:      "bool",
+	optionRedirectStdoutLevel: "string",
+	optionRedirectStderrLevel: "string",
+	optionLogFile:             "bool",
+	optionLogMaxSize:          "int",
+	optionLogMaxAge:           "string",
+	optionLogMaxBackups:       "int",
+	optionLogCompress:         "bool",
+	optionLogRotate:           "bool",
+	optionSyslogAddress:       "string",
+	optionSyslogFacility:      "string",
+	optionSyslogFormat:        "string",
+
+	// Windows
+	"StartType":              "string",
+	"Interactive":            "bool",
+	"Password":               "string",
+	"DelayedAutoStart":       "bool",
+	"OnFailure":              "string",
+	"OnFailureDelayDuration": "string",
+	"OnFailureResetPeriod":   "int",
+}
+
+// kindOf returns the option type of v, as used in optionKinds.
+func kindOf(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return "bool"
+	case int:
+		return "int"
+	case string:
+		return "string"
+	case float64:
+		return "float64"
+	case func():
+		return "func()"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// systemSupport describes what a System does with a Config.
+type systemSupport struct {
+	// name returns what is wrong with a service name, or "" if the system
+	// accepts it.
+	name func(name string) string
+	// fields are the optional Config fields the system uses.
+	fields []string
+	// options are the options the system reads.
+	options []string
+	// userService reports whether the UserService option may be true.
+	userService bool
+	// encryptedCredentials reports whether encrypted credentials are supported.
+	encryptedCredentials bool
+	// check, if set, adds problems specific to the system.
+	check func(c *Config, e *ValidationError)
+}
+
+// logOptions are the options of the built-in loggers on every system.
+var logOptions = []string{optionLogLevel, optionConsoleFormat, optionRedirectOutput, optionRedirectStdoutLevel, optionRedirectStderrLevel}
+
+// unixLogOptions are the options of the file and syslog loggers of the
+// Unix systems.
+var unixLogOptions = []string{optionLogFile, optionLogMaxSize, optionLogMaxAge, optionLogMaxBackups, optionLogCompress, optionSyslogAddress, optionSyslogFacility, optionSyslogFormat}
+
+// joinOptions returns the options of every list.
+func joinOptions(lists ...[]string) []string {
+	var options []string
+	for _, l := range lists {
+		options = append(options, l...)
+	}
+	return options
+}
+
+// supporter is implemented by the built-in systems.
+type supporter interface {
+	support() systemSupport
+}
+
+// Validate checks that c can be installed on sys and returns a
+// *ValidationError listing every problem found: invalid values, unknown
+// options and options of the wrong type. Install runs it first and installs
+// nothing if it fails. Fields and options that sys ignores are not problems;
+// Warnings lists them.
+func (c *Config) Validate(sys System) error {
+	return validateConfig(c, sys.String(), systemSupportOf(sys))
+}
+
+// Warnings returns the fields and options of c that sys ignores, such as
+// Dependencies on a system without dependency ordering. It is empty for
+// systems that do not describe what they support.
+func (c *Config) Warnings(sys System) []FieldError {
+	sup := systemSupportOf(sys)
+	if sup == nil {
+		return nil
+	}
+	return configWarnings(c, sys.String(), sup)
+}
+
+// systemSupportOf returns what sys does with a Config, or nil if unknown.
+func systemSupportOf(sys System) *systemSupport {
+	s, ok := sys.(supporter)
+	if !ok {
+		return nil
+	}
+	v := s.support()
+	return &v
+}
+
+// Validate checks the Config of s against the system that created it, as
+// Config.Validate does.
+func Validate(s Service) error {
+	if v, ok := s.(interface{ validate() error }); ok {
+		return v.validate()
+	}
+	return nil
+}
+
+// Warnings returns the fields and options of the Config of s that the system
+// that created it ignores, as Config.Warnings does.
+func Warnings(s Service) []FieldError {
+	if v, ok := s.(interface{ warnings() []FieldError }); ok {
+		return v.warnings()
+	}
+	return nil
+}
+
+// validateConfig checks c for system. Support checks are skipped if sup is nil.
+func validateConfig(c *Config, system string, sup *systemSupport) error {
+	e := &ValidationError{System: system}
+	c = withOptions(c)
+
+	checkName := unixServiceName
+	if sup != nil && sup.name != nil {
+		checkName = sup.name
+	}
+	if c.Name == "" {
+		e.add("Name", "is required")
+	} else if msg := checkName(c.Name); msg != "" {
+		e.add("Name", "%q %s", c.Name, msg)
+	}
+	for _, f := range []struct{ field, path string }{
+		{"Executable", c.Executable},
+		{"WorkingDirectory", c.WorkingDirectory},
+		{"ChRoot", c.ChRoot},
+	} {
+		if f.path != "" && !filepath.IsAbs(f.path) {
+			e.add(f.field, "%q is not an absolute path", f.path)
+		}
+	}
+	// Scripts and units hold these values one per line.
+	for _, f := range []struct{ field, value string }{
+		{"DisplayName", c.DisplayName},
+		{"Description", c.Description},
+		{"UserName", c.UserName},
+		{"Executable", c.Executable},
+		{"WorkingDirectory", c.WorkingDirectory},
+		{"ChRoot", c.ChRoot},
+	} {
+		if hasControl(f.value) {
+			e.add(f.field, "%q holds a control character", f.value)
+		}
+	}
+	for i, d := range c.Dependencies {
+		if hasControl(d) {
+			e.add(fmt.Sprintf("Dependencies[%d]", i), "%q holds a control character", d)
+		}
+	}
+	for i, cred := range c.Credentials {
+		if hasControl(cred.Path) {
+			e.add(fmt.Sprintf("Credentials[%d]", i), "path %q holds a control character", cred.Path)
+		}
+	}
+	for _, k := range sortedKeys(c.EnvVars) {
+		if !validEnvName(k) {
+			e.add(fmt.Sprintf("EnvVars[%q]", k), "invalid environment variable name")
+		}
+	}
+	encrypted := sup == nil || sup.encryptedCredentials
+	for i, cred := range c.Credentials {
+		if err := validateCredentials(&Config{Credentials: []CredentialConfig{cred}}, encrypted); err != nil {
+			e.add(fmt.Sprintf("Credentials[%d]", i), "%v", err)
+		}
+	}
+
+	for _, k := range sortedKeys(c.Option) {
+		field := fmt.Sprintf("Option[%q]", k)
+		want, known := optionKinds[k]
+		switch {
+		case !known:
+			e.add(field, "unknown option")
+		case kindOf(c.Option[k]) != want:
+			e.add(field, "is a %s, want %s", kindOf(c.Option[k]), want)
+		}
+	}
+	for _, k := range []string{optionPIDFile, optionLogDirectory, optionEnvFile} {
+		p := c.Option.string(k, "")
+		switch {
+		case p == "":
+		case !filepath.IsAbs(p):
+			e.add(fmt.Sprintf("Option[%q]", k), "%q is not an absolute path", p)
+		case hasControl(p):
+			e.add(fmt.Sprintf("Option[%q]", k), "%q holds a control character", p)
+		}
+	}
+	if d := c.Option.string(optionLogMaxAge, ""); d != "" {
+		if _, err := time.ParseDuration(d); err != nil {
+			e.add(fmt.Sprintf("Option[%q]", optionLogMaxAge), "%q is not a duration", d)
+		}
+	}
+	for _, k := range []string{optionLogLevel, optionRedirectStdoutLevel, optionRedirectStderrLevel} {
+		if l := c.Option.string(k, ""); l != "" {
+			if _, err := ParseLevel(l); err != nil {
+				e.add(fmt.Sprintf("Option[%q]", k), "%v", err)
+			}
+		}
+	}
+	if f := c.Option.string(optionConsoleFormat, ""); f != "" {
+		if _, err := parseConsoleFormat(f); err != nil {
+			e.add(fmt.Sprintf("Option[%q]", optionConsoleFormat), "%v", err)
+		}
+	}
+	if a := c.Option.string(optionSyslogAddress, ""); a != "" {
+		if _, _, err := parseSyslogAddress(a); err != nil {
+			e.add(fmt.Sprintf("Option[%q]", optionSyslogAddress), "%v", err)
+		}
+	}
+	if f := c.Option.string(optionSyslogFacility, ""); f != "" {
+		if _, ok := syslogFacilities[f]; !ok {
+			e.add(fmt.Sprintf("Option[%q]", optionSyslogFacility), "unknown facility %q", f)
+		}
+	}
+	if _, err := parseSyslogFormat(c.Option.string(optionSyslogFormat, "")); err != nil {
+		e.add(fmt.Sprintf("Option[%q]", optionSyslogFormat), "%v", err)
+	}
+	if c.Option.bool(optionLogRotate, false) && c.Option.bool(optionUserService, false) {
+		e.add(fmt.Sprintf("Option[%q]", optionLogRotate), "not supported for user services")
+	}
+	if !c.Option.bool(optionUserService, optionUserServiceDefault) {
+		for _, k := range []string{optionUserServiceFor, optionUserServiceGlobal, optionLinger} {
+			if _, ok := c.Option[k]; ok {
+				e.add(fmt.Sprintf("Option[%q]", k), "requires the UserService option")
+			}
+		}
+	}
+
+	if sup != nil {
+		if c.Option.bool(optionUserService, false) && !sup.userService {
+			e.add(fmt.Sprintf("Option[%q]", optionUserService), "user services are not supported by %s", system)
+		}
+		if sup.check != nil {
+			sup.check(c, e)
+		}
+	}
+	if len(e.Problems) > 0 {
+		return e
+	}
+	return nil
+}
+
+// configWarnings returns the fields and options of c that the system
+// described by sup ignores. Installing such a Config works, but the ignored
+// settings have no effect.
+func configWarnings(c *Config, system string, sup *systemSupport) []FieldError {
+	w := &ValidationError{System: system}
+	c = withOptions(c)
+	set := map[string]bool{
+		"UserName":         c.UserName != "",
+		"WorkingDirectory": c.WorkingDirectory != "",
+		"ChRoot":           c.ChRoot != "",
+		"Dependencies":     len(c.Dependencies) > 0,
+		"EnvVars":          len(c.EnvVars) > 0,
+		"Credentials":      len(c.Credentials) > 0,
+	}
+	for _, f := range []string{"UserName", "WorkingDirectory", "ChRoot", "Dependencies", "EnvVars", "Credentials"} {
+		if set[f] && !contains(sup.fields, f) {
+			w.add(f, "not supported by %s", system)
+		}
+	}
+	for _, k := range sortedKeys(c.Option) {
+		if _, known := optionKinds[k]; known && k != optionUserService && !contains(sup.options, k) {
+			w.add(fmt.Sprintf("Option[%q]", k), "not used by %s", system)
+		}
+	}
+	return w.Problems
+}
+
+// hasControl reports whether s holds a control character, such as a line
+// break.
+func hasControl(s string) bool {
+	for _, c := range s {
+		if c < 0x20 || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+const unixServiceNameRule = "may only hold letters, digits and _ . - @ and must not start with - or ."
+
+// unixServiceName checks that name is safe to use as a file and unit name.
+func unixServiceName(name string) string {
+	if name[0] == '-' || name[0] == '.' {
+		return unixServiceNameRule
+	}
+	for _, c := range name {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("_.-@", c):
+		default:
+			return unixServiceNameRule
+		}
+	}
+	return ""
+}
+
+// rcServiceName checks that name can prefix rc.conf variables, as FreeBSD's
+// rc.subr requires.
+func rcServiceName(name string) string {
+	for i, c := range name {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return "may only hold letters, digits and _ and must not start with a digit"
+		}
+	}
+	return ""
+}
+
+// signalNames are the signals a ReloadSignal may name, with or without the
+// SIG prefix.
+var signalNames = []string{
+	"HUP", "INT", "QUIT", "ILL", "TRAP", "ABRT", "BUS", "FPE", "KILL", "USR1",
+	"SEGV", "USR2", "PIPE", "ALRM", "TERM", "CHLD", "CONT", "STOP", "TSTP",
+	"TTIN", "TTOU", "URG", "XCPU", "XFSZ", "VTALRM", "PROF", "WINCH", "IO",
+	"PWR", "SYS",
+}
+
+// validSignal reports whether s is a signal name or number.
+func validSignal(s string) bool {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n > 0 && n < 65
+	}
+	return contains(signalNames, strings.TrimPrefix(strings.ToUpper(s), "SIG"))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,124 @@
+// Copyright 2015 Daniel Theophanes.
+// Use of this source code is governed by a zlib-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testSupport = systemSupport{
+	fields:  []string{"WorkingDirectory", "EnvVars"},
+	options: []string{optionLogDirectory, optionLimitNOFILE, optionLogMaxAge, optionLogLevel, optionConsoleFormat, optionRedirectOutput, optionRedirectStderrLevel, optionLogRotate, optionSyslogAddress, optionSyslogFacility, optionSyslogFormat},
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		fields []string
+	}{
+		{"valid", Config{Name: "demo", WorkingDirectory: "/srv", Option: KeyValue{optionLimitNOFILE: 10}}, nil},
+		{"no name", Config{}, []string{"Name"}},
+		{"name with spaces", Config{Name: "my service"}, []string{"Name"}},
+		{"relative paths", Config{Name: "demo", WorkingDirectory: "srv", Option: KeyValue{optionLogDirectory: "log"}}, []string{"WorkingDirectory", `Option["LogDirectory"]`}},
+		{"unsupported fields", Config{Name: "demo", ChRoot: "/jail", UserName: "nobody"}, nil},
+		{"unknown option", Config{Name: "demo", Option: KeyValue{"LimitNoFile": 10}}, []string{`Option["LimitNoFile"]`}},
+		{"wrong type", Config{Name: "demo", Option: KeyValue{optionLimitNOFILE: int64(10)}}, []string{`Option["LimitNOFILE"]`}},
+		{"unused option", Config{Name: "demo", Option: KeyValue{optionKeepAlive: true}}, nil},
+		{"user service", Config{Name: "demo", Option: KeyValue{optionUserService: true}}, []string{`Option["UserService"]`}},
+		{"user service off", Config{Name: "demo", Option: KeyValue{optionUserService: false}}, nil},
+		{"linger without user service", Config{Name: "demo", Option: KeyValue{optionLinger: true}}, []string{`Option["Linger"]`}},
+		{"log level", Config{Name: "demo", Option: KeyValue{optionLogLevel: "verbose"}}, []string{`Option["LogLevel"]`}},
+		{"redirect level", Config{Name: "demo", Option: KeyValue{optionRedirectOutput: true, optionRedirectStderrLevel: "loud"}}, []string{`Option["RedirectStderrLevel"]`}},
+		{"console format", Config{Name: "demo", Option: KeyValue{optionConsoleFormat: "yaml"}}, []string{`Option["ConsoleFormat"]`}},
+		{"log max age", Config{Name: "demo", Option: KeyValue{optionLogMaxAge: "a day"}}, []string{`Option["LogMaxAge"]`}},
+		{"log rotate for a user service", Config{Name: "demo", Option: KeyValue{optionLogRotate: true, optionUserService: true}}, []string{`Option["LogRotate"]`, `Option["UserService"]`}},
+		{"syslog", Config{Name: "demo", Option: KeyValue{optionSyslogAddress: "tls://logs:6514", optionSyslogFacility: "local0", optionSyslogFormat: "RFC3164"}}, nil},
+		{"syslog address", Config{Name: "demo", Option: KeyValue{optionSyslogAddress: "logs:514"}}, []string{`Option["SyslogAddress"]`}},
+		{"syslog facility and format", Config{Name: "demo", Option: KeyValue{optionSyslogFacility: "local9", optionSyslogFormat: "json"}}, []string{`Option["SyslogFacility"]`, `Option["SyslogFormat"]`}},
+		{"env name", Config{Name: "demo", EnvVars: map[string]string{"A-B": "x"}}, []string{`EnvVars["A-B"]`}},
+		{"credentials", Config{Name: "demo", Credentials: []CredentialConfig{{Name: "a/b", Value: "x"}}}, []string{"Credentials[0]"}},
+		{"control characters", Config{Name: "demo", Description: "demo\nExecStartPre=/bin/sh", UserName: "nobody\nUser=root", WorkingDirectory: "/srv\r", Dependencies: []string{"After=a.target\nExecStartPre=/bin/sh"}, Credentials: []CredentialConfig{{Name: "key\n", Path: "/etc/key\n"}}, Option: KeyValue{optionLogDirectory: "/var/log\n"}}, []string{"Description", "UserName", "WorkingDirectory", "Dependencies[0]", "Credentials[0]", "Credentials[0]", `Option["LogDirectory"]`}},
+		{"all at once", Config{Name: "a b", ChRoot: "/jail", Option: KeyValue{"Bogus": 1}}, []string{"Name", `Option["Bogus"]`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(&tt.config, "test", &testSupport)
+			var fields []string
+			var ve *ValidationError
+			if errors.As(err, &ve) {
+				for _, p := range ve.Problems {
+					fields = append(fields, p.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("validateConfig() = %v, want a *ValidationError", err)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("validateConfig() fields = %q, want %q (%v)", fields, tt.fields, err)
+			}
+		})
+	}
+}
+
+func TestConfigWarnings(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		fields []string
+	}{
+		{"none", Config{Name: "demo", WorkingDirectory: "/srv", Option: KeyValue{optionLimitNOFILE: 10}}, nil},
+		{"unsupported fields", Config{Name: "demo", ChRoot: "/jail", UserName: "nobody", Dependencies: []string{"network"}}, []string{"UserName", "ChRoot", "Dependencies"}},
+		{"unused options", Config{Name: "demo", Option: KeyValue{optionKeepAlive: true, optionRestart: "always"}}, []string{`Option["KeepAlive"]`, `Option["Restart"]`}},
+		{"typed options", Config{Name: "demo", Systemd: &SystemdOptions{DBus: true}}, []string{`Option["SystemdDBus"]`}},
+		{"unknown option", Config{Name: "demo", Option: KeyValue{"Bogus": 1}}, nil},
+		{"user service", Config{Name: "demo", Option: KeyValue{optionUserService: true}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, w := range configWarnings(&tt.config, "test", &testSupport) {
+				fields = append(fields, w.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("configWarnings() fields = %q, want %q", fields, tt.fields)
+			}
+			if err := validateConfig(&tt.config, "test", &testSupport); len(tt.fields) > 0 && err != nil {
+				t.Errorf("validateConfig() = %v, want only warnings", err)
+			}
+		})
+	}
+}
+
+func TestServiceNameRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		unix, rcd bool
+	}{
+		{"demo", true, true},
+		{"my_service2", true, true},
+		{"demo-app@1.service", true, false},
+		{"my service", false, false},
+		{".hidden", false, false},
+		{"2fast", true, false},
+	}
+	for _, tt := range tests {
+		if got := unixServiceName(tt.name) == ""; got != tt.unix {
+			t.Errorf("unixServiceName(%q) valid = %v, want %v", tt.name, got, tt.unix)
+		}
+		if got := rcServiceName(tt.name) == ""; got != tt.rcd {
+			t.Errorf("rcServiceName(%q) valid = %v, want %v", tt.name, got, tt.rcd)
+		}
+	}
+}
+
+func TestValidSignal(t *testing.T) {
+	for s, want := range map[string]bool{"HUP": true, "SIGUSR1": true, "usr2": true, "10": true, "0": false, "RELOAD": false} {
+		if got := validSignal(s); got != want {
+			t.Errorf("validSignal(%q) = %v, want %v", s, got, want)
+		}
+	}
+}